@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	zlog "github.com/rs/zerolog/log"
+	"github.com/vayzur/apadana/pkg/chapar/backup"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+	"github.com/vayzur/apadana/pkg/labels"
+)
+
+// backupCluster writes all nodes, inbounds and users to an archive. The
+// archive holds user credentials in plain text.
+func backupCluster(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	out := fs.String("out", "", "Archive to write, - for stdout")
+	fs.Parse(args)
+
+	if *out == "" {
+		zlog.Fatal().
+			Str("component", "backup").
+			Msg("-out is required")
+	}
+
+	cfg := loadConfig(*configPath)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	store, closeStorage, err := openStorage(ctx, cfg)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "storage").
+			Msg("failed to open")
+	}
+	defer closeStorage()
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.OpenFile(*out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			zlog.Fatal().
+				Err(err).
+				Str("component", "backup").
+				Str("path", *out).
+				Msg("failed to create archive")
+		}
+		defer f.Close()
+		w = f
+	}
+
+	count, err := backup.Backup(ctx, encryptSecrets(store, cfg), w)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "backup").
+			Int("objects", count).
+			Msg("backup failed")
+	}
+
+	zlog.Info().
+		Str("component", "backup").
+		Str("path", *out).
+		Int("objects", count).
+		Msg("backup complete")
+}
+
+func restoreCluster(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	in := fs.String("in", "", "Archive to read, - for stdin")
+	nodes := fs.String("nodes", "", "Comma separated nodes to restore, all when empty")
+	selector := fs.String("selector", "", "Only restore nodes matching this label selector, e.g. region in (eu,us),provider!=ovh")
+	onConflict := fs.String("on-conflict", string(backup.ConflictSkip), "What to do with objects that already exist: skip, overwrite or fail")
+	dryRun := fs.Bool("dry-run", false, "Only report what would be restored")
+	fs.Parse(args)
+
+	if *in == "" {
+		zlog.Fatal().
+			Str("component", "restore").
+			Msg("-in is required")
+	}
+
+	sel, err := labels.Parse(*selector)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "restore").
+			Msg("invalid selector")
+	}
+
+	policy := backup.ConflictPolicy(*onConflict)
+	switch policy {
+	case backup.ConflictSkip, backup.ConflictOverwrite, backup.ConflictFail:
+	default:
+		zlog.Fatal().
+			Str("component", "restore").
+			Str("onConflict", *onConflict).
+			Msg("unknown conflict policy")
+	}
+
+	opts := backup.RestoreOptions{
+		Selector:   sel,
+		OnConflict: policy,
+		DryRun:     *dryRun,
+	}
+	if *nodes != "" {
+		opts.Nodes = strings.Split(*nodes, ",")
+	}
+
+	cfg := loadConfig(*configPath)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	store, closeStorage, err := openStorage(ctx, cfg)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "storage").
+			Msg("failed to open")
+	}
+	defer closeStorage()
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			zlog.Fatal().
+				Err(err).
+				Str("component", "restore").
+				Str("path", *in).
+				Msg("failed to open archive")
+		}
+		defer f.Close()
+		r = f
+	}
+
+	nodeStore := resources.NewNodeStore(store)
+	inboundStore := resources.NewInboundStore(encryptSecrets(store, cfg))
+
+	result, err := backup.Restore(ctx, r, nodeStore, inboundStore, opts)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "restore").
+			Interface("result", result).
+			Msg("restore failed")
+	}
+
+	zlog.Info().
+		Str("component", "restore").
+		Bool("dryRun", *dryRun).
+		Interface("result", result).
+		Msg("restore complete")
+}
@@ -9,74 +9,132 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/gofiber/fiber/v3"
 	"github.com/vayzur/apadana/internal/chapar/server"
 	"github.com/vayzur/apadana/internal/config"
+	"github.com/vayzur/apadana/pkg/chapar/audit"
 	chaparconfigv1 "github.com/vayzur/apadana/pkg/chapar/config/v1"
+	"github.com/vayzur/apadana/pkg/chapar/metrics"
+	"github.com/vayzur/apadana/pkg/chapar/ratelimit"
 	"github.com/vayzur/apadana/pkg/chapar/service"
+	"github.com/vayzur/apadana/pkg/chapar/storage/cacher"
 
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
-	"github.com/vayzur/apadana/pkg/chapar/storage/etcd"
 	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
 )
 
 func main() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 
-	configPath := flag.String("config", "", "Path to config file")
-	flag.Parse()
-
-	cfg := &chaparconfigv1.ChaparConfig{}
-	if err := config.Load(*configPath, cfg); err != nil {
-		zlog.Fatal().
-			Err(err).
-			Str("component", "config").
-			Str("path", *configPath).
-			Msg("failed to load configuration")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rotate-keys":
+			rotateKeys(os.Args[2:])
+			return
+		case "migrate-prefix":
+			migratePrefix(os.Args[2:])
+			return
+		case "migrate":
+			migrate(os.Args[2:])
+			return
+		case "backup":
+			backupCluster(os.Args[2:])
+			return
+		case "restore":
+			restoreCluster(os.Args[2:])
+			return
+		case "reindex":
+			reindex(os.Args[2:])
+			return
+		}
 	}
 
+	serve(os.Args[1:])
+}
+
+func serve(args []string) {
+	fs := flag.NewFlagSet("chapar", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	fs.Parse(args)
+
+	cfg := loadConfig(*configPath)
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	etcdClient, err := etcd.NewClient(&cfg.Etcd, ctx)
+	store, closeStorage, err := openStorage(ctx, cfg)
 	if err != nil {
 		zlog.Fatal().
 			Err(err).
-			Str("component", "etcd").
-			Msg("failed to connect")
+			Str("component", "storage").
+			Msg("failed to open")
 	}
-	defer func() {
-		zlog.Info().
-			Str("component", "etcd").
-			Msg("closing client")
-		if err := etcdClient.Close(); err != nil {
-			zlog.Error().
+	defer closeStorage()
+
+	if cfg.Storage.Backend != chaparconfigv1.StorageBackendMemory && !cfg.Storage.DisableWatchCache {
+		c := cacher.New(store, "/", resources.AuditPrefix)
+		go c.Run(ctx)
+		store = c
+	}
+
+	ports := resources.DefaultPortRange
+	if cfg.PortRange != "" {
+		if ports, err = resources.ParsePortRange(cfg.PortRange); err != nil {
+			zlog.Fatal().
 				Err(err).
-				Str("component", "etcd").
-				Msg("client close error")
+				Str("component", "config").
+				Msg("invalid port range")
 		}
-	}()
+	}
 
-	etcdStorage := etcd.NewEtcdStorage(etcdClient)
-	if err := etcdStorage.ReadinessCheck(); err != nil {
-		zlog.Error().
-			Err(err).
-			Str("component", "etcd").
-			Msg("readiness check failed: not ready")
+	auth := server.AuthConfig{
+		Token:          cfg.Token,
+		NonceCacheSize: cfg.NonceCacheSize,
+	}
+	if cfg.LegacyHMACUntil != "" {
+		if auth.LegacyUntil, err = time.Parse(time.RFC3339, cfg.LegacyHMACUntil); err != nil {
+			zlog.Fatal().
+				Err(err).
+				Str("component", "config").
+				Msg("invalid legacyHMACUntil")
+		}
 	}
 
-	inboundStore := resources.NewInboundStore(etcdStorage)
-	nodeStore := resources.NewNodeStore(etcdStorage)
-	nodeService := service.NewNodeService(nodeStore)
-	inboundService := service.NewInboundService(inboundStore)
+	secrets := encryptSecrets(store, cfg)
+	inboundStore := resources.NewInboundStore(secrets)
+	nodeStore := resources.NewNodeStore(store)
+	credentialStore := resources.NewCredentialStore(secrets)
+	nodeService := service.NewNodeService(nodeStore, inboundStore)
+	inboundService := service.NewInboundService(inboundStore, nodeStore, ports)
+	credentialService := service.NewCredentialService(credentialStore)
+
+	var auditStore *resources.AuditStore
+	if cfg.Audit.Store {
+		auditStore = resources.NewAuditStore(store, cfg.Audit.Retention)
+	}
+	auditService := service.NewAuditService(auditStore)
+	auditLogger := openAudit(cfg, auditStore)
+	if auditLogger != nil {
+		// registered before the server shuts down, so it runs after
+		defer auditLogger.Close()
+	}
+
+	metricsConfig := server.MetricsConfig{Enabled: cfg.Metrics.Enabled, Token: cfg.Metrics.Token}
+	if metricsConfig.Enabled {
+		metrics.RegisterObjects(nodeStore, inboundStore)
+		if auditLogger != nil {
+			metrics.RegisterAuditDropped(auditLogger.Dropped)
+		}
+	}
 
 	serverAddr := fmt.Sprintf("%s:%d", cfg.Address, cfg.Port)
-	app := server.NewServer(serverAddr, cfg.Token, cfg.Prefork, inboundService, nodeService)
+	app := server.NewServer(serverAddr, auth, rateLimits(cfg), metricsConfig, cfg.Prefork, inboundService, nodeService, credentialService, auditService, auditLogger)
 
 	go func() {
 		var err error
 		if cfg.TLS.Enabled {
-			err = app.StartTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			err = app.StartTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile, cfg.TLS.RequireClientCert)
 		} else {
 			err = app.Start()
 		}
@@ -116,3 +174,79 @@ func main() {
 
 	<-ctx.Done()
 }
+
+// openAudit returns the logger for the configured audit sinks, or nil when
+// there are none.
+func openAudit(cfg *chaparconfigv1.ChaparConfig, store *resources.AuditStore) *audit.Logger {
+	sinks := []audit.Sink{}
+	if path := cfg.Audit.Path; path != "" {
+		// prefork children would race each other rotating one file
+		if fiber.IsChild() {
+			path = fmt.Sprintf("%s.%d", path, os.Getpid())
+		}
+		file, err := audit.NewFileSink(path, cfg.Audit.MaxSizeMB, cfg.Audit.MaxBackups)
+		if err != nil {
+			zlog.Fatal().
+				Err(err).
+				Str("component", "audit").
+				Str("path", path).
+				Msg("failed to open")
+		}
+		sinks = append(sinks, file)
+	}
+	if store != nil {
+		sinks = append(sinks, audit.NewStoreSink(store))
+	}
+
+	if len(sinks) == 0 {
+		return nil
+	}
+	return audit.NewLogger(sinks...)
+}
+
+// rateLimits returns the configured limits, with defaults for the unset
+// ones. Without rateLimit.enabled nothing is limited.
+func rateLimits(cfg *chaparconfigv1.ChaparConfig) server.RateLimits {
+	rl := cfg.RateLimit
+	if !rl.Enabled {
+		return server.RateLimits{}
+	}
+
+	limiter := func(budget chaparconfigv1.RateLimit, rate float64, burst int) *ratelimit.Limiter {
+		if budget.RPS <= 0 {
+			budget.RPS = rate
+		}
+		if budget.Burst <= 0 {
+			budget.Burst = burst
+		}
+		return ratelimit.NewLimiter(budget.RPS, budget.Burst)
+	}
+	if rl.MaxAuthFailures <= 0 {
+		rl.MaxAuthFailures = ratelimit.DefaultMaxAuthFailures
+	}
+	if rl.AuthFailureWindow <= 0 {
+		rl.AuthFailureWindow = ratelimit.DefaultAuthFailureWindow
+	}
+	if rl.LockoutDuration <= 0 {
+		rl.LockoutDuration = ratelimit.DefaultLockoutDuration
+	}
+
+	return server.RateLimits{
+		IP:      limiter(rl.IP, ratelimit.DefaultIPRate, ratelimit.DefaultIPBurst),
+		Agent:   limiter(rl.Agent, ratelimit.DefaultAgentRate, ratelimit.DefaultAgentBurst),
+		Admin:   limiter(rl.Admin, ratelimit.DefaultAdminRate, ratelimit.DefaultAdminBurst),
+		Lockout: ratelimit.NewLockout(rl.MaxAuthFailures, rl.AuthFailureWindow, rl.LockoutDuration),
+	}
+}
+
+func loadConfig(configPath string) *chaparconfigv1.ChaparConfig {
+	cfg := &chaparconfigv1.ChaparConfig{}
+	if err := config.Load(configPath, cfg); err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "config").
+			Str("path", configPath).
+			Msg("failed to load configuration")
+	}
+	return cfg
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	zlog "github.com/rs/zerolog/log"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+)
+
+// migrate rewrites every stored object written by an older schema version or
+// in another encoding in the current storage version and configured encoding.
+// chapar reads both either way, so it can run while the server is up.
+func migrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	fs.Parse(args)
+
+	cfg := loadConfig(*configPath)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	store, closeStorage, err := openStorage(ctx, cfg)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "storage").
+			Msg("failed to open")
+	}
+	defer closeStorage()
+
+	migrated, err := resources.Migrate(ctx, encryptSecrets(store, cfg))
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "storage").
+			Int("migrated", migrated).
+			Msg("migration failed")
+	}
+
+	zlog.Info().
+		Str("component", "storage").
+		Int("migrated", migrated).
+		Msg("migration complete")
+}
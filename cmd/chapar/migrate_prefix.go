@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	zlog "github.com/rs/zerolog/log"
+	"github.com/vayzur/apadana/pkg/chapar/storage/etcd"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+)
+
+// migratePrefix moves the resources stored under the -from root to the
+// prefix configured in etcd.prefix. Stop chapar and spasaka before running it.
+func migratePrefix(args []string) {
+	fs := flag.NewFlagSet("migrate-prefix", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	from := fs.String("from", "", "Prefix the keys are currently stored under")
+	deleteSource := fs.Bool("delete", false, "Delete the keys under the old prefix once copied")
+	fs.Parse(args)
+
+	cfg := loadConfig(*configPath)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	etcdCfg := cfg.Etcd
+	etcdCfg.Prefix = ""
+
+	etcdClient, err := etcd.NewClient(&etcdCfg, ctx)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "etcd").
+			Msg("failed to connect")
+	}
+	defer etcdClient.Close()
+
+	moved, skipped, err := etcd.MigratePrefix(ctx, etcdClient, *from, cfg.Etcd.Prefix, resources.Prefixes, *deleteSource)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "etcd").
+			Int("moved", moved).
+			Int("skipped", skipped).
+			Msg("prefix migration failed")
+	}
+
+	zlog.Info().
+		Str("component", "etcd").
+		Str("from", *from).
+		Str("to", cfg.Etcd.Prefix).
+		Int("moved", moved).
+		Int("skipped", skipped).
+		Msg("prefix migration complete")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	zlog "github.com/rs/zerolog/log"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+)
+
+// reindex rebuilds the user email index from the stored users, for data
+// written before the index existed or an index that drifted.
+func reindex(args []string) {
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	fs.Parse(args)
+
+	cfg := loadConfig(*configPath)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	store, closeStorage, err := openStorage(ctx, cfg)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "storage").
+			Msg("failed to open")
+	}
+	defer closeStorage()
+
+	added, removed, err := resources.Reindex(ctx, encryptSecrets(store, cfg))
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "storage").
+			Int("added", added).
+			Int("removed", removed).
+			Msg("reindex failed")
+	}
+
+	zlog.Info().
+		Str("component", "storage").
+		Int("added", added).
+		Int("removed", removed).
+		Msg("reindex complete")
+}
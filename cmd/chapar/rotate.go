@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	zlog "github.com/rs/zerolog/log"
+	"github.com/vayzur/apadana/pkg/chapar/storage/encryption"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+)
+
+// rotateKeys re-encrypts everything under resources.EncryptedPrefixes, the
+// stored inbound users and credentials, with the primary key. Run it after
+// putting a new key first in the config and restarting chapar; the old key
+// can be removed from the config once it finishes.
+func rotateKeys(args []string) {
+	fs := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	fs.Parse(args)
+
+	cfg := loadConfig(*configPath)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	keys, err := encryption.NewKeySet(&cfg.Encryption)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "encryption").
+			Msg("invalid key set")
+	}
+
+	store, closeStorage, err := openStorage(ctx, cfg)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "storage").
+			Msg("failed to open")
+	}
+	defer closeStorage()
+
+	rewritten, err := encryption.NewStore(store, keys, resources.EncryptedPrefixes...).Rotate(ctx)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "encryption").
+			Int("rewritten", rewritten).
+			Msg("rotation failed")
+	}
+
+	zlog.Info().
+		Str("component", "encryption").
+		Str("primaryKey", cfg.Encryption.Keys[0].Name).
+		Int("rewritten", rewritten).
+		Msg("rotation complete")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	zlog "github.com/rs/zerolog/log"
+	chaparconfigv1 "github.com/vayzur/apadana/pkg/chapar/config/v1"
+	"github.com/vayzur/apadana/pkg/chapar/metrics"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/chapar/storage/encryption"
+	"github.com/vayzur/apadana/pkg/chapar/storage/etcd"
+	"github.com/vayzur/apadana/pkg/chapar/storage/memory"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+)
+
+// openStorage connects the configured backend and selects the encoding
+// objects are written in. The returned function closes it and must be called
+// once the storage is no longer used.
+func openStorage(ctx context.Context, cfg *chaparconfigv1.ChaparConfig) (storage.Interface, func(), error) {
+	if err := resources.SetEncoding(cfg.Storage.Encoding); err != nil {
+		return nil, nil, err
+	}
+
+	switch cfg.Storage.Backend {
+	case chaparconfigv1.StorageBackendMemory:
+		if cfg.Prefork {
+			return nil, nil, fmt.Errorf("memory backend cannot be used with prefork")
+		}
+		zlog.Warn().
+			Str("component", "storage").
+			Msg("using in-memory backend, state will be lost on restart")
+		return memory.NewMemoryStorage(ctx), func() {}, nil
+
+	case "", chaparconfigv1.StorageBackendEtcd:
+		etcdClient, err := etcd.NewClient(&cfg.Etcd, ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("etcd connect failed: %w", err)
+		}
+		closeFn := func() {
+			zlog.Info().
+				Str("component", "etcd").
+				Msg("closing client")
+			if err := etcdClient.Close(); err != nil {
+				zlog.Error().
+					Err(err).
+					Str("component", "etcd").
+					Msg("client close error")
+			}
+		}
+
+		etcdStorage := etcd.NewEtcdStorage(etcdClient)
+		if err := etcdStorage.ReadinessCheck(); err != nil {
+			zlog.Error().
+				Err(err).
+				Str("component", "etcd").
+				Msg("readiness check failed: not ready")
+		}
+		if cfg.Metrics.Enabled {
+			return metrics.InstrumentEtcd(etcdStorage), closeFn, nil
+		}
+		return etcdStorage, closeFn, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+}
+
+// encryptSecrets wraps store so that inbound users and credentials are
+// encrypted with the configured keys. Without keys store is returned as is.
+func encryptSecrets(store storage.Interface, cfg *chaparconfigv1.ChaparConfig) storage.Interface {
+	if len(cfg.Encryption.Keys) == 0 {
+		return store
+	}
+
+	keys, err := encryption.NewKeySet(&cfg.Encryption)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "encryption").
+			Msg("invalid key set")
+	}
+	return encryption.NewStore(store, keys, resources.EncryptedPrefixes...)
+}
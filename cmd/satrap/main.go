@@ -11,6 +11,7 @@ import (
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
 	"github.com/vayzur/apadana/internal/config"
+	authv1 "github.com/vayzur/apadana/pkg/apis/auth/v1"
 	corev1 "github.com/vayzur/apadana/pkg/apis/core/v1"
 	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
 	satrapconfigv1 "github.com/vayzur/apadana/pkg/satrap/config/v1"
@@ -57,10 +58,19 @@ func main() {
 		}
 	}()
 
+	clientOpts, err := apadana.ClusterOptions(&cfg.Cluster)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "apadana").
+			Msg("invalid cluster tls config")
+	}
+
 	apadanaClient := apadana.New(
 		cfg.Cluster.Server,
 		cfg.Cluster.Token,
 		time.Second*5,
+		clientOpts...,
 	)
 
 	nodeName := cfg.GetName()
@@ -70,7 +80,33 @@ func main() {
 			Msg("node name unavailable: cfg.Name not set and system hostname lookup failed")
 	}
 
-	if cfg.RegisterNode {
+	credentialFile := cfg.GetCredentialFile()
+	credential, err := satrapRegisterManager.LoadCredential(credentialFile)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "registerManager").
+			Str("path", credentialFile).
+			Msg("failed to load credential")
+	}
+
+	join := false
+	switch {
+	case credential != nil:
+		apadanaClient.SetCredential(credential.Metadata.Name, credential.Spec.Token)
+	case cfg.BootstrapToken != "":
+		name, token, err := satrapRegisterManager.ParseBootstrapToken(cfg.BootstrapToken)
+		if err != nil {
+			zlog.Fatal().
+				Err(err).
+				Str("component", "registerManager").
+				Msg("invalid bootstrap token")
+		}
+		apadanaClient.SetCredential(name, token)
+		join = true
+	}
+
+	if cfg.RegisterNode || join {
 		registerManager := satrapRegisterManager.NewRegisterManager(
 			apadanaClient,
 		)
@@ -93,9 +129,39 @@ func main() {
 		}
 
 		rlock := flock.NewFlock("/tmp/satrap-register-manager.lock")
-		if err := rlock.TryLock(); err == nil {
+		locked := rlock.TryLock() == nil
+		if !locked && join {
+			// the bootstrap token is only accepted once, so the credential
+			// the process holding the lock joins with is taken over
+			credential, locked, err = awaitJoin(ctx, rlock, credentialFile)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				zlog.Fatal().
+					Err(err).
+					Str("component", "registerManager").
+					Str("path", credentialFile).
+					Msg("failed to load credential")
+			}
+			if credential != nil {
+				apadanaClient.SetCredential(credential.Metadata.Name, credential.Spec.Token)
+				join = false
+			}
+		}
+		if locked {
 			// block until register node
-			registerManager.RegisterWithAPIServer(ctx, node)
+			if join {
+				if err := registerManager.JoinWithAPIServer(ctx, node, credentialFile); err != nil && ctx.Err() == nil {
+					zlog.Fatal().
+						Err(err).
+						Str("component", "registerManager").
+						Str("nodeName", nodeName).
+						Msg("failed to join cluster")
+				}
+			} else if cfg.RegisterNode {
+				registerManager.RegisterWithAPIServer(ctx, node)
+			}
 			defer rlock.Unlock()
 		}
 	}
@@ -139,3 +205,29 @@ func main() {
 		Msg("started successfully")
 	<-ctx.Done()
 }
+
+// awaitJoin waits for another process, which holds lock, to join the cluster
+// and returns the credential it saved to credentialFile. Should that process
+// give up and release lock first, it is taken and reported, along with the
+// credential if one was saved after all.
+func awaitJoin(ctx context.Context, lock *flock.Flock, credentialFile string) (*authv1.Credential, bool, error) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		credential, err := satrapRegisterManager.LoadCredential(credentialFile)
+		if err != nil || credential != nil {
+			return credential, false, err
+		}
+		if lock.TryLock() == nil {
+			credential, err := satrapRegisterManager.LoadCredential(credentialFile)
+			return credential, true, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
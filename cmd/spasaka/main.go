@@ -76,7 +76,15 @@ func main() {
 		}
 	}()
 
-	apadanaClient := apadana.New(cfg.Cluster.Server, cfg.Cluster.Token, time.Second*5)
+	clientOpts, err := apadana.ClusterOptions(&cfg.Cluster)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Str("component", "apadana").
+			Msg("invalid cluster tls config")
+	}
+
+	apadanaClient := apadana.New(cfg.Cluster.Server, cfg.Cluster.Token, time.Second*5, clientOpts...)
 	spasakaManager := controller.NewSpasaka(apadanaClient)
 
 	val := "spasaka"
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"testing"
+	"time"
+
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+	satrapv1 "github.com/vayzur/apadana/pkg/apis/satrap/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+)
+
+const users = 100_000
+
+// Measures the cost of decoding a list of 100k stored inbound users in each
+// encoding, as done when listing the users of an inbound.
+func main() {
+	codec := resources.Codec()
+
+	for _, encoding := range []string{"json", "cbor"} {
+		if err := resources.SetEncoding(encoding); err != nil {
+			log.Fatal(err)
+		}
+
+		values := make([][]byte, users)
+		size := 0
+		for i := range values {
+			data, err := codec.Encode(satrapv1.KindInboundUser, newUser(i))
+			if err != nil {
+				log.Fatal(err)
+			}
+			values[i] = data
+			size += len(data)
+		}
+
+		result := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				list := make([]*satrapv1.InboundUser, 0, users)
+				for _, data := range values {
+					user := &satrapv1.InboundUser{}
+					if _, err := codec.Decode(satrapv1.KindInboundUser, data, user); err != nil {
+						b.Fatal(err)
+					}
+					list = append(list, user)
+				}
+			}
+		})
+
+		fmt.Printf("%-5s %8d bytes/user %12s/list %10d allocs/list\n",
+			encoding,
+			size/users,
+			time.Duration(result.NsPerOp()),
+			result.AllocsPerOp(),
+		)
+	}
+}
+
+func newUser(i int) *satrapv1.InboundUser {
+	email := fmt.Sprintf("user-%06d@example.com", i)
+	account, _ := json.Marshal(&satrapv1.VlessAccount{
+		ID:   fmt.Sprintf("5783a3e7-e373-51cd-8642-c83782%06d", i),
+		Flow: "xtls-rprx-vision",
+	})
+	expiresAt := time.Now().Add(30 * 24 * time.Hour)
+
+	return &satrapv1.InboundUser{
+		Metadata: metav1.ObjectMeta{
+			Name:              email,
+			UID:               fmt.Sprintf("0b6f1c2e-7d3a-4c8e-9f21-5a6b7c%06d", i),
+			CreationTimestamp: time.Now(),
+			Labels: map[string]string{
+				"plan": "monthly",
+			},
+		},
+		Spec: satrapv1.InboundUserSpec{
+			Type:       "vless",
+			InboundTag: "vless-in",
+			Email:      email,
+			Account:    account,
+			TTL:        30 * 24 * time.Hour,
+			ExpiresAt:  &expiresAt,
+		},
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	zlog "github.com/rs/zerolog/log"
+	auditv1 "github.com/vayzur/apadana/pkg/apis/audit/v1"
+	"github.com/vayzur/apadana/pkg/chapar/audit"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// auditMiddleware records every mutating request once it has been answered,
+// including those refused by authentication or authorization.
+func (s *Server) auditMiddleware(c fiber.Ctx) error {
+	switch c.Method() {
+	case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+	default:
+		return c.Next()
+	}
+
+	start := time.Now()
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		status = fiber.StatusInternalServerError
+		var fe *fiber.Error
+		if errors.As(err, &fe) {
+			status = fe.Code
+		}
+	}
+
+	// the event outlives the request, whose buffers fiber reuses
+	path := strings.Clone(c.Path())
+	event := &auditv1.Event{
+		SourceIP: strings.Clone(c.IP()),
+		Method:   strings.Clone(c.Method()),
+		Path:     path,
+		Object:   objectRef(path, c.Body()),
+		Status:   status,
+		Latency:  time.Since(start),
+	}
+	event.Metadata.CreationTimestamp = start
+	if id := requestIdentity(c); id != nil {
+		event.User = auditv1.User{Name: id.name, Role: string(id.role)}
+		event.Route = c.Route().Path
+	}
+	if body := c.Body(); len(body) > 0 {
+		event.RequestBody, _ = audit.RedactBody(body)
+		event.RequestBodyOmitted = event.RequestBody == nil
+	}
+
+	s.audit.Record(event)
+	return err
+}
+
+// objectRef tells the object a request is about from its path under /api/v1
+// and, for creates, the name in its body.
+func objectRef(path string, body []byte) auditv1.ObjectRef {
+	parts := strings.Split(strings.TrimPrefix(path, "/api/v1/"), "/")
+	ref := auditv1.ObjectRef{Resource: parts[0]}
+
+	switch parts[0] {
+	case "users:batch":
+		ref.Resource = "inboundUsers"
+	case "credentials":
+		ref.Name = part(parts, 1)
+	case "nodes":
+		ref.NodeName = part(parts, 1)
+		ref.Name = ref.NodeName
+		if part(parts, 2) == "inbounds" {
+			ref.Resource = "inbounds"
+			ref.Tag = part(parts, 3)
+			ref.Name = ref.Tag
+			if strings.HasPrefix(part(parts, 4), "users") {
+				ref.Resource = "inboundUsers"
+				ref.Name = part(parts, 5)
+			}
+		}
+	}
+
+	if ref.Name == "" && len(body) > 0 {
+		ref.Name = createdName(body)
+		switch ref.Resource {
+		case "nodes":
+			ref.NodeName = ref.Name
+		case "inbounds":
+			ref.Tag = ref.Name
+		}
+	}
+	return ref
+}
+
+// createdName returns the name of the object a create request carries: the
+// tag of an inbound, the email of a user, or else its metadata.name.
+func createdName(body []byte) string {
+	obj := struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			Email  string `json:"email"`
+			Config struct {
+				Tag string `json:"tag"`
+			} `json:"config"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return ""
+	}
+	switch {
+	case obj.Spec.Config.Tag != "":
+		return obj.Spec.Config.Tag
+	case obj.Spec.Email != "":
+		return obj.Spec.Email
+	}
+	return obj.Metadata.Name
+}
+
+func part(parts []string, i int) string {
+	if i < len(parts) {
+		return parts[i]
+	}
+	return ""
+}
+
+func (s *Server) GetAuditEvents(c fiber.Ctx) error {
+	listOpts, err := listOptions(c)
+	if err != nil {
+		return errs.HandleAPIError(c, err)
+	}
+
+	opts := auditv1.ListOptions{
+		ListOptions: listOpts,
+		NodeName:    c.Query("nodeName"),
+		Resource:    c.Query("resource"),
+	}
+	if opts.Since, err = queryTime(c, "since"); err != nil {
+		return errs.HandleAPIError(c, err)
+	}
+	if opts.Until, err = queryTime(c, "until"); err != nil {
+		return errs.HandleAPIError(c, err)
+	}
+
+	events, err := s.auditService.GetEvents(readContext(c), opts)
+	if err != nil {
+		return errs.HandleAPIError(c, err)
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", "auditEvents").Str("action", "list").Int("count", len(events.Items)).Msg("retrieved")
+	return c.Status(http.StatusOK).JSON(events)
+}
+
+func queryTime(c fiber.Ctx, key string) (*time.Time, error) {
+	v := c.Query(key)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return nil, errs.ErrInvalidTimeRange
+	}
+	return &t, nil
+}
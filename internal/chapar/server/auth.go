@@ -0,0 +1,199 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	zlog "github.com/rs/zerolog/log"
+	authv1 "github.com/vayzur/apadana/pkg/apis/auth/v1"
+	"github.com/vayzur/apadana/pkg/chapar/authentication"
+	"github.com/vayzur/apadana/pkg/chapar/metrics"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+type localsKey int
+
+const identityKey localsKey = iota
+
+// identity is who signed a request.
+type identity struct {
+	name     string
+	role     authv1.Role
+	nodeName string
+	// credential is nil for the cluster token
+	credential *authv1.Credential
+}
+
+// clusterIdentity signs with the token of the config file.
+var clusterIdentity = &identity{role: authv1.RoleAdmin}
+
+// AuthConfig is how requests are authenticated.
+type AuthConfig struct {
+	// Token signs requests that name no credential, as an admin. Empty
+	// accepts named credentials only.
+	Token string
+	// LegacyUntil is until when requests signed with the legacy scheme are
+	// accepted. The zero time rejects them.
+	LegacyUntil time.Time
+	// NonceCacheSize bounds the nonces remembered to reject replays.
+	NonceCacheSize int
+}
+
+func (s *Server) authMiddleware(c fiber.Ctx) error {
+	h := c.Get("Authorization")
+	if h == "" {
+		id := certIdentity(c)
+		if id == nil {
+			return unauthorized("missing")
+		}
+		c.Locals(identityKey, id)
+		return c.Next()
+	}
+
+	sig, err := authentication.ParseHeader(h)
+	if err != nil {
+		return unauthorized("malformed")
+	}
+	// the credential names the identity, which the audit log keeps past the
+	// request, whose buffers fiber reuses
+	sig.Credential = strings.Clone(sig.Credential)
+
+	now := time.Now()
+	if sig.Legacy && !now.Before(s.auth.LegacyUntil) {
+		return unauthorized("legacy")
+	}
+
+	id := clusterIdentity
+	token := s.auth.Token
+	if sig.Credential != "" {
+		credential, err := s.credentialService.Authenticate(c.RequestCtx(), sig.Credential)
+		if err != nil {
+			if !errors.Is(err, errs.ErrCredentialNotFound) {
+				zlog.Error().Err(err).Str("component", "chapar").Str("credential", sig.Credential).Msg("authentication failed")
+			}
+			return unauthorized("credential")
+		}
+		id = &identity{
+			name:       sig.Credential,
+			role:       credential.Spec.Role,
+			nodeName:   credential.Spec.NodeName,
+			credential: credential,
+		}
+		token = credential.Spec.Token
+	}
+	if token == "" {
+		return unauthorized("credential")
+	}
+
+	req := &authentication.Request{
+		Method: c.Method(),
+		URI:    c.OriginalURL(),
+		Body:   c.BodyRaw(),
+	}
+	if err := sig.Verify(token, req, now); err != nil {
+		return unauthorized("signature")
+	}
+	if !sig.Legacy && s.nonces.Seen(sig.Credential, sig.Nonce, now) {
+		zlog.Warn().Str("component", "chapar").Str("credential", sig.Credential).Str("method", c.Method()).Str("path", c.Path()).Msg("replayed request rejected")
+		return unauthorized("replay")
+	}
+	if sig.Legacy {
+		zlog.Warn().Str("component", "chapar").Str("credential", sig.Credential).Str("method", c.Method()).Str("path", c.Path()).Msg("legacy signature accepted")
+	}
+
+	c.Locals(identityKey, id)
+	return c.Next()
+}
+
+// unauthorized counts a failed authentication, for reason, and refuses the
+// request.
+func unauthorized(reason string) error {
+	metrics.AuthFailure(reason)
+	return fiber.ErrUnauthorized
+}
+
+// certIdentity returns who the verified client certificate of the
+// connection names, or nil without one. The role is taken from the first
+// organization that names one, and the name from the common name or else the
+// first DNS name. Certificates cannot hold the bootstrap role.
+func certIdentity(c fiber.Ctx) *identity {
+	state := c.RequestCtx().TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := state.PeerCertificates[0]
+
+	id := &identity{name: cert.Subject.CommonName}
+	if id.name == "" && len(cert.DNSNames) > 0 {
+		id.name = cert.DNSNames[0]
+	}
+	for _, o := range cert.Subject.Organization {
+		if role := authv1.Role(o); role != authv1.RoleBootstrap && slices.Contains(authv1.Roles, role) {
+			id.role = role
+			break
+		}
+	}
+	if id.name == "" || id.role == "" {
+		zlog.Warn().Str("component", "chapar").Str("subject", cert.Subject.String()).Msg("client certificate names no identity")
+		return nil
+	}
+
+	if id.role == authv1.RoleNode {
+		id.nodeName = id.name
+	}
+	return id
+}
+
+// authorize lets admins and the given roles through to the route. A node
+// agent is only let through to the subtree of its own node.
+func (s *Server) authorize(roles ...authv1.Role) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		id := requestIdentity(c)
+		if id == nil {
+			return fiber.ErrUnauthorized
+		}
+
+		if id.role == authv1.RoleAdmin {
+			return c.Next()
+		}
+		if slices.Contains(roles, id.role) && (id.role != authv1.RoleNode || ownNode(c, id)) {
+			return c.Next()
+		}
+
+		zlog.Warn().Str("component", "chapar").Str("credential", id.name).Str("role", string(id.role)).Str("method", c.Method()).Str("path", c.Path()).Msg("forbidden")
+		return fiber.ErrForbidden
+	}
+}
+
+func requestIdentity(c fiber.Ctx) *identity {
+	id, _ := c.Locals(identityKey).(*identity)
+	return id
+}
+
+// ownNode reports whether the request is about the node id is bound to: the
+// one in the path or, when registering a node, the one in the body.
+func ownNode(c fiber.Ctx, id *identity) bool {
+	if nodeName := c.Params("nodeName"); nodeName != "" {
+		return nodeName == id.nodeName
+	}
+
+	name := bodyName(c.Body())
+	return name != "" && name == id.nodeName
+}
+
+// bodyName returns the metadata.name of the object in body, if any.
+func bodyName(body []byte) string {
+	obj := struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	}{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return ""
+	}
+	return obj.Metadata.Name
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v3"
+	zlog "github.com/rs/zerolog/log"
+	satrapv1 "github.com/vayzur/apadana/pkg/apis/satrap/v1"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// BatchInboundUsers applies a batch of user operations to one inbound. The
+// node and inbound of every item are taken from the path.
+func (s *Server) BatchInboundUsers(c fiber.Ctx) error {
+	params, err := s.requiredParams(c, "nodeName", "tag")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(&errs.Error{
+			Kind:    errs.KindInvalid,
+			Reason:  errs.ReasonMissingParam,
+			Message: err.Error(),
+		})
+	}
+
+	batch := &satrapv1.InboundUserBatch{}
+	if err := c.Bind().JSON(batch); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			fiber.Map{
+				"error": err.Error(),
+			},
+		)
+	}
+
+	for _, item := range batch.Items {
+		if item != nil {
+			item.NodeName = params["nodeName"]
+			item.InboundTag = params["tag"]
+		}
+	}
+
+	return s.batchUsers(c, batch)
+}
+
+// BatchUsers applies a batch of user operations spanning any number of nodes
+// and inbounds.
+func (s *Server) BatchUsers(c fiber.Ctx) error {
+	batch := &satrapv1.InboundUserBatch{}
+	if err := c.Bind().JSON(batch); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			fiber.Map{
+				"error": err.Error(),
+			},
+		)
+	}
+
+	return s.batchUsers(c, batch)
+}
+
+func (s *Server) batchUsers(c fiber.Ctx, batch *satrapv1.InboundUserBatch) error {
+	results, err := s.inboundService.BatchUsers(c.RequestCtx(), batch.Items)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "chapar").Str("resource", "inboundUser").Str("action", "batch").Int("items", len(batch.Items)).Msg("failed")
+		return errs.HandleAPIError(c, err)
+	}
+
+	list := &satrapv1.InboundUserBatchResultList{
+		Items: make([]*satrapv1.InboundUserBatchResult, len(batch.Items)),
+	}
+	failed := 0
+	for i, item := range batch.Items {
+		list.Items[i] = batchResult(item, results[i])
+		if results[i] != nil {
+			failed++
+		}
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", "inboundUser").Str("action", "batch").Int("items", len(batch.Items)).Int("failed", failed).Msg("applied")
+	return c.Status(fiber.StatusOK).JSON(list)
+}
+
+func batchResult(item *satrapv1.InboundUserBatchOp, err error) *satrapv1.InboundUserBatchResult {
+	result := &satrapv1.InboundUserBatchResult{}
+	if item != nil {
+		result.Action = item.Action
+		result.NodeName = item.NodeName
+		result.InboundTag = item.InboundTag
+		result.Email = item.Email
+		if item.Action == satrapv1.BatchCreate && item.User != nil {
+			result.Email = item.User.Spec.Email
+		}
+	}
+
+	if err != nil {
+		result.Status = errs.StatusCode(err)
+		result.Message = err.Error()
+		var e *errs.Error
+		if errors.As(err, &e) {
+			result.Reason = string(e.Reason)
+			result.Fields = e.Fields
+		}
+		return result
+	}
+
+	switch result.Action {
+	case satrapv1.BatchCreate:
+		result.Status = fiber.StatusCreated
+	case satrapv1.BatchDelete:
+		result.Status = fiber.StatusNoContent
+	default:
+		result.Status = fiber.StatusOK
+	}
+	return result
+}
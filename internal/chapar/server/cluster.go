@@ -0,0 +1,43 @@
+package server
+
+import (
+	"github.com/gofiber/fiber/v3"
+	zlog "github.com/rs/zerolog/log"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// GetClusterInbounds lists the inbounds of all nodes.
+func (s *Server) GetClusterInbounds(c fiber.Ctx) error {
+	opts, err := listOptions(c)
+	if err != nil {
+		return errs.HandleAPIError(c, err)
+	}
+
+	inbounds, err := s.inboundService.GetClusterInbounds(readContext(c), opts)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "chapar").Str("resource", "inbounds").Str("action", "list").Msg("failed")
+		return errs.HandleAPIError(c, err)
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", "inbounds").Str("action", "list").Int("count", len(inbounds.Items)).Msg("retrieved")
+	return c.Status(fiber.StatusOK).JSON(inbounds)
+}
+
+// GetClusterUsers lists the users of all nodes, or with ?email= every inbound
+// user with that email.
+func (s *Server) GetClusterUsers(c fiber.Ctx) error {
+	opts, err := listOptions(c)
+	if err != nil {
+		return errs.HandleAPIError(c, err)
+	}
+	email := c.Query("email")
+
+	users, err := s.inboundService.GetClusterUsers(readContext(c), email, opts)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "chapar").Str("resource", "inboundUser").Str("action", "list").Str("email", email).Msg("failed")
+		return errs.HandleAPIError(c, err)
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", "inboundUser").Str("action", "list").Str("email", email).Int("count", len(users.Items)).Msg("retrieved")
+	return c.Status(fiber.StatusOK).JSON(users)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v3"
+	zlog "github.com/rs/zerolog/log"
+	authv1 "github.com/vayzur/apadana/pkg/apis/auth/v1"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+func (s *Server) GetCredentials(c fiber.Ctx) error {
+	opts, err := listOptions(c)
+	if err != nil {
+		return errs.HandleAPIError(c, err)
+	}
+
+	credentials, err := s.credentialService.GetCredentials(readContext(c), opts)
+	if err != nil {
+		return errs.HandleAPIError(c, err)
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", "credentials").Str("action", "list").Int("count", len(credentials.Items)).Msg("retrieved")
+	return c.Status(http.StatusOK).JSON(credentials)
+}
+
+func (s *Server) GetCredential(c fiber.Ctx) error {
+	name := c.Params("name")
+	if name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			fiber.Map{
+				"error": errs.ErrInvalidCredential,
+			},
+		)
+	}
+
+	credential, err := s.credentialService.GetCredential(readContext(c), name)
+	if err != nil {
+		return errs.HandleAPIError(c, err)
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", "credential").Str("action", "get").Str("name", name).Msg("retrieved")
+	return c.Status(http.StatusOK).JSON(credential)
+}
+
+func (s *Server) CreateCredential(c fiber.Ctx) error {
+	credential := &authv1.Credential{}
+	if err := c.Bind().JSON(credential); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(&errs.Error{
+			Kind:    errs.KindInvalid,
+			Reason:  errs.ReasonUnmarshalFailed,
+			Message: err.Error(),
+		})
+	}
+
+	credential, err := s.credentialService.CreateCredential(c.RequestCtx(), credential)
+	if err != nil {
+		return errs.HandleAPIError(c, err)
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", "credential").Str("action", "create").Str("name", credential.Metadata.Name).Str("role", string(credential.Spec.Role)).Msg("created")
+	return c.Status(http.StatusCreated).JSON(credential)
+}
+
+func (s *Server) DeleteCredential(c fiber.Ctx) error {
+	name := c.Params("name")
+	if name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			fiber.Map{
+				"error": errs.ErrInvalidCredential,
+			},
+		)
+	}
+
+	if err := s.credentialService.DeleteCredential(c.RequestCtx(), name); err != nil {
+		return errs.HandleAPIError(c, err)
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", "credential").Str("action", "delete").Str("name", name).Msg("deleted")
+	return c.SendStatus(fiber.StatusNoContent)
+}
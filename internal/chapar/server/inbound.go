@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+
 	"github.com/gofiber/fiber/v3"
 	zlog "github.com/rs/zerolog/log"
 	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
@@ -21,7 +23,7 @@ func (s *Server) GetInbound(c fiber.Ctx) error {
 	nodeName := params["nodeName"]
 	tag := params["tag"]
 
-	inbound, err := s.inboundService.GetInbound(c.RequestCtx(), nodeName, tag)
+	inbound, err := s.inboundService.GetInbound(readContext(c), nodeName, tag)
 	if err != nil {
 		zlog.Error().Err(err).Str("component", "chapar").Str("resource", "inbound").Str("action", "get").Str("nodeName", nodeName).Str("tag", tag).Msg("failed")
 		return errs.HandleAPIError(c, err)
@@ -75,13 +77,24 @@ func (s *Server) GetInbounds(c fiber.Ctx) error {
 		)
 	}
 
-	inbounds, err := s.inboundService.GetInbounds(c.RequestCtx(), nodeName)
+	if isWatch(c) {
+		return s.serveWatch(c, "inbounds", func(ctx context.Context, fromRevision int64) (<-chan metav1.WatchEvent, error) {
+			return s.inboundService.WatchInbounds(ctx, nodeName, fromRevision)
+		})
+	}
+
+	opts, err := listOptions(c)
 	if err != nil {
-		zlog.Error().Err(err).Str("component", "chapar").Str("resource", "inbounds").Str("action", "list").Str("nodeName", nodeName).Int("count", len(inbounds)).Msg("failed")
 		return errs.HandleAPIError(c, err)
 	}
 
-	zlog.Info().Str("component", "chapar").Str("resource", "inbounds").Str("action", "list").Str("nodeName", nodeName).Int("count", len(inbounds)).Msg("retrieved")
+	inbounds, err := s.inboundService.GetInbounds(readContext(c), nodeName, opts)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "chapar").Str("resource", "inbounds").Str("action", "list").Str("nodeName", nodeName).Msg("failed")
+		return errs.HandleAPIError(c, err)
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", "inbounds").Str("action", "list").Str("nodeName", nodeName).Int("count", len(inbounds.Items)).Msg("retrieved")
 	return c.Status(fiber.StatusOK).JSON(inbounds)
 }
 
@@ -177,13 +190,24 @@ func (s *Server) GetInboundUsers(c fiber.Ctx) error {
 	nodeName := params["nodeName"]
 	tag := params["tag"]
 
-	users, err := s.inboundService.GetUsers(c.RequestCtx(), nodeName, tag)
+	if isWatch(c) {
+		return s.serveWatch(c, "inboundUsers", func(ctx context.Context, fromRevision int64) (<-chan metav1.WatchEvent, error) {
+			return s.inboundService.WatchUsers(ctx, nodeName, tag, fromRevision)
+		})
+	}
+
+	opts, err := listOptions(c)
+	if err != nil {
+		return errs.HandleAPIError(c, err)
+	}
+
+	users, err := s.inboundService.GetUsers(readContext(c), nodeName, tag, opts)
 	if err != nil {
-		zlog.Error().Err(err).Str("component", "chapar").Str("resource", "inboundUser").Str("action", "list").Str("nodeName", nodeName).Str("tag", tag).Int("count", len(users)).Msg("failed")
+		zlog.Error().Err(err).Str("component", "chapar").Str("resource", "inboundUser").Str("action", "list").Str("nodeName", nodeName).Str("tag", tag).Msg("failed")
 		return errs.HandleAPIError(c, err)
 	}
 
-	zlog.Info().Str("component", "chapar").Str("resource", "inboundUser").Str("action", "list").Str("nodeName", nodeName).Str("tag", tag).Int("count", len(users)).Msg("retrieved")
+	zlog.Info().Str("component", "chapar").Str("resource", "inboundUser").Str("action", "list").Str("nodeName", nodeName).Str("tag", tag).Int("count", len(users.Items)).Msg("retrieved")
 	return c.Status(fiber.StatusOK).JSON(users)
 }
 
@@ -272,7 +296,7 @@ func (s *Server) UpdateInboundSpec(c fiber.Ctx) error {
 		)
 	}
 
-	if err := s.inboundService.UpdateInboundSpec(c.RequestCtx(), nodeName, tag, newSpec); err != nil {
+	if err := s.inboundService.UpdateInboundSpec(c.RequestCtx(), nodeName, tag, newSpec, c.Query("resourceVersion")); err != nil {
 		zlog.Error().Err(err).Str("component", "chapar").Str("resource", "inbound").Str("action", "update").Str("nodeName", nodeName).Str("tag", tag).Msg("failed")
 		return errs.HandleAPIError(c, err)
 	}
@@ -304,7 +328,7 @@ func (s *Server) UpdateInboundUserSpec(c fiber.Ctx) error {
 		)
 	}
 
-	if err := s.inboundService.UpdateUserSpec(c.RequestCtx(), nodeName, tag, email, newSpec); err != nil {
+	if err := s.inboundService.UpdateUserSpec(c.RequestCtx(), nodeName, tag, email, newSpec, c.Query("resourceVersion")); err != nil {
 		zlog.Error().Err(err).Str("component", "chapar").Str("resource", "inboundUser").Str("action", "update").Str("nodeName", nodeName).Str("tag", tag).Str("email", email).Msg("failed")
 		return errs.HandleAPIError(c, err)
 	}
@@ -323,7 +347,7 @@ func (s *Server) CountInbounds(c fiber.Ctx) error {
 		)
 	}
 
-	count, err := s.inboundService.CountInbounds(c.RequestCtx(), nodeName)
+	count, err := s.inboundService.CountInbounds(readContext(c), nodeName)
 	if err != nil {
 		zlog.Error().Err(err).Str("component", "chapar").Str("resource", "inbound").Str("action", "count").Str("nodeName", nodeName).Uint32("count", count).Msg("failed")
 		return errs.HandleAPIError(c, err)
@@ -350,7 +374,7 @@ func (s *Server) CountInboundUsers(c fiber.Ctx) error {
 	nodeName := params["nodeName"]
 	tag := params["tag"]
 
-	count, err := s.inboundService.CountUsers(c.RequestCtx(), nodeName, tag)
+	count, err := s.inboundService.CountUsers(readContext(c), nodeName, tag)
 	if err != nil {
 		zlog.Error().Err(err).Str("component", "chapar").Str("resource", "inboundUser").Str("action", "count").Str("nodeName", nodeName).Str("tag", tag).Uint32("count", count).Msg("failed")
 		return errs.HandleAPIError(c, err)
@@ -363,3 +387,68 @@ func (s *Server) CountInboundUsers(c fiber.Ctx) error {
 	zlog.Info().Str("component", "chapar").Str("resource", "inbound").Str("action", "count").Str("nodeName", nodeName).Str("tag", tag).Uint32("count", count).Msg("retrieved")
 	return c.Status(fiber.StatusOK).JSON(countResp)
 }
+
+func (s *Server) RenewInbound(c fiber.Ctx) error {
+	params, err := s.requiredParams(c, "nodeName", "tag")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(&errs.Error{
+			Kind:    errs.KindInvalid,
+			Reason:  errs.ReasonMissingParam,
+			Message: err.Error(),
+		})
+	}
+
+	nodeName := params["nodeName"]
+	tag := params["tag"]
+
+	req := &satrapv1.RenewRequest{}
+	if err := c.Bind().JSON(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			fiber.Map{
+				"error": err.Error(),
+			},
+		)
+	}
+
+	inbound, err := s.inboundService.RenewInbound(c.RequestCtx(), nodeName, tag, req.TTL, c.Query("resourceVersion"))
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "chapar").Str("resource", "inbound").Str("action", "renew").Str("nodeName", nodeName).Str("tag", tag).Msg("failed")
+		return errs.HandleAPIError(c, err)
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", "inbound").Str("action", "renew").Str("nodeName", nodeName).Str("tag", tag).Time("expiresAt", *inbound.Spec.ExpiresAt).Msg("renewed")
+	return c.Status(fiber.StatusOK).JSON(inbound)
+}
+
+func (s *Server) RenewInboundUser(c fiber.Ctx) error {
+	params, err := s.requiredParams(c, "nodeName", "tag", "email")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(&errs.Error{
+			Kind:    errs.KindInvalid,
+			Reason:  errs.ReasonMissingParam,
+			Message: err.Error(),
+		})
+	}
+
+	nodeName := params["nodeName"]
+	tag := params["tag"]
+	email := params["email"]
+
+	req := &satrapv1.RenewRequest{}
+	if err := c.Bind().JSON(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			fiber.Map{
+				"error": err.Error(),
+			},
+		)
+	}
+
+	user, err := s.inboundService.RenewUser(c.RequestCtx(), nodeName, tag, email, req.TTL, c.Query("resourceVersion"))
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "chapar").Str("resource", "inboundUser").Str("action", "renew").Str("nodeName", nodeName).Str("tag", tag).Str("email", email).Msg("failed")
+		return errs.HandleAPIError(c, err)
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", "inboundUser").Str("action", "renew").Str("nodeName", nodeName).Str("tag", tag).Str("email", email).Time("expiresAt", *user.Spec.ExpiresAt).Msg("renewed")
+	return c.Status(fiber.StatusOK).JSON(user)
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v3"
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// listOptions reads the limit, continue and labelSelector query parameters.
+// A missing or zero limit returns everything in one page.
+func listOptions(c fiber.Ctx) (metav1.ListOptions, error) {
+	opts := metav1.ListOptions{
+		Continue:      c.Query("continue"),
+		LabelSelector: c.Query("labelSelector"),
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 0 {
+			return opts, errs.ErrInvalidLimit
+		}
+		opts.Limit = limit
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/vayzur/apadana/pkg/chapar/metrics"
+)
+
+const metricsPath = "/metrics"
+
+// MetricsConfig is how metrics are served.
+type MetricsConfig struct {
+	Enabled bool
+	// Token is the bearer token scrapers authenticate with, apart from the
+	// API token and credentials. Empty serves metrics to anyone.
+	Token string
+}
+
+// metricsMiddleware measures every request once it has been answered.
+func (s *Server) metricsMiddleware(c fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		status = fiber.StatusInternalServerError
+		var fe *fiber.Error
+		if errors.As(err, &fe) {
+			status = fe.Code
+		}
+	}
+
+	// requests answered by middleware, which is mounted at the root, before
+	// they reached a route have none
+	route := c.Route().Path
+	if route == "/" {
+		route = ""
+	}
+
+	// label values are kept past the request, whose buffers fiber reuses
+	metrics.ObserveRequest(strings.Clone(c.Method()), route, status, time.Since(start))
+	return err
+}
+
+func (s *Server) metricsAuth(c fiber.Ctx) error {
+	if s.metrics.Token == "" {
+		return c.Next()
+	}
+
+	token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.metrics.Token)) != 1 {
+		return unauthorized("metrics")
+	}
+	return c.Next()
+}
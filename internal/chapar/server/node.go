@@ -2,27 +2,38 @@ package server
 
 import (
 	"context"
+	"errors"
 	"net/http"
 
 	"github.com/gofiber/fiber/v3"
 	zlog "github.com/rs/zerolog/log"
+	authv1 "github.com/vayzur/apadana/pkg/apis/auth/v1"
 	corev1 "github.com/vayzur/apadana/pkg/apis/core/v1"
 	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
 	"github.com/vayzur/apadana/pkg/errs"
 )
 
 func (s *Server) GetNodes(c fiber.Ctx) error {
-	nodes, err := s.nodeService.GetNodes(c.RequestCtx())
+	if isWatch(c) {
+		return s.serveWatch(c, "nodes", s.nodeService.WatchNodes)
+	}
+
+	opts, err := listOptions(c)
 	if err != nil {
 		return errs.HandleAPIError(c, err)
 	}
 
-	zlog.Info().Str("component", "chapar").Str("resource", "nodes").Str("action", "list").Int("count", len(nodes)).Msg("retrieved")
+	nodes, err := s.nodeService.GetNodes(readContext(c), opts)
+	if err != nil {
+		return errs.HandleAPIError(c, err)
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", "nodes").Str("action", "list").Int("count", len(nodes.Items)).Msg("retrieved")
 	return c.Status(http.StatusOK).JSON(nodes)
 }
 
 func (s *Server) GetActiveNodes(c fiber.Ctx) error {
-	nodes, err := s.nodeService.GetActiveNodes(c.RequestCtx())
+	nodes, err := s.nodeService.GetActiveNodes(readContext(c))
 	if err != nil {
 		return errs.HandleAPIError(c, err)
 	}
@@ -41,7 +52,7 @@ func (s *Server) GetNode(c fiber.Ctx) error {
 		)
 	}
 
-	node, err := s.nodeService.GetNode(c.RequestCtx(), nodeName)
+	node, err := s.nodeService.GetNode(readContext(c), nodeName)
 	if err != nil {
 		return errs.HandleAPIError(c, err)
 	}
@@ -60,6 +71,10 @@ func (s *Server) CreateNode(c fiber.Ctx) error {
 		})
 	}
 
+	if id := requestIdentity(c); id != nil && id.role == authv1.RoleBootstrap {
+		return s.joinNode(c, id.credential, node)
+	}
+
 	if err := s.nodeService.CreateNode(c.RequestCtx(), node); err != nil {
 		return errs.HandleAPIError(c, err)
 	}
@@ -68,6 +83,43 @@ func (s *Server) CreateNode(c fiber.Ctx) error {
 	return c.Status(http.StatusCreated).JSON(node)
 }
 
+// joinNode registers node for the holder of a bootstrap token, which is
+// consumed, and answers with the credential the node is to use from then on.
+func (s *Server) joinNode(c fiber.Ctx, bootstrap *authv1.Credential, node *corev1.Node) error {
+	join, err := s.credentialService.JoinNode(c.RequestCtx(), bootstrap, node)
+	if err != nil {
+		if errors.Is(err, errs.ErrCredentialNotFound) {
+			// the token was used up by a concurrent join
+			return unauthorized("credential")
+		}
+		return errs.HandleAPIError(c, err)
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", "node").Str("action", "join").Str("nodeName", join.Node.Metadata.Name).Str("bootstrap", bootstrap.Metadata.Name).Str("credential", join.Credential.Metadata.Name).Msg("joined")
+	return c.Status(http.StatusCreated).JSON(join)
+}
+
+// RevokeNodeCredentials deletes the credentials of a node, cutting it off
+// until it joins again.
+func (s *Server) RevokeNodeCredentials(c fiber.Ctx) error {
+	nodeName := c.Params("nodeName")
+	if nodeName == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			fiber.Map{
+				"error": errs.ErrInvalidNode,
+			},
+		)
+	}
+
+	revoked, err := s.credentialService.RevokeNodeCredentials(c.RequestCtx(), nodeName)
+	if err != nil {
+		return errs.HandleAPIError(c, err)
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", "credential").Str("action", "revoke").Str("nodeName", nodeName).Int("count", revoked).Msg("deleted")
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
 func (s *Server) DeleteNode(c fiber.Ctx) error {
 	nodeName := c.Params("nodeName")
 	if nodeName == "" {
@@ -105,7 +157,7 @@ func (s *Server) UpdateNodeStatus(c fiber.Ctx) error {
 		)
 	}
 
-	if err := s.nodeService.UpdateNodeStatus(c.RequestCtx(), nodeName, newStatus); err != nil {
+	if err := s.nodeService.UpdateNodeStatus(c.RequestCtx(), nodeName, newStatus, c.Query("resourceVersion")); err != nil {
 		return errs.HandleAPIError(c, err)
 	}
 
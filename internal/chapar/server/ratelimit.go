@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	zlog "github.com/rs/zerolog/log"
+	authv1 "github.com/vayzur/apadana/pkg/apis/auth/v1"
+	"github.com/vayzur/apadana/pkg/chapar/ratelimit"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// RateLimits throttles clients. A nil member disables its limit.
+type RateLimits struct {
+	// IP limits each source address, before it authenticates.
+	IP *ratelimit.Limiter
+	// Agent limits each node agent, for its heartbeats and syncs, and Admin
+	// each other identity.
+	Agent *ratelimit.Limiter
+	Admin *ratelimit.Limiter
+	// Lockout refuses source addresses that failed to authenticate too
+	// often.
+	Lockout *ratelimit.Lockout
+}
+
+func (s *Server) ipRateLimit(c fiber.Ctx) error {
+	// the key outlives the request, whose buffers fiber reuses
+	ip := strings.Clone(c.IP())
+	now := time.Now()
+
+	if s.limits.Lockout != nil {
+		if wait := s.limits.Lockout.Locked(ip, now); wait > 0 {
+			return tooManyRequests(c, errs.ErrLockedOut, wait)
+		}
+	}
+	if s.limits.IP != nil {
+		if ok, wait := s.limits.IP.Allow(ip, now); !ok {
+			return tooManyRequests(c, errs.ErrRateLimited, wait)
+		}
+	}
+
+	err := c.Next()
+	if s.limits.Lockout == nil {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, fiber.ErrUnauthorized):
+		if s.limits.Lockout.Failure(ip, now) {
+			zlog.Warn().Str("component", "chapar").Str("ip", ip).Msg("locked out after repeated authentication failures")
+		}
+	case requestIdentity(c) != nil:
+		s.limits.Lockout.Reset(ip)
+	}
+	return err
+}
+
+func (s *Server) identityRateLimit(c fiber.Ctx) error {
+	id := requestIdentity(c)
+	if id == nil {
+		return c.Next()
+	}
+
+	limiter := s.limits.Admin
+	if id.role == authv1.RoleNode || id.role == authv1.RoleBootstrap {
+		limiter = s.limits.Agent
+	}
+	if limiter == nil {
+		return c.Next()
+	}
+
+	if ok, wait := limiter.Allow(string(id.role)+":"+id.name, time.Now()); !ok {
+		return tooManyRequests(c, errs.ErrRateLimited, wait)
+	}
+	return c.Next()
+}
+
+// tooManyRequests answers with err and when to retry, in whole seconds.
+func tooManyRequests(c fiber.Ctx, err error, wait time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return errs.HandleAPIError(c, err)
+}
@@ -0,0 +1,17 @@
+package server
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+)
+
+// readContext returns the context for a read handler. With ?quorum=true the
+// read bypasses the watch cache and sees the latest committed state.
+func readContext(c fiber.Ctx) context.Context {
+	if c.Query("quorum") == "true" {
+		return storage.WithQuorum(c.RequestCtx())
+	}
+	return c.RequestCtx()
+}
@@ -2,82 +2,148 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 
 	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/adaptor"
 	"github.com/gofiber/fiber/v3/middleware/healthcheck"
+	authv1 "github.com/vayzur/apadana/pkg/apis/auth/v1"
+	"github.com/vayzur/apadana/pkg/chapar/audit"
 	"github.com/vayzur/apadana/pkg/chapar/authentication"
+	"github.com/vayzur/apadana/pkg/chapar/metrics"
 	"github.com/vayzur/apadana/pkg/chapar/service"
 )
 
 type Server struct {
-	addr           string
-	token          string
-	prefork        bool
-	app            *fiber.App
-	inboundService *service.InboundService
-	nodeService    *service.NodeService
+	addr              string
+	auth              AuthConfig
+	limits            RateLimits
+	metrics           MetricsConfig
+	nonces            *authentication.NonceCache
+	prefork           bool
+	app               *fiber.App
+	inboundService    *service.InboundService
+	nodeService       *service.NodeService
+	credentialService *service.CredentialService
+	auditService      *service.AuditService
+	// audit records mutating requests; nil records none.
+	audit *audit.Logger
 }
 
-func NewServer(addr, token string, prefork bool, inboundService *service.InboundService, nodeService *service.NodeService) *Server {
+func NewServer(addr string, auth AuthConfig, limits RateLimits, metricsConfig MetricsConfig, prefork bool, inboundService *service.InboundService, nodeService *service.NodeService, credentialService *service.CredentialService, auditService *service.AuditService, auditLogger *audit.Logger) *Server {
 	app := fiber.New(fiber.Config{
 		CaseSensitive: true,
 		StrictRouting: true,
 	})
 	s := &Server{
-		addr:           addr,
-		token:          token,
-		prefork:        prefork,
-		app:            app,
-		inboundService: inboundService,
-		nodeService:    nodeService,
+		addr:              addr,
+		auth:              auth,
+		limits:            limits,
+		metrics:           metricsConfig,
+		nonces:            authentication.NewNonceCache(auth.NonceCacheSize),
+		prefork:           prefork,
+		app:               app,
+		inboundService:    inboundService,
+		nodeService:       nodeService,
+		credentialService: credentialService,
+		auditService:      auditService,
+		audit:             auditLogger,
 	}
 	s.setupRoutes()
 	return s
 }
 
 func (s *Server) setupRoutes() {
+	if s.metrics.Enabled {
+		s.app.Use(s.metricsMiddleware)
+	}
+	// throttled requests are refused before they reach the audit log
+	if s.limits.IP != nil || s.limits.Lockout != nil {
+		s.app.Use(s.ipRateLimit)
+	}
+	// scrapers authenticate apart from API clients
+	if s.metrics.Enabled {
+		s.app.Get(metricsPath, s.metricsAuth, adaptor.HTTPHandler(metrics.Handler()))
+	}
+	if s.audit != nil {
+		s.app.Use(s.auditMiddleware)
+	}
 	s.app.Use(s.authMiddleware)
+	if s.limits.Agent != nil || s.limits.Admin != nil {
+		s.app.Use(s.identityRateLimit)
+	}
 
 	s.app.Get(healthcheck.LivenessEndpoint, healthcheck.New())
 	s.app.Get(healthcheck.ReadinessEndpoint, healthcheck.New())
 
+	const (
+		readOnly    = authv1.RoleReadOnly
+		node        = authv1.RoleNode
+		userManager = authv1.RoleUserManager
+		bootstrap   = authv1.RoleBootstrap
+	)
+
 	api := s.app.Group("/api")
 	v1 := api.Group("/v1")
 
+	v1.Get("/inbounds", s.authorize(readOnly), s.GetClusterInbounds)
+	v1.Get("/users", s.authorize(readOnly, userManager), s.GetClusterUsers)
+	v1.Post("/users\\:batch", s.authorize(userManager), s.BatchUsers)
+
+	credentials := v1.Group("/credentials")
+	credentials.Get("", s.authorize(), s.GetCredentials)
+	credentials.Get("/:name", s.authorize(), s.GetCredential)
+	credentials.Post("", s.authorize(), s.CreateCredential)
+	credentials.Delete("/:name", s.authorize(), s.DeleteCredential)
+
+	v1.Get("/audit/events", s.authorize(), s.GetAuditEvents)
+
 	nodes := v1.Group("/nodes")
-	nodes.Get("", s.GetNodes)
-	nodes.Get("/active", s.GetActiveNodes)
-	nodes.Get("/:nodeName", s.GetNode)
-	nodes.Post("", s.CreateNode)
-	nodes.Delete("/:nodeName", s.DeleteNode)
-	nodes.Patch("/:nodeName/status", s.UpdateNodeStatus)
-	nodes.Patch("/:nodeName/metadata", s.UpdateNodeMetadata)
+	nodes.Get("", s.authorize(readOnly), s.GetNodes)
+	nodes.Get("/active", s.authorize(readOnly), s.GetActiveNodes)
+	nodes.Get("/:nodeName", s.authorize(readOnly, node), s.GetNode)
+	nodes.Post("", s.authorize(node, bootstrap), s.CreateNode)
+	nodes.Delete("/:nodeName", s.authorize(node), s.DeleteNode)
+	nodes.Delete("/:nodeName/credentials", s.authorize(), s.RevokeNodeCredentials)
+	nodes.Patch("/:nodeName/status", s.authorize(node), s.UpdateNodeStatus)
+	nodes.Patch("/:nodeName/metadata", s.authorize(node), s.UpdateNodeMetadata)
 
 	inbounds := nodes.Group("/:nodeName/inbounds")
-	inbounds.Get("", s.GetInbounds)
-	inbounds.Post("", s.CreateInbound)
-	inbounds.Get("/count", s.CountInbounds)
-	inbounds.Get("/:tag", s.GetInbound)
-	inbounds.Delete("/:tag", s.DeleteInbound)
-	inbounds.Patch("/:tag/metadata", s.UpdateInboundMetadata)
-	inbounds.Patch("/:tag/spec", s.UpdateInboundSpec)
+	inbounds.Get("", s.authorize(readOnly, node), s.GetInbounds)
+	inbounds.Post("", s.authorize(node), s.CreateInbound)
+	inbounds.Get("/count", s.authorize(readOnly, node), s.CountInbounds)
+	inbounds.Get("/:tag", s.authorize(readOnly, node), s.GetInbound)
+	inbounds.Delete("/:tag", s.authorize(node), s.DeleteInbound)
+	inbounds.Patch("/:tag/metadata", s.authorize(node), s.UpdateInboundMetadata)
+	inbounds.Patch("/:tag/spec", s.authorize(node), s.UpdateInboundSpec)
+	inbounds.Post("/:tag/renew", s.authorize(node), s.RenewInbound)
+	inbounds.Post("/:tag/users\\:batch", s.authorize(node, userManager), s.BatchInboundUsers)
 
 	inboundUsers := inbounds.Group("/:tag/users")
-	inboundUsers.Get("", s.GetInboundUsers)
-	inboundUsers.Get("/count", s.CountInboundUsers)
-	inboundUsers.Post("", s.CreateUser)
-	inboundUsers.Delete("/:email", s.DeleteUser)
-	inboundUsers.Patch("/:email/metadata", s.UpdateInboundUserMetadata)
-	inboundUsers.Patch("/:email/spec", s.UpdateInboundUserSpec)
+	inboundUsers.Get("", s.authorize(readOnly, node, userManager), s.GetInboundUsers)
+	inboundUsers.Get("/count", s.authorize(readOnly, node, userManager), s.CountInboundUsers)
+	inboundUsers.Post("", s.authorize(node, userManager), s.CreateUser)
+	inboundUsers.Delete("/:email", s.authorize(node, userManager), s.DeleteUser)
+	inboundUsers.Patch("/:email/metadata", s.authorize(node, userManager), s.UpdateInboundUserMetadata)
+	inboundUsers.Patch("/:email/spec", s.authorize(node, userManager), s.UpdateInboundUserSpec)
+	inboundUsers.Post("/:email/renew", s.authorize(node, userManager), s.RenewInboundUser)
 }
 
-func (s *Server) StartTLS(certFilePath, keyFilePath string) error {
+// StartTLS serves over TLS. With a clientCAFile, client certificates it
+// signed authenticate requests; requireClientCert makes them mandatory.
+func (s *Server) StartTLS(certFilePath, keyFilePath, clientCAFile string, requireClientCert bool) error {
 	return s.app.Listen(s.addr, fiber.ListenConfig{
 		DisableStartupMessage: true,
 		CertFile:              certFilePath,
 		CertKeyFile:           keyFilePath,
-		EnablePrefork:         s.prefork,
+		CertClientFile:        clientCAFile,
+		TLSConfigFunc: func(cfg *tls.Config) {
+			if clientCAFile != "" && !requireClientCert {
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+		},
+		EnablePrefork: s.prefork,
 	})
 }
 
@@ -92,18 +158,6 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return s.app.ShutdownWithContext(ctx)
 }
 
-func (s *Server) authMiddleware(c fiber.Ctx) error {
-	h := c.Get("Authorization")
-	if h == "" {
-		return fiber.ErrUnauthorized
-	}
-
-	if err := authentication.VerifyHMAC(h, s.token); err != nil {
-		return fiber.ErrUnauthorized
-	}
-	return c.Next()
-}
-
 func (s *Server) requiredParams(c fiber.Ctx, keys ...string) (map[string]string, error) {
 	m := make(map[string]string)
 	for _, k := range keys {
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	zlog "github.com/rs/zerolog/log"
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// watchKeepaliveInterval is how often an idle watch writes a blank line, so
+// that proxies keep the connection open and disconnected clients are noticed.
+const watchKeepaliveInterval = 30 * time.Second
+
+type watchFunc func(ctx context.Context, fromRevision int64) (<-chan metav1.WatchEvent, error)
+
+func isWatch(c fiber.Ctx) bool {
+	return c.Query("watch") == "true"
+}
+
+// serveWatch streams watch events as newline-delimited JSON until the client
+// goes away or the underlying watch ends. When resourceVersion is given, only
+// changes made after that version are sent.
+func (s *Server) serveWatch(c fiber.Ctx, resource string, watch watchFunc) error {
+	var fromRevision int64
+	if rv := c.Query("resourceVersion"); rv != "" {
+		rev, err := storage.ParseResourceVersion(rv)
+		if err != nil {
+			return errs.HandleAPIError(c, err)
+		}
+		fromRevision = rev + 1
+	}
+
+	// the request context is recycled once the handler returns, while the
+	// stream writer keeps running after that
+	ctx, cancel := context.WithCancel(context.Background())
+
+	events, err := watch(ctx, fromRevision)
+	if err != nil {
+		cancel()
+		zlog.Error().Err(err).Str("component", "chapar").Str("resource", resource).Str("action", "watch").Msg("failed")
+		return errs.HandleAPIError(c, err)
+	}
+
+	zlog.Info().Str("component", "chapar").Str("resource", resource).Str("action", "watch").Int64("fromRevision", fromRevision).Msg("started")
+
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+
+	return c.SendStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		defer zlog.Info().Str("component", "chapar").Str("resource", resource).Str("action", "watch").Msg("stopped")
+
+		enc := json.NewEncoder(w)
+		ticker := time.NewTicker(watchKeepaliveInterval)
+		defer ticker.Stop()
+
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				if err := enc.Encode(ev); err != nil {
+					return
+				}
+			case <-ticker.C:
+				if err := w.WriteByte('\n'); err != nil {
+					return
+				}
+			}
+
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package v1
+
+import (
+	"encoding/json"
+	"time"
+
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+)
+
+const (
+	APIVersion = "audit/v1"
+	KindEvent  = "Event"
+)
+
+// Labels every stored event carries, so events can be selected by them.
+const (
+	LabelNodeName = "nodeName"
+	LabelResource = "resource"
+)
+
+// User is who made an audited request. Name is empty for the cluster token
+// and for requests that failed to authenticate.
+type User struct {
+	Name string `json:"name,omitempty"`
+	Role string `json:"role,omitempty"`
+}
+
+// ObjectRef is the object a request was about, as far as its route and body
+// tell. Name is the node name, tag, email or credential name of the object
+// itself.
+type ObjectRef struct {
+	Resource string `json:"resource"`
+	NodeName string `json:"nodeName,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	Name     string `json:"name,omitempty"`
+}
+
+// Event records one mutating request. Metadata.Name identifies it and
+// Metadata.CreationTimestamp is when the request arrived.
+type Event struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta `json:"metadata"`
+	User            User              `json:"user"`
+	SourceIP        string            `json:"sourceIP,omitempty"`
+	Method          string            `json:"method"`
+	Path            string            `json:"path"`
+	// Route is the route pattern Path matched, empty when none did.
+	Route  string    `json:"route,omitempty"`
+	Object ObjectRef `json:"object"`
+	// RequestBody has secrets replaced by "[REDACTED]". It is left out when
+	// it is not JSON or larger than the audit body limit, which
+	// RequestBodyOmitted then tells.
+	RequestBody        json.RawMessage `json:"requestBody,omitempty"`
+	RequestBodyOmitted bool            `json:"requestBodyOmitted,omitempty"`
+	Status             int             `json:"status"`
+	Latency            time.Duration   `json:"latency"`
+}
+
+type EventList struct {
+	Metadata metav1.ListMeta `json:"metadata"`
+	Items    []*Event        `json:"items"`
+}
+
+// ListOptions filters audit events. Empty fields match every event.
+type ListOptions struct {
+	metav1.ListOptions `json:",inline"`
+	NodeName           string `json:"nodeName,omitempty"`
+	Resource           string `json:"resource,omitempty"`
+	// Since and Until bound the time of the request, Since inclusive and
+	// Until exclusive.
+	Since *time.Time `json:"since,omitempty"`
+	Until *time.Time `json:"until,omitempty"`
+}
@@ -0,0 +1,73 @@
+package v1
+
+import (
+	"time"
+
+	corev1 "github.com/vayzur/apadana/pkg/apis/core/v1"
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+)
+
+const (
+	APIVersion     = "auth/v1"
+	KindCredential = "Credential"
+)
+
+// Role decides which routes a credential may call.
+type Role string
+
+const (
+	// RoleAdmin may call every route.
+	RoleAdmin Role = "admin"
+	// RoleReadOnly may read everything but credentials.
+	RoleReadOnly Role = "read-only"
+	// RoleNode is held by a satrap agent and limited to the subtree of its
+	// own node, /nodes/<nodeName>, and to registering that node.
+	RoleNode Role = "node"
+	// RoleUserManager may only manage inbound users.
+	RoleUserManager Role = "user-manager"
+	// RoleBootstrap is held by a short-lived, single-use token that may only
+	// register a node, which exchanges it for a RoleNode credential.
+	RoleBootstrap Role = "bootstrap"
+)
+
+var Roles = []Role{RoleAdmin, RoleReadOnly, RoleNode, RoleUserManager, RoleBootstrap}
+
+const (
+	// DefaultBootstrapTTL is how long a bootstrap token created without a ttl
+	// is valid for.
+	DefaultBootstrapTTL = time.Hour
+	MaxBootstrapTTL     = 24 * time.Hour
+)
+
+type CredentialSpec struct {
+	Role Role `json:"role"`
+	// NodeName is the node a RoleNode credential is bound to. On a
+	// RoleBootstrap credential it restricts the token to joining that node
+	// and lets it replace the node's credential if the node already exists.
+	NodeName string `json:"nodeName,omitempty"`
+	// Token is the secret requests are signed with. It is generated by chapar
+	// and only returned when the credential is created.
+	Token string `json:"token,omitempty"`
+	// TTL is only read on creation, to compute ExpiresAt, and only allowed
+	// for RoleBootstrap.
+	TTL       time.Duration `json:"ttl,omitempty"`
+	ExpiresAt *time.Time    `json:"expiresAt,omitempty"`
+}
+
+type Credential struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta `json:"metadata"`
+	Spec            CredentialSpec    `json:"spec"`
+}
+
+type CredentialList struct {
+	Metadata metav1.ListMeta `json:"metadata"`
+	Items    []*Credential   `json:"items"`
+}
+
+// NodeJoin is what registering a node with a bootstrap token returns: the
+// node and the credential it is to use from then on.
+type NodeJoin struct {
+	Node       *corev1.Node `json:"node"`
+	Credential *Credential  `json:"credential"`
+}
@@ -6,6 +6,11 @@ import (
 	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
 )
 
+const (
+	APIVersion = "core/v1"
+	KindNode   = "Node"
+)
+
 const (
 	LabelHostname = "hostname"
 	LabelOS       = "os"
@@ -13,6 +18,9 @@ const (
 	LabelCountry  = "country"
 	LabelRegion   = "region"
 	LabelProvider = "provider"
+	// LabelPortRange, as "from-to", is where chapar allocates ports for the
+	// inbounds of the node created without one.
+	LabelPortRange = "port-range"
 )
 
 type NodeAddressType string
@@ -32,15 +40,24 @@ type NodeCapacity struct {
 }
 
 type NodeStatus struct {
-	Capacity          NodeCapacity  `json:"capacity"`
+	Capacity NodeCapacity `json:"capacity"`
+	// Remaining is the part of Capacity still free. It is filled in when a
+	// single node is read and never stored; nil means unlimited.
+	Remaining         *NodeCapacity `json:"remaining,omitempty"`
 	Addresses         []NodeAddress `json:"addresses"`
 	Ready             bool          `json:"ready"`
 	LastHeartbeatTime time.Time     `json:"lastHeartbeatTime"`
 }
 
 type Node struct {
-	Metadata metav1.ObjectMeta `json:"metadata"`
-	Status   NodeStatus        `json:"status"`
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta `json:"metadata"`
+	Status          NodeStatus        `json:"status"`
+}
+
+type NodeList struct {
+	Metadata metav1.ListMeta `json:"metadata"`
+	Items    []*Node         `json:"items"`
 }
 
 func GetPreferredAddress(addresses []NodeAddress, addressType NodeAddressType) string {
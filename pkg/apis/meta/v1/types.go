@@ -2,10 +2,49 @@ package meta
 
 import "time"
 
+// TypeMeta identifies the schema an object is stored and served with.
+type TypeMeta struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+}
+
+func (t *TypeMeta) GetTypeMeta() *TypeMeta {
+	return t
+}
+
 type ObjectMeta struct {
 	Name              string            `json:"name"`
 	UID               string            `json:"uid"`
+	ResourceVersion   string            `json:"resourceVersion,omitempty"`
 	CreationTimestamp time.Time         `json:"creationTimestamp"`
 	Labels            map[string]string `json:"labels,omitempty"`
 	Annotations       map[string]string `json:"annotations,omitempty"`
 }
+
+type WatchEventType string
+
+const (
+	Added    WatchEventType = "ADDED"
+	Modified WatchEventType = "MODIFIED"
+	Deleted  WatchEventType = "DELETED"
+	Error    WatchEventType = "ERROR"
+)
+
+type WatchEvent struct {
+	Type   WatchEventType `json:"type"`
+	Object any            `json:"object"`
+}
+
+type ListMeta struct {
+	ResourceVersion    string `json:"resourceVersion,omitempty"`
+	Continue           string `json:"continue,omitempty"`
+	RemainingItemCount *int64 `json:"remainingItemCount,omitempty"`
+}
+
+type ListOptions struct {
+	Limit    int64  `json:"limit,omitempty"`
+	Continue string `json:"continue,omitempty"`
+	// LabelSelector restricts the list to objects whose labels match, in
+	// the syntax of labels.Parse.
+	LabelSelector string `json:"labelSelector,omitempty"`
+}
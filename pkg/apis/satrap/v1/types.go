@@ -13,6 +13,12 @@ import (
 	"github.com/xtls/xray-core/proxy/vmess"
 )
 
+const (
+	APIVersion      = "satrap/v1"
+	KindInbound     = "Inbound"
+	KindInboundUser = "InboundUser"
+)
+
 type Resource string
 
 const (
@@ -29,14 +35,58 @@ type InboundCapacity struct {
 }
 
 type InboundSpec struct {
-	Capacity InboundCapacity          `json:"capacity"`
-	Config   conf.InboundDetourConfig `json:"config"`
-	TTL      time.Duration            `json:"ttl"`
+	Capacity  InboundCapacity          `json:"capacity"`
+	Config    conf.InboundDetourConfig `json:"config"`
+	TTL       time.Duration            `json:"ttl"` // only read on creation to compute ExpiresAt
+	ExpiresAt *time.Time               `json:"expiresAt,omitempty"`
+}
+
+// InboundStatus is filled in when a single inbound is read and never stored.
+type InboundStatus struct {
+	// Remaining is the part of Spec.Capacity still free; nil means unlimited.
+	Remaining *InboundCapacity `json:"remaining,omitempty"`
 }
 
 type Inbound struct {
-	Metadata metav1.ObjectMeta `json:"metadata"`
-	Spec     InboundSpec       `json:"spec"`
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta `json:"metadata"`
+	Spec            InboundSpec       `json:"spec"`
+	Status          *InboundStatus    `json:"status,omitempty"`
+}
+
+// RenewRequest extends an inbound or user so that it expires TTL from now.
+type RenewRequest struct {
+	TTL time.Duration `json:"ttl"`
+}
+
+type InboundList struct {
+	Metadata metav1.ListMeta `json:"metadata"`
+	Items    []*Inbound      `json:"items"`
+}
+
+// ClusterInbound is an inbound found by a query across nodes, together with
+// the node it belongs to.
+type ClusterInbound struct {
+	NodeName string   `json:"nodeName"`
+	Inbound  *Inbound `json:"inbound"`
+}
+
+type ClusterInboundList struct {
+	Metadata metav1.ListMeta   `json:"metadata"`
+	Items    []*ClusterInbound `json:"items"`
+}
+
+// ClusterInboundUser is a user found by a query across nodes, together with
+// the node and inbound it belongs to.
+type ClusterInboundUser struct {
+	NodeName   string       `json:"nodeName"`
+	InboundTag string       `json:"inboundTag"`
+	User       *InboundUser `json:"user"`
+}
+
+type ClusterInboundUserList struct {
+	Metadata metav1.ListMeta       `json:"metadata"`
+	Items    []*ClusterInboundUser `json:"items"`
 }
 
 type Account interface {
@@ -80,12 +130,70 @@ type InboundUserSpec struct {
 	InboundTag string          `json:"inboundTag"`
 	Email      string          `json:"email"`
 	Account    json.RawMessage `json:"account"`
-	TTL        time.Duration   `json:"ttl"`
+	TTL        time.Duration   `json:"ttl"` // only read on creation to compute ExpiresAt
+	ExpiresAt  *time.Time      `json:"expiresAt,omitempty"`
 }
 
 type InboundUser struct {
-	Metadata metav1.ObjectMeta `json:"metadata"`
-	Spec     InboundUserSpec   `json:"spec"`
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta `json:"metadata"`
+	Spec            InboundUserSpec   `json:"spec"`
+}
+
+type InboundUserList struct {
+	Metadata metav1.ListMeta `json:"metadata"`
+	Items    []*InboundUser  `json:"items"`
+}
+
+// MaxBatchItems bounds the number of operations in one batch request.
+const MaxBatchItems = 5000
+
+type BatchAction string
+
+const (
+	BatchCreate BatchAction = "create"
+	BatchUpdate BatchAction = "update"
+	BatchDelete BatchAction = "delete"
+)
+
+// InboundUserBatchOp is one operation of a batch request. NodeName and
+// InboundTag are only read by the cross-node endpoint; the per-inbound one
+// takes them from its path.
+type InboundUserBatchOp struct {
+	Action     BatchAction `json:"action"`
+	NodeName   string      `json:"nodeName,omitempty"`
+	InboundTag string      `json:"inboundTag,omitempty"`
+	// Email names the user to update or delete. Creates take it from User.
+	Email string       `json:"email,omitempty"`
+	User  *InboundUser `json:"user,omitempty"`
+	// Metadata and Spec are applied by updates like the metadata and spec
+	// PATCH endpoints; either may be left out.
+	Metadata *metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec     *InboundUserSpec   `json:"spec,omitempty"`
+	// ResourceVersion makes an update or delete conditional.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type InboundUserBatch struct {
+	Items []*InboundUserBatchOp `json:"items"`
+}
+
+// InboundUserBatchResult reports the outcome of the operation at the same
+// index of the request. Status is the HTTP status the single-user endpoint
+// would have answered with; Reason, Message and Fields are set when it failed.
+type InboundUserBatchResult struct {
+	Action     BatchAction       `json:"action"`
+	NodeName   string            `json:"nodeName"`
+	InboundTag string            `json:"inboundTag"`
+	Email      string            `json:"email"`
+	Status     int               `json:"status"`
+	Reason     string            `json:"reason,omitempty"`
+	Message    string            `json:"message,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty"`
+}
+
+type InboundUserBatchResultList struct {
+	Items []*InboundUserBatchResult `json:"items"`
 }
 
 func (u *InboundUser) ToAccount() (Account, error) {
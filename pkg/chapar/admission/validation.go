@@ -0,0 +1,178 @@
+package admission
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	authv1 "github.com/vayzur/apadana/pkg/apis/auth/v1"
+	satrapv1 "github.com/vayzur/apadana/pkg/apis/satrap/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+	"github.com/vayzur/apadana/pkg/errs"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// ReservedTag is the tag of the inbound serving xray's own API on every node.
+const ReservedTag = "api"
+
+const maxEmailLength = 254
+
+// tags, emails and credential names become key segments, so they cannot hold
+// a slash; credential names are also sent before a ':' in the auth header
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+var userTypes = []string{"vless", "vmess", "trojan"}
+
+// fieldErrors maps the path of every invalid field to what is wrong with it.
+type fieldErrors map[string]string
+
+func (f fieldErrors) add(path, format string, args ...any) {
+	if _, ok := f[path]; !ok {
+		f[path] = fmt.Sprintf(format, args...)
+	}
+}
+
+func (f fieldErrors) err(msg string) error {
+	if len(f) == 0 {
+		return nil
+	}
+	return errs.New(errs.KindInvalid, errs.ReasonValidationFailed, msg, f, nil)
+}
+
+// ValidateInbound checks that inbound can be created, the way satrap will
+// build it on its node. Every invalid field is reported in the Fields of the
+// returned error, keyed by its path.
+func ValidateInbound(inbound *satrapv1.Inbound) error {
+	f := fieldErrors{}
+	cfg := inbound.Spec.Config
+
+	switch tag := cfg.Tag; {
+	case tag == "":
+		f.add("spec.config.tag", "required")
+	case tag == ReservedTag:
+		f.add("spec.config.tag", "%q is reserved", ReservedTag)
+	case !tagPattern.MatchString(tag):
+		f.add("spec.config.tag", "must be 1-63 letters, digits, '.', '_' or '-', starting with a letter or digit")
+	}
+
+	if cfg.Protocol == "" {
+		f.add("spec.config.protocol", "required")
+	}
+	if inbound.Spec.TTL < 0 {
+		f.add("spec.ttl", "must not be negative")
+	}
+
+	if len(f) == 0 {
+		// a missing port is allocated on create; stand in any for the build
+		if resources.NeedsPort(&cfg) {
+			cfg.PortList = &conf.PortList{Range: []conf.PortRange{{From: 1, To: 1}}}
+		}
+		if _, err := cfg.Build(); err != nil {
+			f.add("spec.config", "%v", err)
+		}
+	}
+
+	return f.err("invalid inbound")
+}
+
+// ValidateUser checks that user can be added to inbound.
+func ValidateUser(user *satrapv1.InboundUser, inbound *satrapv1.Inbound) error {
+	f := fieldErrors{}
+	spec := user.Spec
+
+	switch email := spec.Email; {
+	case email == "":
+		f.add("spec.email", "required")
+	case len(email) > maxEmailLength:
+		f.add("spec.email", "must be at most %d characters", maxEmailLength)
+	case strings.ContainsAny(email, "/ \t\r\n"):
+		f.add("spec.email", "must not contain '/' or whitespace")
+	}
+
+	switch {
+	case spec.Type == "":
+		f.add("spec.type", "required")
+	case !slices.Contains(userTypes, spec.Type):
+		f.add("spec.type", "must be one of %s", strings.Join(userTypes, ", "))
+	case !strings.EqualFold(spec.Type, inbound.Spec.Config.Protocol):
+		f.add("spec.type", "does not match inbound protocol %q", inbound.Spec.Config.Protocol)
+	}
+
+	if spec.InboundTag != "" && spec.InboundTag != inbound.Spec.Config.Tag {
+		f.add("spec.inboundTag", "does not match inbound %q", inbound.Spec.Config.Tag)
+	}
+	if spec.TTL < 0 {
+		f.add("spec.ttl", "must not be negative")
+	}
+
+	if _, ok := f["spec.type"]; !ok {
+		validateAccount(f, user)
+	}
+
+	return f.err("invalid inbound user")
+}
+
+func validateAccount(f fieldErrors, user *satrapv1.InboundUser) {
+	if len(user.Spec.Account) == 0 {
+		f.add("spec.account", "required")
+		return
+	}
+
+	account, err := user.ToAccount()
+	if err != nil {
+		f.add("spec.account", "%v", err)
+		return
+	}
+
+	switch a := account.(type) {
+	case *satrapv1.VlessAccount:
+		if a.ID == "" {
+			f.add("spec.account.id", "required")
+		}
+	case *satrapv1.VmessAccount:
+		if a.ID == "" {
+			f.add("spec.account.id", "required")
+		}
+	case *satrapv1.TrojanAccount:
+		if a.Password == "" {
+			f.add("spec.account.password", "required")
+		}
+	}
+}
+
+// ValidateCredential checks that credential can be created.
+func ValidateCredential(credential *authv1.Credential) error {
+	f := fieldErrors{}
+	spec := credential.Spec
+
+	switch name := credential.Metadata.Name; {
+	case name == "":
+		f.add("metadata.name", "required")
+	case !tagPattern.MatchString(name):
+		f.add("metadata.name", "must be 1-63 letters, digits, '.', '_' or '-', starting with a letter or digit")
+	}
+
+	switch {
+	case spec.Role == "":
+		f.add("spec.role", "required")
+	case !slices.Contains(authv1.Roles, spec.Role):
+		f.add("spec.role", "must be one of admin, read-only, node, user-manager, bootstrap")
+	}
+
+	switch {
+	case spec.Role == authv1.RoleNode && spec.NodeName == "":
+		f.add("spec.nodeName", "required for role %q", authv1.RoleNode)
+	case spec.Role != authv1.RoleNode && spec.Role != authv1.RoleBootstrap && spec.NodeName != "":
+		f.add("spec.nodeName", "only allowed for roles %q and %q", authv1.RoleNode, authv1.RoleBootstrap)
+	}
+
+	switch ttl := spec.TTL; {
+	case spec.Role != authv1.RoleBootstrap && (ttl != 0 || spec.ExpiresAt != nil):
+		f.add("spec.ttl", "only allowed for role %q", authv1.RoleBootstrap)
+	case ttl < 0 || ttl > authv1.MaxBootstrapTTL:
+		f.add("spec.ttl", "must be between 0 and %s", authv1.MaxBootstrapTTL)
+	}
+
+	return f.err("invalid credential")
+}
@@ -0,0 +1,123 @@
+package audit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+	auditv1 "github.com/vayzur/apadana/pkg/apis/audit/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+)
+
+const (
+	queueSize = 1024
+
+	storeWriteTimeout = 5 * time.Second
+)
+
+// Sink persists audit events.
+type Sink interface {
+	Write(event *auditv1.Event) error
+	Close() error
+}
+
+// Logger hands recorded events to its sinks in the background, in the order
+// they were recorded. Events recorded while the queue is full are dropped, so
+// that a slow sink does not hold up the requests.
+type Logger struct {
+	sinks  []Sink
+	events chan *auditv1.Event
+	done   chan struct{}
+
+	dropped  atomic.Uint64
+	dropping atomic.Bool
+}
+
+func NewLogger(sinks ...Sink) *Logger {
+	l := &Logger{
+		sinks:  sinks,
+		events: make(chan *auditv1.Event, queueSize),
+		done:   make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Record names event and queues it. The time of the request is expected in
+// event.Metadata.CreationTimestamp.
+func (l *Logger) Record(event *auditv1.Event) {
+	event.TypeMeta.APIVersion = auditv1.APIVersion
+	event.TypeMeta.Kind = auditv1.KindEvent
+	event.Metadata.Name = resources.AuditEventName(event.Metadata.CreationTimestamp)
+	event.Metadata.Labels = map[string]string{
+		auditv1.LabelResource: event.Object.Resource,
+	}
+	if event.Object.NodeName != "" {
+		event.Metadata.Labels[auditv1.LabelNodeName] = event.Object.NodeName
+	}
+
+	select {
+	case l.events <- event:
+		l.dropping.Store(false)
+	default:
+		l.dropped.Add(1)
+		// logged once per run of drops rather than for every event
+		if l.dropping.CompareAndSwap(false, true) {
+			zlog.Warn().Str("component", "audit").Int("queue", queueSize).Msg("queue full, dropping events")
+		}
+	}
+}
+
+// Dropped returns how many events were dropped for a full queue.
+func (l *Logger) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	for event := range l.events {
+		for _, sink := range l.sinks {
+			if err := sink.Write(event); err != nil {
+				zlog.Error().Err(err).Str("component", "audit").Str("name", event.Metadata.Name).Str("method", event.Method).Str("path", event.Path).Msg("write failed")
+			}
+		}
+	}
+}
+
+// Close writes out the queued events and closes the sinks. Record must not
+// be called after it.
+func (l *Logger) Close() {
+	close(l.events)
+	<-l.done
+	for _, sink := range l.sinks {
+		if err := sink.Close(); err != nil {
+			zlog.Error().Err(err).Str("component", "audit").Msg("close failed")
+		}
+	}
+}
+
+// StoreSink writes events to storage, where they can be queried. Requests
+// that failed to authenticate are left to the other sinks, so that anonymous
+// clients cannot fill the store.
+type StoreSink struct {
+	store *resources.AuditStore
+}
+
+func NewStoreSink(store *resources.AuditStore) *StoreSink {
+	return &StoreSink{store: store}
+}
+
+func (s *StoreSink) Write(event *auditv1.Event) error {
+	if event.User.Role == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeWriteTimeout)
+	defer cancel()
+	return s.store.CreateEvent(ctx, event)
+}
+
+func (s *StoreSink) Close() error {
+	return nil
+}
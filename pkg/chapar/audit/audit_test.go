@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	auditv1 "github.com/vayzur/apadana/pkg/apis/audit/v1"
+)
+
+// blockingSink holds up every write until release is closed, and tells
+// when it started on one.
+type blockingSink struct {
+	started chan struct{}
+	release chan struct{}
+	written int
+}
+
+func (s *blockingSink) Write(*auditv1.Event) error {
+	select {
+	case s.started <- struct{}{}:
+	default:
+	}
+	<-s.release
+	s.written++
+	return nil
+}
+
+func (s *blockingSink) Close() error {
+	return nil
+}
+
+func TestLoggerDropsWhenFull(t *testing.T) {
+	sink := &blockingSink{started: make(chan struct{}, 1), release: make(chan struct{})}
+	l := NewLogger(sink)
+
+	// the first event is taken off the queue by the writer, which blocks
+	l.Record(&auditv1.Event{})
+	<-sink.started
+
+	const extra = 10
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range queueSize + extra {
+			l.Record(&auditv1.Event{})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Record blocked on a full queue")
+	}
+	if got := l.Dropped(); got != extra {
+		t.Errorf("Dropped() = %d, want %d", got, extra)
+	}
+
+	close(sink.release)
+	l.Close()
+	if sink.written != queueSize+1 {
+		t.Errorf("sink got %d events, want %d", sink.written, queueSize+1)
+	}
+}
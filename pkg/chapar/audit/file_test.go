@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+
+	auditv1 "github.com/vayzur/apadana/pkg/apis/audit/v1"
+)
+
+func newTestSink(t *testing.T, maxBackups int) *FileSink {
+	t.Helper()
+	s, err := NewFileSink(filepath.Join(t.TempDir(), "audit.log"), 1, maxBackups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	// small enough for every event to fill the file
+	s.maxSize = 1
+	return s
+}
+
+func writeEvent(t *testing.T, s *FileSink, path string) {
+	t.Helper()
+	if err := s.Write(&auditv1.Event{Method: "POST", Path: path}); err != nil {
+		t.Fatalf("Write(%s): %v", path, err)
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	n := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		n++
+	}
+	return n
+}
+
+func TestFileSinkRotate(t *testing.T) {
+	s := newTestSink(t, 2)
+
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		writeEvent(t, s, path)
+	}
+
+	for _, name := range []string{s.path, s.backup(1), s.backup(2)} {
+		if n := countLines(t, name); n != 1 {
+			t.Errorf("%s holds %d events, want 1", filepath.Base(name), n)
+		}
+	}
+	if _, err := os.Stat(s.backup(3)); !os.IsNotExist(err) {
+		t.Errorf("backup beyond maxBackups kept: %v", err)
+	}
+}
+
+func TestFileSinkRotateFailure(t *testing.T) {
+	s := newTestSink(t, 1)
+
+	// a directory that is not empty can be neither removed nor replaced, so
+	// the current file cannot be moved to the first backup
+	if err := os.MkdirAll(filepath.Join(s.backup(1), "taken"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	writeEvent(t, s, "/a")
+	writeEvent(t, s, "/b")
+	writeEvent(t, s, "/c")
+	if n := countLines(t, s.path); n != 3 {
+		t.Fatalf("current file holds %d events after failed rotations, want 3", n)
+	}
+
+	// once the backup is free again rotating resumes
+	if err := os.RemoveAll(s.backup(1)); err != nil {
+		t.Fatal(err)
+	}
+	writeEvent(t, s, "/d")
+	if n := countLines(t, s.backup(1)); n != 3 {
+		t.Errorf("first backup holds %d events, want 3", n)
+	}
+	if n := countLines(t, s.path); n != 1 {
+		t.Errorf("current file holds %d events, want 1", n)
+	}
+}
+
+func TestFileSinkClosed(t *testing.T) {
+	s := newTestSink(t, 1)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(&auditv1.Event{}); err == nil {
+		t.Error("Write() after Close() = nil, want error")
+	}
+}
@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// MaxBodySize bounds the request bodies kept in events.
+const MaxBodySize = 64 << 10
+
+const redacted = "[REDACTED]"
+
+// secretFields are the JSON fields, compared in lower case, whose values are
+// never logged: credential tokens, and the ids, passwords and keys of xray
+// accounts and inbound configs.
+var secretFields = map[string]bool{
+	"token":        true,
+	"password":     true,
+	"secret":       true,
+	"id":           true,
+	"privatekey":   true,
+	"presharedkey": true,
+	"psk":          true,
+	"seed":         true,
+	"shortids":     true,
+	"auth":         true,
+}
+
+// RedactBody returns body with the values of secret fields replaced. It
+// reports false, and no body, when body is not JSON or too large to keep.
+func RedactBody(body []byte) (json.RawMessage, bool) {
+	if len(body) > MaxBodySize {
+		return nil, false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+
+	out, err := json.Marshal(redact(v))
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if secretFields[strings.ToLower(k)] {
+				v[k] = redacted
+			} else {
+				v[k] = redact(val)
+			}
+		}
+	case []any:
+		for i, val := range v {
+			v[i] = redact(val)
+		}
+	}
+	return v
+}
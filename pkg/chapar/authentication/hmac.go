@@ -2,6 +2,7 @@ package authentication
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/hex"
@@ -12,34 +13,132 @@ import (
 	"time"
 )
 
-func VerifyHMAC(header, token string) error {
-	if !strings.HasPrefix(header, "hmac ") {
-		return errors.New("invalid header prefix")
+const (
+	// Scheme signs the method, path, query and body of a request together
+	// with a timestamp and a nonce. The header reads
+	// "hmac-v2 <credential>:<ts>:<nonce>:<sig>", where the credential is
+	// empty for the cluster token.
+	Scheme = "hmac-v2"
+	// LegacyScheme only signs the timestamp, as "hmac <ts>:<sig>" or
+	// "hmac <credential>:<ts>:<sig>". A captured header can be replayed
+	// against any route until it expires.
+	LegacyScheme = "hmac"
+
+	// MaxClockSkew is how far the timestamp of a request may be off.
+	MaxClockSkew = time.Minute
+
+	nonceBytes = 16
+)
+
+// Request is the part of an HTTP request a signature covers.
+type Request struct {
+	Method string
+	// URI is the request target as sent, path and query.
+	URI  string
+	Body []byte
+}
+
+// Signature is a parsed Authorization header.
+type Signature struct {
+	Legacy     bool
+	Credential string
+	Timestamp  int64
+	Nonce      string
+	Sig        string
+}
+
+// ParseHeader reads an Authorization header of either scheme.
+func ParseHeader(header string) (*Signature, error) {
+	scheme, auth, ok := strings.Cut(header, " ")
+	if !ok {
+		return nil, errors.New("invalid header prefix")
 	}
 
-	auth := strings.TrimPrefix(header, "hmac ")
-	parts := strings.SplitN(auth, ":", 2)
-	if len(parts) != 2 {
-		return errors.New("invalid format")
+	s := &Signature{}
+	parts := strings.Split(auth, ":")
+
+	switch scheme {
+	case Scheme:
+		if len(parts) != 4 || parts[2] == "" {
+			return nil, errors.New("invalid format")
+		}
+		s.Credential, s.Nonce, s.Sig = parts[0], parts[2], parts[3]
+		parts = parts[1:2]
+
+	case LegacyScheme:
+		s.Legacy = true
+		switch len(parts) {
+		case 2:
+		case 3:
+			if parts[0] == "" {
+				return nil, errors.New("invalid format")
+			}
+			s.Credential = parts[0]
+			parts = parts[1:]
+		default:
+			return nil, errors.New("invalid format")
+		}
+		s.Sig = parts[1]
+
+	default:
+		return nil, errors.New("invalid header prefix")
 	}
 
-	tsStr, sig := parts[0], parts[1]
-	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
 	if err != nil {
-		return errors.New("invalid timestamp")
+		return nil, errors.New("invalid timestamp")
 	}
+	s.Timestamp = ts
+	return s, nil
+}
 
-	if time.Since(time.Unix(ts, 0)).Abs() > time.Minute {
+// Verify checks that s was made with token for r, and is recent as of now.
+// Replays are not detected here; see NonceCache.
+func (s *Signature) Verify(token string, r *Request, now time.Time) error {
+	if now.Sub(time.Unix(s.Timestamp, 0)).Abs() > MaxClockSkew {
 		return errors.New("expired")
 	}
 
-	mac := hmac.New(sha256.New, []byte(token))
-	fmt.Fprintf(mac, "%d", ts)
-	expected := hex.EncodeToString(mac.Sum(nil))
+	var expected string
+	if s.Legacy {
+		expected = legacySign(token, s.Timestamp)
+	} else {
+		expected = sign(token, s.Credential, s.Timestamp, s.Nonce, r)
+	}
 
-	if subtle.ConstantTimeCompare([]byte(sig), []byte(expected)) == 1 {
+	if subtle.ConstantTimeCompare([]byte(s.Sig), []byte(expected)) == 1 {
 		return nil
 	}
-
 	return errors.New("unauthorized")
 }
+
+// SignRequest returns the Authorization header for r, signed with token as
+// the named credential, or the cluster token when credential is empty.
+func SignRequest(credential, token string, r *Request) (string, error) {
+	b := make([]byte, nonceBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(b)
+	ts := time.Now().Unix()
+
+	return fmt.Sprintf("%s %s:%d:%s:%s", Scheme, credential, ts, nonce, sign(token, credential, ts, nonce, r)), nil
+}
+
+// sign covers everything a request is made of, so that a signature cannot be
+// moved to another method, path, query, body or credential.
+func sign(token, credential string, ts int64, nonce string, r *Request) string {
+	path, query, _ := strings.Cut(r.URI, "?")
+	body := sha256.Sum256(r.Body)
+
+	mac := hmac.New(sha256.New, []byte(token))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n%d\n%s\n%s",
+		Scheme, r.Method, path, query, hex.EncodeToString(body[:]), ts, nonce, credential)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func legacySign(token string, ts int64) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	fmt.Fprintf(mac, "%d", ts)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,202 @@
+package authentication
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    Signature
+		wantErr bool
+	}{
+		{
+			name:   "credential",
+			header: "hmac-v2 node-a:1700000000:abc:sig",
+			want:   Signature{Credential: "node-a", Timestamp: 1700000000, Nonce: "abc", Sig: "sig"},
+		},
+		{
+			name:   "cluster token",
+			header: "hmac-v2 :1700000000:abc:sig",
+			want:   Signature{Timestamp: 1700000000, Nonce: "abc", Sig: "sig"},
+		},
+		{
+			name:   "legacy",
+			header: "hmac 1700000000:sig",
+			want:   Signature{Legacy: true, Timestamp: 1700000000, Sig: "sig"},
+		},
+		{
+			name:   "legacy credential",
+			header: "hmac node-a:1700000000:sig",
+			want:   Signature{Legacy: true, Credential: "node-a", Timestamp: 1700000000, Sig: "sig"},
+		},
+		{name: "no scheme", header: "node-a:1700000000:abc:sig", wantErr: true},
+		{name: "unknown scheme", header: "bearer node-a:1700000000:abc:sig", wantErr: true},
+		{name: "missing nonce", header: "hmac-v2 node-a:1700000000::sig", wantErr: true},
+		{name: "too few parts", header: "hmac-v2 node-a:1700000000:sig", wantErr: true},
+		{name: "too many parts", header: "hmac-v2 node-a:1700000000:abc:sig:x", wantErr: true},
+		{name: "legacy empty credential", header: "hmac :1700000000:sig", wantErr: true},
+		{name: "legacy too many parts", header: "hmac a:b:1700000000:sig", wantErr: true},
+		{name: "bad timestamp", header: "hmac-v2 node-a:soon:abc:sig", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHeader(tt.header)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseHeader(%q) = %+v, want error", tt.header, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseHeader(%q): %v", tt.header, err)
+			}
+			if *got != tt.want {
+				t.Errorf("ParseHeader(%q) = %+v, want %+v", tt.header, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const token = "secret"
+	signed := &Request{Method: "PUT", URI: "/api/v1/nodes/a/status?force=true", Body: []byte(`{"ready":true}`)}
+
+	header, err := SignRequest("node-a", token, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ParseHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Unix(sig.Timestamp, 0)
+
+	moved := func(change func(r *Request)) *Request {
+		r := *signed
+		change(&r)
+		return &r
+	}
+
+	tests := []struct {
+		name    string
+		sig     *Signature
+		token   string
+		req     *Request
+		now     time.Time
+		wantErr bool
+	}{
+		{name: "valid", sig: sig, token: token, req: signed, now: at},
+		{name: "skew ahead within limit", sig: sig, token: token, req: signed, now: at.Add(MaxClockSkew)},
+		{name: "skew behind within limit", sig: sig, token: token, req: signed, now: at.Add(-MaxClockSkew)},
+		{name: "expired", sig: sig, token: token, req: signed, now: at.Add(MaxClockSkew + time.Second), wantErr: true},
+		{name: "from the future", sig: sig, token: token, req: signed, now: at.Add(-MaxClockSkew - time.Second), wantErr: true},
+		{name: "other token", sig: sig, token: "other", req: signed, now: at, wantErr: true},
+		{
+			name: "other method", sig: sig, token: token, now: at, wantErr: true,
+			req: moved(func(r *Request) { r.Method = "DELETE" }),
+		},
+		{
+			name: "other path", sig: sig, token: token, now: at, wantErr: true,
+			req: moved(func(r *Request) { r.URI = "/api/v1/nodes/b/status?force=true" }),
+		},
+		{
+			name: "other query", sig: sig, token: token, now: at, wantErr: true,
+			req: moved(func(r *Request) { r.URI = "/api/v1/nodes/a/status?force=false" }),
+		},
+		{
+			name: "query dropped", sig: sig, token: token, now: at, wantErr: true,
+			req: moved(func(r *Request) { r.URI = "/api/v1/nodes/a/status" }),
+		},
+		{
+			name: "other body", sig: sig, token: token, now: at, wantErr: true,
+			req: moved(func(r *Request) { r.Body = []byte(`{"ready":false}`) }),
+		},
+		{
+			name: "other credential", token: token, req: signed, now: at, wantErr: true,
+			sig: &Signature{Credential: "node-b", Timestamp: sig.Timestamp, Nonce: sig.Nonce, Sig: sig.Sig},
+		},
+		{
+			name: "other nonce", token: token, req: signed, now: at, wantErr: true,
+			sig: &Signature{Credential: sig.Credential, Timestamp: sig.Timestamp, Nonce: "fresh", Sig: sig.Sig},
+		},
+		{
+			name: "other timestamp", token: token, req: signed, now: at, wantErr: true,
+			sig: &Signature{Credential: sig.Credential, Timestamp: sig.Timestamp + 1, Nonce: sig.Nonce, Sig: sig.Sig},
+		},
+		{
+			name: "downgraded to legacy", token: token, req: signed, now: at, wantErr: true,
+			sig: &Signature{Legacy: true, Credential: sig.Credential, Timestamp: sig.Timestamp, Sig: sig.Sig},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.sig.Verify(tt.token, tt.req, tt.now)
+			if tt.wantErr && err == nil {
+				t.Error("Verify() = nil, want error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Verify() = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestVerifyLegacy(t *testing.T) {
+	const token = "secret"
+	at := time.Unix(1700000000, 0)
+	sig := &Signature{Legacy: true, Timestamp: at.Unix(), Sig: legacySign(token, at.Unix())}
+
+	// the legacy scheme does not cover the request, which is why it is
+	// only accepted for a limited time
+	for _, r := range []*Request{
+		{Method: "GET", URI: "/api/v1/nodes"},
+		{Method: "DELETE", URI: "/api/v1/nodes/a", Body: []byte("x")},
+	} {
+		if err := sig.Verify(token, r, at); err != nil {
+			t.Errorf("Verify(%s %s) = %v, want nil", r.Method, r.URI, err)
+		}
+	}
+
+	if err := sig.Verify("other", &Request{}, at); err == nil {
+		t.Error("Verify() with other token = nil, want error")
+	}
+	if err := sig.Verify(token, &Request{}, at.Add(MaxClockSkew+time.Second)); err == nil {
+		t.Error("Verify() after clock skew = nil, want error")
+	}
+
+	header := "hmac " + strings.Join([]string{"1700000000", sig.Sig}, ":")
+	parsed, err := ParseHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.Verify(token, &Request{}, at); err != nil {
+		t.Errorf("Verify() of parsed header = %v, want nil", err)
+	}
+}
+
+func TestSignRequestNonce(t *testing.T) {
+	r := &Request{Method: "GET", URI: "/api/v1/nodes"}
+	first, err := SignRequest("", "secret", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := SignRequest("", "secret", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := ParseHeader(first)
+	b, _ := ParseHeader(second)
+	if a.Nonce == b.Nonce {
+		t.Errorf("two signatures share nonce %q", a.Nonce)
+	}
+	if len(a.Nonce) != 2*nonceBytes {
+		t.Errorf("nonce %q has %d hex digits, want %d", a.Nonce, len(a.Nonce), 2*nonceBytes)
+	}
+}
@@ -0,0 +1,78 @@
+package authentication
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultNonceCacheSize bounds the nonces chapar remembers.
+const DefaultNonceCacheSize = 100000
+
+type nonceEntry struct {
+	key     string
+	expires time.Time
+}
+
+// NonceCache remembers the nonces of recently accepted requests to reject
+// replays. Nonces are kept for as long as their request could still pass
+// the clock skew check. When the cache is full the oldest nonce is dropped
+// early, so the size must cover the requests of that period.
+//
+// The cache is per process: with prefork every child has its own.
+type NonceCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	seen    map[string]time.Time
+	entries []nonceEntry
+}
+
+func NewNonceCache(size int) *NonceCache {
+	if size <= 0 {
+		size = DefaultNonceCacheSize
+	}
+	return &NonceCache{
+		size: size,
+		ttl:  2 * MaxClockSkew,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// Seen records the nonce of credential and reports whether it was already
+// recorded.
+func (c *NonceCache) Seen(credential, nonce string, now time.Time) bool {
+	key := credential + ":" + nonce
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire(now)
+	if _, ok := c.seen[key]; ok {
+		return true
+	}
+
+	if len(c.entries) >= c.size {
+		c.drop(1)
+	}
+	expires := now.Add(c.ttl)
+	c.seen[key] = expires
+	c.entries = append(c.entries, nonceEntry{key: key, expires: expires})
+	return false
+}
+
+// expire drops the nonces that have outlived the clock skew window. Entries
+// are in the order they were added, which is also the order they expire in.
+func (c *NonceCache) expire(now time.Time) {
+	n := 0
+	for n < len(c.entries) && !now.Before(c.entries[n].expires) {
+		n++
+	}
+	c.drop(n)
+}
+
+func (c *NonceCache) drop(n int) {
+	for _, e := range c.entries[:n] {
+		delete(c.seen, e.key)
+	}
+	c.entries = c.entries[n:]
+}
@@ -0,0 +1,77 @@
+package authentication
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNonceCacheReplay(t *testing.T) {
+	c := NewNonceCache(10)
+	now := time.Unix(1700000000, 0)
+
+	if c.Seen("node-a", "n1", now) {
+		t.Fatal("first use of a nonce reported as seen")
+	}
+	if !c.Seen("node-a", "n1", now) {
+		t.Error("replayed nonce not reported as seen")
+	}
+	if c.Seen("node-b", "n1", now) {
+		t.Error("nonce of another credential reported as seen")
+	}
+	if c.Seen("", "n1", now) {
+		t.Error("nonce of the cluster token reported as seen")
+	}
+}
+
+func TestNonceCacheExpiry(t *testing.T) {
+	c := NewNonceCache(10)
+	now := time.Unix(1700000000, 0)
+	ttl := 2 * MaxClockSkew
+
+	c.Seen("node-a", "n1", now)
+	c.Seen("node-a", "n2", now.Add(time.Second))
+
+	// a request signed at now passes the skew check until now+MaxClockSkew,
+	// and the cache must remember it for at least as long
+	if !c.Seen("node-a", "n1", now.Add(ttl-time.Nanosecond)) {
+		t.Error("nonce forgotten before it expired")
+	}
+	if c.Seen("node-a", "n1", now.Add(ttl)) {
+		t.Error("nonce remembered after it expired")
+	}
+	if !c.Seen("node-a", "n2", now.Add(ttl)) {
+		t.Error("later nonce expired along with an earlier one")
+	}
+	if len(c.entries) != 2 || len(c.seen) != 2 {
+		t.Errorf("cache holds %d entries and %d keys, want 2 and 2", len(c.entries), len(c.seen))
+	}
+}
+
+func TestNonceCacheEviction(t *testing.T) {
+	c := NewNonceCache(2)
+	now := time.Unix(1700000000, 0)
+
+	c.Seen("node-a", "n1", now)
+	c.Seen("node-a", "n2", now)
+	c.Seen("node-a", "n3", now)
+
+	if len(c.entries) != 2 || len(c.seen) != 2 {
+		t.Fatalf("cache holds %d entries and %d keys, want 2 and 2", len(c.entries), len(c.seen))
+	}
+	if !c.Seen("node-a", "n3", now) || !c.Seen("node-a", "n2", now) {
+		t.Error("newest nonces evicted")
+	}
+	// the oldest was dropped to make room, and recording it again evicts n2
+	if c.Seen("node-a", "n1", now) {
+		t.Error("oldest nonce not evicted from a full cache")
+	}
+	if c.Seen("node-a", "n2", now) {
+		t.Error("n2 not evicted once n1 was recorded again")
+	}
+}
+
+func TestNewNonceCacheDefaultSize(t *testing.T) {
+	if c := NewNonceCache(0); c.size != DefaultNonceCacheSize {
+		t.Errorf("size = %d, want %d", c.size, DefaultNonceCacheSize)
+	}
+}
@@ -0,0 +1,27 @@
+package backup
+
+import (
+	"encoding/json"
+	"time"
+
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+)
+
+// An archive is a gzip compressed stream of JSON lines: one Header followed
+// by one Record per object, nodes first, then inbounds, then users.
+const (
+	APIVersion  = "backup/v1"
+	KindArchive = "Archive"
+)
+
+type Header struct {
+	metav1.TypeMeta   `json:",inline"`
+	CreationTimestamp time.Time `json:"creationTimestamp"`
+}
+
+type Record struct {
+	Kind     string          `json:"kind"`
+	NodeName string          `json:"nodeName,omitempty"`
+	Tag      string          `json:"tag,omitempty"`
+	Object   json.RawMessage `json:"object"`
+}
@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+)
+
+const pageSize = 500
+
+// Backup writes every node, inbound and user in store to w and returns the
+// number of objects written. Each kind is read page by page, so the archive
+// is not a point-in-time snapshot of a cluster that is being modified.
+// User credentials are written in plain text.
+func Backup(ctx context.Context, store storage.Interface, w io.Writer) (int, error) {
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+
+	header := &Header{
+		TypeMeta:          metav1.TypeMeta{APIVersion: APIVersion, Kind: KindArchive},
+		CreationTimestamp: time.Now(),
+	}
+	if err := enc.Encode(header); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	codec := resources.Codec()
+
+	for _, k := range resources.Kinds {
+		opts := storage.ListOptions{Limit: pageSize}
+		for {
+			list := &storage.List{}
+			if err := store.GetList(ctx, k.Prefix, opts, list); err != nil {
+				return count, err
+			}
+
+			for _, kv := range list.Items {
+				obj := k.New()
+				if _, err := codec.Decode(k.Name, kv.Value, obj); err != nil {
+					return count, fmt.Errorf("%q: %w", kv.Key, err)
+				}
+				data, err := codec.EncodeJSON(k.Name, obj)
+				if err != nil {
+					return count, fmt.Errorf("%q: %w", kv.Key, err)
+				}
+
+				record := &Record{Kind: k.Name, Object: data}
+				// keys are <prefix><nodeName>/<tag>/<email>
+				parts := strings.Split(strings.TrimPrefix(kv.Key, k.Prefix), "/")
+				if len(parts) > 1 {
+					record.NodeName = parts[0]
+				}
+				if len(parts) > 2 {
+					record.Tag = parts[1]
+				}
+
+				if err := enc.Encode(record); err != nil {
+					return count, err
+				}
+				count++
+			}
+
+			if list.Continue == "" {
+				break
+			}
+			opts.Continue = list.Continue
+		}
+	}
+
+	return count, gz.Close()
+}
@@ -0,0 +1,269 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	corev1 "github.com/vayzur/apadana/pkg/apis/core/v1"
+	satrapv1 "github.com/vayzur/apadana/pkg/apis/satrap/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+	"github.com/vayzur/apadana/pkg/errs"
+	"github.com/vayzur/apadana/pkg/labels"
+)
+
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictFail      ConflictPolicy = "fail"
+)
+
+type RestoreOptions struct {
+	// Nodes limits the restore to these nodes and their inbounds and users.
+	Nodes []string
+	// Selector limits the restore to nodes whose labels match, and their
+	// inbounds and users.
+	Selector   labels.Selector
+	OnConflict ConflictPolicy
+	// DryRun only counts what would be done.
+	DryRun bool
+}
+
+type RestoreResult struct {
+	Created     int `json:"created"`
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+	Expired     int `json:"expired"`
+	Filtered    int `json:"filtered"`
+}
+
+// target is how one archived object is looked up, created and overwritten.
+type target struct {
+	name   string
+	get    func(ctx context.Context) (resourceVersion string, err error)
+	create func(ctx context.Context) error
+	update func(ctx context.Context, resourceVersion string) error
+}
+
+type restorer struct {
+	nodes    *resources.NodeStore
+	inbounds *resources.InboundStore
+	opts     RestoreOptions
+	result   RestoreResult
+	// selected holds the archived nodes that passed the selector
+	selected map[string]bool
+}
+
+// Restore re-creates the objects of an archive written by Backup. Objects
+// already expired are left out, and existing ones are handled according to
+// opts.OnConflict.
+func Restore(ctx context.Context, r io.Reader, nodes *resources.NodeStore, inbounds *resources.InboundStore, opts RestoreOptions) (*RestoreResult, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(gz))
+
+	header := &Header{}
+	if err := dec.Decode(header); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if header.Kind != KindArchive || header.APIVersion != APIVersion {
+		return nil, fmt.Errorf("unsupported archive %s %s", header.APIVersion, header.Kind)
+	}
+
+	if opts.OnConflict == "" {
+		opts.OnConflict = ConflictSkip
+	}
+
+	rs := &restorer{
+		nodes:    nodes,
+		inbounds: inbounds,
+		opts:     opts,
+		selected: make(map[string]bool),
+	}
+
+	for {
+		record := &Record{}
+		if err := dec.Decode(record); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return &rs.result, err
+		}
+		if err := rs.restore(ctx, record); err != nil {
+			return &rs.result, err
+		}
+	}
+
+	return &rs.result, nil
+}
+
+func (rs *restorer) restore(ctx context.Context, record *Record) error {
+	codec := resources.Codec()
+
+	switch record.Kind {
+	case corev1.KindNode:
+		node := &corev1.Node{}
+		if _, err := codec.Decode(record.Kind, record.Object, node); err != nil {
+			return err
+		}
+		if !rs.matches(node.Metadata.Name) || !rs.opts.Selector.Matches(node.Metadata.Labels) {
+			rs.result.Filtered++
+			return nil
+		}
+		rs.selected[node.Metadata.Name] = true
+
+		return rs.apply(ctx, &target{
+			name: "node " + node.Metadata.Name,
+			get: func(ctx context.Context) (string, error) {
+				existing, err := rs.nodes.GetNode(ctx, node.Metadata.Name)
+				if err != nil {
+					return "", err
+				}
+				return existing.Metadata.ResourceVersion, nil
+			},
+			create: func(ctx context.Context) error {
+				return rs.nodes.CreateNode(ctx, node)
+			},
+			update: func(ctx context.Context, resourceVersion string) error {
+				node.Metadata.ResourceVersion = resourceVersion
+				return rs.nodes.UpdateNode(ctx, node)
+			},
+		})
+
+	case satrapv1.KindInbound:
+		inbound := &satrapv1.Inbound{}
+		if _, err := codec.Decode(record.Kind, record.Object, inbound); err != nil {
+			return err
+		}
+		if !rs.included(record.NodeName) {
+			rs.result.Filtered++
+			return nil
+		}
+		if expired(inbound.Spec.ExpiresAt) {
+			rs.result.Expired++
+			return nil
+		}
+
+		tag := inbound.Spec.Config.Tag
+		return rs.apply(ctx, &target{
+			name: fmt.Sprintf("inbound %s/%s", record.NodeName, tag),
+			get: func(ctx context.Context) (string, error) {
+				existing, err := rs.inbounds.GetInbound(ctx, record.NodeName, tag)
+				if err != nil {
+					return "", err
+				}
+				return existing.Metadata.ResourceVersion, nil
+			},
+			create: func(ctx context.Context) error {
+				// archived objects were admitted when first created and
+				// have their ports, which are still checked for conflicts
+				return rs.inbounds.CreateInbound(ctx, record.NodeName, inbound, 0, resources.PortRange{})
+			},
+			update: func(ctx context.Context, resourceVersion string) error {
+				inbound.Metadata.ResourceVersion = resourceVersion
+				return rs.inbounds.RenewInbound(ctx, record.NodeName, inbound)
+			},
+		})
+
+	case satrapv1.KindInboundUser:
+		user := &satrapv1.InboundUser{}
+		if _, err := codec.Decode(record.Kind, record.Object, user); err != nil {
+			return err
+		}
+		if !rs.included(record.NodeName) {
+			rs.result.Filtered++
+			return nil
+		}
+		if expired(user.Spec.ExpiresAt) {
+			rs.result.Expired++
+			return nil
+		}
+
+		return rs.apply(ctx, &target{
+			name: fmt.Sprintf("user %s/%s/%s", record.NodeName, record.Tag, user.Spec.Email),
+			get: func(ctx context.Context) (string, error) {
+				existing, err := rs.inbounds.GetUser(ctx, record.NodeName, record.Tag, user.Spec.Email)
+				if err != nil {
+					return "", err
+				}
+				return existing.Metadata.ResourceVersion, nil
+			},
+			create: func(ctx context.Context) error {
+				return rs.inbounds.CreateUser(ctx, record.NodeName, record.Tag, user, 0)
+			},
+			update: func(ctx context.Context, resourceVersion string) error {
+				user.Metadata.ResourceVersion = resourceVersion
+				return rs.inbounds.RenewUser(ctx, record.NodeName, record.Tag, user)
+			},
+		})
+
+	default:
+		return fmt.Errorf("unknown kind %q in archive", record.Kind)
+	}
+}
+
+func (rs *restorer) apply(ctx context.Context, t *target) error {
+	resourceVersion, err := t.get(ctx)
+	if err != nil {
+		if !notFound(err) {
+			return fmt.Errorf("%s: %w", t.name, err)
+		}
+		if !rs.opts.DryRun {
+			if err := t.create(ctx); err != nil {
+				return fmt.Errorf("%s: %w", t.name, err)
+			}
+		}
+		rs.result.Created++
+		return nil
+	}
+
+	switch rs.opts.OnConflict {
+	case ConflictOverwrite:
+		if !rs.opts.DryRun {
+			if err := t.update(ctx, resourceVersion); err != nil {
+				return fmt.Errorf("%s: %w", t.name, err)
+			}
+		}
+		rs.result.Overwritten++
+	case ConflictFail:
+		return fmt.Errorf("%s already exists", t.name)
+	default:
+		rs.result.Skipped++
+	}
+	return nil
+}
+
+func (rs *restorer) matches(nodeName string) bool {
+	return len(rs.opts.Nodes) == 0 || slices.Contains(rs.opts.Nodes, nodeName)
+}
+
+// included reports whether objects of nodeName are restored. Without a
+// selector that only depends on the node filter; with one, the node itself
+// must have been selected from the archive.
+func (rs *restorer) included(nodeName string) bool {
+	if !rs.matches(nodeName) {
+		return false
+	}
+	return rs.opts.Selector.Empty() || rs.selected[nodeName]
+}
+
+func expired(expiresAt *time.Time) bool {
+	return expiresAt != nil && !expiresAt.After(time.Now())
+}
+
+func notFound(err error) bool {
+	var e *errs.Error
+	return errors.As(err, &e) && e.Kind == errs.KindNotFound
+}
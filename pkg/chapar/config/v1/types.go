@@ -1,23 +1,140 @@
 package v1
 
-import etcdconfigv1 "github.com/vayzur/apadana/pkg/chapar/storage/etcd/config/v1"
+import (
+	"time"
+
+	encryptionconfigv1 "github.com/vayzur/apadana/pkg/chapar/storage/encryption/config/v1"
+	etcdconfigv1 "github.com/vayzur/apadana/pkg/chapar/storage/etcd/config/v1"
+)
 
 type TLSConfig struct {
 	Enabled  bool   `mapstructure:"enabled" yaml:"enabled"`
 	CertFile string `mapstructure:"certFile" yaml:"certFile"`
 	KeyFile  string `mapstructure:"keyFile" yaml:"keyFile"`
+	// ClientCAFile verifies client certificates. A request that carries no
+	// Authorization header is authenticated by its certificate: the
+	// organization names the role and the common name, or else the first DNS
+	// name, the identity, which for the node role is the node name.
+	ClientCAFile string `mapstructure:"clientCAFile" yaml:"clientCAFile"`
+	// RequireClientCert rejects connections without a valid client
+	// certificate instead of falling back to signed requests alone.
+	RequireClientCert bool `mapstructure:"requireClientCert" yaml:"requireClientCert"`
+}
+
+// ClientTLSConfig is how clients verify chapar and, optionally, present a
+// certificate to it.
+type ClientTLSConfig struct {
+	// CAFile verifies the certificate of chapar instead of the system roots.
+	CAFile   string `mapstructure:"caFile" yaml:"caFile"`
+	CertFile string `mapstructure:"certFile" yaml:"certFile"`
+	KeyFile  string `mapstructure:"keyFile" yaml:"keyFile"`
+	// ServerName overrides the name the certificate of chapar is checked
+	// against.
+	ServerName string `mapstructure:"serverName" yaml:"serverName"`
 }
 
 type ClusterConfig struct {
 	Server string `mapstructure:"server" yaml:"server"`
-	Token  string `mapstructure:"token" yaml:"token"`
+	// Credential names the credential Token belongs to. Without it Token is
+	// taken to be the cluster token of chapar.
+	Credential string `mapstructure:"credential" yaml:"credential"`
+	// Token may be left empty when a client certificate authenticates.
+	Token string          `mapstructure:"token" yaml:"token"`
+	TLS   ClientTLSConfig `mapstructure:"tls" yaml:"tls"`
+}
+
+const (
+	StorageBackendEtcd   = "etcd"
+	StorageBackendMemory = "memory"
+)
+
+type StorageConfig struct {
+	// Backend is either "etcd" (default) or "memory". The memory backend keeps
+	// all state in the chapar process and is meant for development and tests.
+	Backend string `mapstructure:"backend" yaml:"backend"`
+	// DisableWatchCache makes every read go to etcd instead of the in-memory
+	// cache chapar keeps in sync through a watch.
+	DisableWatchCache bool `mapstructure:"disableWatchCache" yaml:"disableWatchCache"`
+	// Encoding is what objects are written in, "json" (default) or the more
+	// compact "cbor". Both are read regardless, and "chapar migrate" rewrites
+	// existing objects in the configured one.
+	Encoding string `mapstructure:"encoding" yaml:"encoding"`
+}
+
+// AuditConfig is where mutating requests are recorded. Request bodies are
+// kept with their secrets redacted.
+type AuditConfig struct {
+	// Path is the JSON lines file events are appended to. Empty writes no
+	// file. With prefork every child appends to its own file, <path>.<pid>.
+	Path string `mapstructure:"path" yaml:"path"`
+	// MaxSizeMB is the size the file is rotated at, 100 by default.
+	MaxSizeMB int `mapstructure:"maxSizeMB" yaml:"maxSizeMB"`
+	// MaxBackups is how many rotated files are kept, 5 by default.
+	MaxBackups int `mapstructure:"maxBackups" yaml:"maxBackups"`
+	// Store also writes events to storage, where /api/v1/audit/events
+	// queries them.
+	Store bool `mapstructure:"store" yaml:"store"`
+	// Retention is how long stored events are kept; zero keeps them
+	// forever.
+	Retention time.Duration `mapstructure:"retention" yaml:"retention"`
+}
+
+// RateLimit is a token bucket: RPS requests a second on average, in bursts
+// of up to Burst.
+type RateLimit struct {
+	RPS   float64 `mapstructure:"rps" yaml:"rps"`
+	Burst int     `mapstructure:"burst" yaml:"burst"`
+}
+
+// RateLimitConfig bounds how often clients may call chapar once enabled.
+// Throttled requests are answered with 429 and a Retry-After header. Unset
+// fields take the defaults of the ratelimit package. Limits are kept per
+// process, so with prefork every child applies them on its own.
+type RateLimitConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// IP is the budget of each source address, checked before
+	// authentication.
+	IP RateLimit `mapstructure:"ip" yaml:"ip"`
+	// Agent is the budget of each node agent, for its heartbeats and syncs.
+	Agent RateLimit `mapstructure:"agent" yaml:"agent"`
+	// Admin is the budget of every other identity.
+	Admin RateLimit `mapstructure:"admin" yaml:"admin"`
+	// MaxAuthFailures failed authentications from one address within
+	// AuthFailureWindow lock it out for LockoutDuration.
+	MaxAuthFailures   int           `mapstructure:"maxAuthFailures" yaml:"maxAuthFailures"`
+	AuthFailureWindow time.Duration `mapstructure:"authFailureWindow" yaml:"authFailureWindow"`
+	LockoutDuration   time.Duration `mapstructure:"lockoutDuration" yaml:"lockoutDuration"`
+}
+
+// MetricsConfig is how Prometheus metrics are served at /metrics.
+type MetricsConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// Token, when set, is the bearer token scrapers must present. It is
+	// apart from the API token and credentials.
+	Token string `mapstructure:"token" yaml:"token"`
 }
 
 type ChaparConfig struct {
-	Address string                  `mapstructure:"address" yaml:"address"`
-	Port    uint16                  `mapstructure:"port" yaml:"port"`
-	Prefork bool                    `mapstructure:"prefork" yaml:"prefork"`
-	Token   string                  `mapstructure:"token" yaml:"token"`
-	TLS     TLSConfig               `mapstructure:"tls" yaml:"tls"`
-	Etcd    etcdconfigv1.EtcdConfig `mapstructure:"etcd" yaml:"etcd"`
+	Address string `mapstructure:"address" yaml:"address"`
+	Port    uint16 `mapstructure:"port" yaml:"port"`
+	Prefork bool   `mapstructure:"prefork" yaml:"prefork"`
+	// Token signs requests that name no credential and grants them the admin
+	// role. Leaving it empty accepts named credentials only.
+	Token string `mapstructure:"token" yaml:"token"`
+	// LegacyHMACUntil, in RFC 3339, is until when requests signed with the
+	// legacy scheme, which only covers the timestamp and can be replayed, are
+	// still accepted while agents are upgraded. Empty rejects them.
+	LegacyHMACUntil string `mapstructure:"legacyHMACUntil" yaml:"legacyHMACUntil"`
+	// NonceCacheSize bounds the request nonces kept to reject replays.
+	NonceCacheSize int `mapstructure:"nonceCacheSize" yaml:"nonceCacheSize"`
+	// PortRange, as "from-to", is where inbounds created without a port get
+	// one from. A node's "port-range" label takes precedence.
+	PortRange  string                              `mapstructure:"portRange" yaml:"portRange"`
+	TLS        TLSConfig                           `mapstructure:"tls" yaml:"tls"`
+	Storage    StorageConfig                       `mapstructure:"storage" yaml:"storage"`
+	Etcd       etcdconfigv1.EtcdConfig             `mapstructure:"etcd" yaml:"etcd"`
+	Encryption encryptionconfigv1.EncryptionConfig `mapstructure:"encryption" yaml:"encryption"`
+	Audit      AuditConfig                         `mapstructure:"audit" yaml:"audit"`
+	RateLimit  RateLimitConfig                     `mapstructure:"rateLimit" yaml:"rateLimit"`
+	Metrics    MetricsConfig                       `mapstructure:"metrics" yaml:"metrics"`
 }
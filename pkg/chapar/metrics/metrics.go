@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "chapar"
+
+// registry holds every chapar metric, along with those of the Go runtime
+// and the process. Metrics are per process: with prefork every child
+// reports its own.
+var registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "API requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of API requests by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	authFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "failures_total",
+		Help:      "Requests that failed to authenticate, by reason.",
+	}, []string{"reason"})
+
+	etcdDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "etcd",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of etcd operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	etcdErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "etcd",
+		Name:      "request_errors_total",
+		Help:      "Failed etcd operations. Not found, already exists and conflict answers are not failures.",
+	}, []string{"operation"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests,
+		requestDuration,
+		authFailures,
+		etcdDuration,
+		etcdErrors,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records an answered API request. route is the pattern the
+// path matched, so that it does not grow the label set.
+func ObserveRequest(method, route string, status int, latency time.Duration) {
+	code := strconv.Itoa(status)
+	requests.WithLabelValues(method, route, code).Inc()
+	requestDuration.WithLabelValues(method, route, code).Observe(latency.Seconds())
+}
+
+// AuthFailure counts a request that failed to authenticate for reason.
+func AuthFailure(reason string) {
+	authFailures.WithLabelValues(reason).Inc()
+}
+
+// RegisterAuditDropped reports the audit events dropped for a full queue, as
+// counted by dropped.
+func RegisterAuditDropped(dropped func() uint64) {
+	registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "audit",
+		Name:      "dropped_events_total",
+		Help:      "Audit events dropped because the queue was full.",
+	}, func() float64 { return float64(dropped()) }))
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	zlog "github.com/rs/zerolog/log"
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+)
+
+const collectTimeout = 10 * time.Second
+
+var (
+	nodesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "nodes"),
+		"Registered nodes.", nil, nil)
+	readyNodesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "nodes_ready"),
+		"Registered nodes that are ready.", nil, nil)
+	inboundsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "inbounds"),
+		"Inbounds per node.", []string{"node"}, nil)
+	usersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "inbound_users"),
+		"Inbound users per node.", []string{"node"}, nil)
+)
+
+// objectCollector counts the stored objects on every scrape.
+type objectCollector struct {
+	nodes    *resources.NodeStore
+	inbounds *resources.InboundStore
+}
+
+// RegisterObjects reports the number of nodes, ready nodes, and inbounds and
+// users per node.
+func RegisterObjects(nodes *resources.NodeStore, inbounds *resources.InboundStore) {
+	registry.MustRegister(&objectCollector{nodes: nodes, inbounds: inbounds})
+}
+
+func (c *objectCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- nodesDesc
+	ch <- readyNodesDesc
+	ch <- inboundsDesc
+	ch <- usersDesc
+}
+
+func (c *objectCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	// a failed read leaves its metrics out of the scrape; failing the scrape
+	// would also lose those of the process and the requests
+	nodes, err := c.nodes.GetNodes(ctx, metav1.ListOptions{})
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "metrics").Str("resource", "nodes").Msg("collect failed")
+		return
+	}
+
+	inbounds, users, err := c.inbounds.CountPerNode(ctx)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "metrics").Str("resource", "inbounds").Msg("collect failed")
+	}
+
+	ready := 0
+	for _, node := range nodes.Items {
+		if node.Status.Ready {
+			ready++
+		}
+		if err != nil {
+			continue
+		}
+		nodeName := node.Metadata.Name
+		ch <- prometheus.MustNewConstMetric(inboundsDesc, prometheus.GaugeValue, float64(inbounds[nodeName]), nodeName)
+		ch <- prometheus.MustNewConstMetric(usersDesc, prometheus.GaugeValue, float64(users[nodeName]), nodeName)
+	}
+
+	ch <- prometheus.MustNewConstMetric(nodesDesc, prometheus.GaugeValue, float64(len(nodes.Items)))
+	ch <- prometheus.MustNewConstMetric(readyNodesDesc, prometheus.GaugeValue, float64(ready))
+}
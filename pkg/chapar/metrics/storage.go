@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// etcdStore records the latency and failures of every operation on the etcd
+// store it wraps.
+type etcdStore struct {
+	store storage.Interface
+}
+
+// InstrumentEtcd wraps the etcd store so that its operations are measured.
+func InstrumentEtcd(store storage.Interface) storage.Interface {
+	return &etcdStore{store: store}
+}
+
+func observeEtcd(operation string, start time.Time, err error) {
+	etcdDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if failed(err) {
+		etcdErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// failed tells errors from the answers a caller expects to handle.
+func failed(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, errs.ErrResourceNotFound),
+		errors.Is(err, errs.ErrResourceExists),
+		errors.Is(err, errs.ErrResourceVersionConflict),
+		errors.Is(err, errs.ErrResourceExpired),
+		errors.Is(err, errs.ErrLeaseNotFound),
+		errors.Is(err, errs.ErrInvalidContinue):
+		return false
+	}
+	return true
+}
+
+func (s *etcdStore) Get(ctx context.Context, key string, out *storage.KeyValue) error {
+	start := time.Now()
+	err := s.store.Get(ctx, key, out)
+	observeEtcd("get", start, err)
+	return err
+}
+
+func (s *etcdStore) Create(ctx context.Context, key string, obj []byte, ttl uint64) error {
+	start := time.Now()
+	err := s.store.Create(ctx, key, obj, ttl)
+	observeEtcd("create", start, err)
+	return err
+}
+
+func (s *etcdStore) Update(ctx context.Context, key string, obj []byte, ttl uint64, resourceVersion int64) error {
+	start := time.Now()
+	err := s.store.Update(ctx, key, obj, ttl, resourceVersion)
+	observeEtcd("update", start, err)
+	return err
+}
+
+func (s *etcdStore) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.store.Delete(ctx, key)
+	observeEtcd("delete", start, err)
+	return err
+}
+
+func (s *etcdStore) Txn(ctx context.Context, ops ...storage.Op) error {
+	start := time.Now()
+	err := s.store.Txn(ctx, ops...)
+	observeEtcd("txn", start, err)
+	return err
+}
+
+func (s *etcdStore) GetList(ctx context.Context, prefix string, opts storage.ListOptions, out *storage.List) error {
+	start := time.Now()
+	err := s.store.GetList(ctx, prefix, opts, out)
+	observeEtcd("list", start, err)
+	return err
+}
+
+func (s *etcdStore) Grant(ctx context.Context, ttl uint64) (int64, error) {
+	start := time.Now()
+	lease, err := s.store.Grant(ctx, ttl)
+	observeEtcd("grant", start, err)
+	return lease, err
+}
+
+func (s *etcdStore) Count(ctx context.Context, key string) (uint32, error) {
+	start := time.Now()
+	count, err := s.store.Count(ctx, key)
+	observeEtcd("count", start, err)
+	return count, err
+}
+
+// Watch only measures starting the watch.
+func (s *etcdStore) Watch(ctx context.Context, prefix string, fromRevision int64) (<-chan storage.Event, error) {
+	start := time.Now()
+	events, err := s.store.Watch(ctx, prefix, fromRevision)
+	observeEtcd("watch", start, err)
+	return events, err
+}
+
+func (s *etcdStore) ReadinessCheck() error {
+	start := time.Now()
+	err := s.store.ReadinessCheck()
+	observeEtcd("readiness", start, err)
+	return err
+}
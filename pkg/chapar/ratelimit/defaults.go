@@ -0,0 +1,18 @@
+package ratelimit
+
+import "time"
+
+// Defaults for the budgets and the lockout chapar applies when none are
+// configured.
+const (
+	DefaultIPRate     = 100
+	DefaultIPBurst    = 200
+	DefaultAgentRate  = 20
+	DefaultAgentBurst = 100
+	DefaultAdminRate  = 50
+	DefaultAdminBurst = 200
+
+	DefaultMaxAuthFailures   = 10
+	DefaultAuthFailureWindow = 5 * time.Minute
+	DefaultLockoutDuration   = 15 * time.Minute
+)
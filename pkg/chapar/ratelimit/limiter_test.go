@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterBurst(t *testing.T) {
+	l := NewLimiter(2, 3)
+	now := time.Unix(1700000000, 0)
+
+	for i := range 3 {
+		if ok, _ := l.Allow("a", now); !ok {
+			t.Fatalf("request %d of the burst denied", i+1)
+		}
+	}
+	ok, wait := l.Allow("a", now)
+	if ok {
+		t.Fatal("request past the burst allowed")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("wait = %v, want 500ms", wait)
+	}
+
+	if ok, _ := l.Allow("b", now); !ok {
+		t.Error("other key denied along with a")
+	}
+}
+
+func TestLimiterRefill(t *testing.T) {
+	l := NewLimiter(2, 3)
+	now := time.Unix(1700000000, 0)
+
+	for range 3 {
+		l.Allow("a", now)
+	}
+
+	tests := []struct {
+		name     string
+		after    time.Duration
+		want     bool
+		wantWait time.Duration
+	}{
+		{name: "before a token", after: 250 * time.Millisecond, want: false, wantWait: 250 * time.Millisecond},
+		{name: "a token refilled", after: 500 * time.Millisecond, want: true},
+		{name: "spent again", after: 500 * time.Millisecond, want: false, wantWait: 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		ok, wait := l.Allow("a", now.Add(tt.after))
+		if ok != tt.want || wait != tt.wantWait {
+			t.Errorf("%s: Allow() = %v, %v, want %v, %v", tt.name, ok, wait, tt.want, tt.wantWait)
+		}
+	}
+}
+
+func TestLimiterRefillCapped(t *testing.T) {
+	l := NewLimiter(2, 3)
+	now := time.Unix(1700000000, 0)
+
+	l.Allow("a", now)
+	now = now.Add(time.Hour)
+
+	allowed := 0
+	for range 10 {
+		if ok, _ := l.Allow("a", now); ok {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("%d requests allowed after a long idle, want the burst of 3", allowed)
+	}
+}
+
+func TestLimiterMinimumBurst(t *testing.T) {
+	l := NewLimiter(1, 0)
+	now := time.Unix(1700000000, 0)
+
+	if ok, _ := l.Allow("a", now); !ok {
+		t.Fatal("first request denied with a burst of 0")
+	}
+	if ok, _ := l.Allow("a", now); ok {
+		t.Error("second request allowed with a burst of 0")
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	l := NewLimiter(1, 2)
+	now := time.Unix(1700000000, 0)
+
+	l.Allow("idle", now)
+	l.Allow("busy", now)
+
+	// idle has filled up again by then, busy is still short of its burst
+	now = now.Add(sweepPeriod)
+	l.Allow("busy", now.Add(-time.Second))
+	l.Allow("new", now)
+
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("full bucket not swept")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("bucket still refilling swept")
+	}
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type lockoutEntry struct {
+	failures    int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+// Lockout locks a key out once it failed maxFailures times within window,
+// for duration.
+//
+// Entries are per process: with prefork every child has its own.
+type Lockout struct {
+	mu          sync.Mutex
+	maxFailures int
+	window      time.Duration
+	duration    time.Duration
+	entries     map[string]*lockoutEntry
+	lastSweep   time.Time
+}
+
+func NewLockout(maxFailures int, window, duration time.Duration) *Lockout {
+	return &Lockout{
+		maxFailures: max(maxFailures, 1),
+		window:      window,
+		duration:    duration,
+		entries:     make(map[string]*lockoutEntry),
+	}
+}
+
+// Locked returns how long key is still locked out for, or zero.
+func (l *Lockout) Locked(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok || !now.Before(e.lockedUntil) {
+		return 0
+	}
+	return e.lockedUntil.Sub(now)
+}
+
+// Failure counts a failure of key and reports whether it locked key out.
+func (l *Lockout) Failure(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	e, ok := l.entries[key]
+	if !ok {
+		e = &lockoutEntry{first: now}
+		l.entries[key] = e
+	} else if now.Sub(e.first) >= l.window {
+		// a new window, but a lock still running is kept
+		e.failures, e.first = 0, now
+	}
+	e.failures++
+	if e.failures < l.maxFailures {
+		return false
+	}
+
+	e.failures = 0
+	e.first = now
+	e.lockedUntil = now.Add(l.duration)
+	return true
+}
+
+// Reset forgets the failures of key.
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+func (l *Lockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepPeriod {
+		return
+	}
+	l.lastSweep = now
+
+	for key, e := range l.entries {
+		if now.Sub(e.first) >= l.window && !now.Before(e.lockedUntil) {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockout(t *testing.T) {
+	l := NewLockout(3, time.Minute, 5*time.Minute)
+	now := time.Unix(1700000000, 0)
+
+	for i := range 2 {
+		if l.Failure("a", now.Add(time.Duration(i)*time.Second)) {
+			t.Fatalf("failure %d locked out", i+1)
+		}
+	}
+	if d := l.Locked("a", now); d != 0 {
+		t.Fatalf("locked for %v before the last failure", d)
+	}
+	if !l.Failure("a", now.Add(2*time.Second)) {
+		t.Fatal("failure 3 did not lock out")
+	}
+	locked := now.Add(2 * time.Second)
+
+	tests := []struct {
+		name  string
+		after time.Duration
+		want  time.Duration
+	}{
+		{name: "at the lock", after: 0, want: 5 * time.Minute},
+		{name: "during the lock", after: time.Minute, want: 4 * time.Minute},
+		{name: "just before the end", after: 5*time.Minute - time.Nanosecond, want: time.Nanosecond},
+		{name: "at the end", after: 5 * time.Minute, want: 0},
+		{name: "after the end", after: time.Hour, want: 0},
+	}
+	for _, tt := range tests {
+		if got := l.Locked("a", locked.Add(tt.after)); got != tt.want {
+			t.Errorf("%s: Locked() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if d := l.Locked("b", now); d != 0 {
+		t.Errorf("other key locked for %v", d)
+	}
+}
+
+func TestLockoutWindow(t *testing.T) {
+	l := NewLockout(3, time.Minute, 5*time.Minute)
+	now := time.Unix(1700000000, 0)
+
+	l.Failure("a", now)
+	l.Failure("a", now.Add(30*time.Second))
+
+	// the first two fell out of the window, so this one starts counting over
+	if l.Failure("a", now.Add(time.Minute)) {
+		t.Fatal("failures outside the window locked out")
+	}
+	if l.Failure("a", now.Add(time.Minute+time.Second)) {
+		t.Fatal("second failure of the new window locked out")
+	}
+	if !l.Failure("a", now.Add(time.Minute+2*time.Second)) {
+		t.Error("third failure of the new window did not lock out")
+	}
+}
+
+func TestLockoutKeptAcrossWindows(t *testing.T) {
+	l := NewLockout(2, time.Minute, 5*time.Minute)
+	now := time.Unix(1700000000, 0)
+
+	l.Failure("a", now)
+	l.Failure("a", now)
+
+	// a failure racing the lock, after the window, must not lift it
+	if l.Failure("a", now.Add(2*time.Minute)) {
+		t.Fatal("single failure in a new window locked out")
+	}
+	if d := l.Locked("a", now.Add(2*time.Minute)); d != 3*time.Minute {
+		t.Errorf("Locked() = %v, want 3m0s", d)
+	}
+}
+
+func TestLockoutReset(t *testing.T) {
+	l := NewLockout(2, time.Minute, 5*time.Minute)
+	now := time.Unix(1700000000, 0)
+
+	l.Failure("a", now)
+	l.Reset("a")
+	if l.Failure("a", now) {
+		t.Error("failure before a reset still counted")
+	}
+
+	l.Failure("a", now)
+	l.Reset("a")
+	if d := l.Locked("a", now); d != 0 {
+		t.Errorf("locked for %v after a reset", d)
+	}
+}
+
+func TestLockoutMinimumFailures(t *testing.T) {
+	l := NewLockout(0, time.Minute, time.Minute)
+	if !l.Failure("a", time.Unix(1700000000, 0)) {
+		t.Error("first failure did not lock out with maxFailures of 0")
+	}
+}
+
+func TestLockoutSweep(t *testing.T) {
+	l := NewLockout(2, time.Minute, 5*time.Minute)
+	now := time.Unix(1700000000, 0)
+
+	l.Failure("stale", now)
+	l.Failure("locked", now)
+	l.Failure("locked", now)
+
+	l.Failure("new", now.Add(sweepPeriod))
+
+	if _, ok := l.entries["stale"]; ok {
+		t.Error("entry past its window not swept")
+	}
+	if _, ok := l.entries["locked"]; !ok {
+		t.Error("entry still locked swept")
+	}
+}
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"context"
+
+	auditv1 "github.com/vayzur/apadana/pkg/apis/audit/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+type AuditService struct {
+	// store is nil when events are not kept in storage.
+	store *resources.AuditStore
+}
+
+func NewAuditService(store *resources.AuditStore) *AuditService {
+	return &AuditService{store: store}
+}
+
+func (s *AuditService) GetEvents(ctx context.Context, opts auditv1.ListOptions) (*auditv1.EventList, error) {
+	if s.store == nil {
+		return nil, errs.ErrAuditDisabled
+	}
+	if opts.Since != nil && opts.Until != nil && !opts.Since.Before(*opts.Until) {
+		return nil, errs.ErrInvalidTimeRange
+	}
+	return s.store.GetEvents(ctx, opts)
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	satrapv1 "github.com/vayzur/apadana/pkg/apis/satrap/v1"
+	"github.com/vayzur/apadana/pkg/chapar/admission"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+type batchGroup struct {
+	nodeName string
+	tag      string
+	writes   []resources.UserWrite
+	// index maps every write back to its item
+	index []int
+}
+
+// BatchUsers applies items and returns an error, or nil, per item. Items are
+// grouped by inbound and applied in request order within each inbound.
+// Updates and deletes are prepared from the users as they were before the
+// batch, so an item cannot act on a user created earlier in the same batch.
+func (s *InboundService) BatchUsers(ctx context.Context, items []*satrapv1.InboundUserBatchOp) ([]error, error) {
+	if len(items) > satrapv1.MaxBatchItems {
+		return nil, errs.ErrBatchTooLarge
+	}
+
+	results := make([]error, len(items))
+	groups := make(map[[2]string]*batchGroup)
+	order := []*batchGroup{}
+	now := time.Now()
+
+	for i, item := range items {
+		w, err := s.prepareBatchOp(ctx, item, now)
+		if err != nil {
+			results[i] = err
+			continue
+		}
+
+		id := [2]string{item.NodeName, item.InboundTag}
+		g, ok := groups[id]
+		if !ok {
+			g = &batchGroup{nodeName: item.NodeName, tag: item.InboundTag}
+			groups[id] = g
+			order = append(order, g)
+		}
+		g.writes = append(g.writes, w)
+		g.index = append(g.index, i)
+	}
+
+	for _, g := range order {
+		inbound, err := s.parentInbound(ctx, g.nodeName, g.tag)
+		if err != nil {
+			for _, i := range g.index {
+				results[i] = err
+			}
+			continue
+		}
+
+		writes := make([]resources.UserWrite, 0, len(g.writes))
+		index := make([]int, 0, len(g.index))
+		for j, w := range g.writes {
+			if w.Action == satrapv1.BatchCreate {
+				if err := admission.ValidateUser(w.User, inbound); err != nil {
+					results[g.index[j]] = err
+					continue
+				}
+			}
+			writes = append(writes, w)
+			index = append(index, g.index[j])
+		}
+
+		for j, err := range s.store.ApplyUsers(ctx, g.nodeName, g.tag, writes, inbound.Spec.Capacity.MaxUsers) {
+			results[index[j]] = err
+		}
+	}
+
+	return results, nil
+}
+
+// prepareBatchOp validates item and turns it into the write to apply.
+func (s *InboundService) prepareBatchOp(ctx context.Context, item *satrapv1.InboundUserBatchOp, now time.Time) (resources.UserWrite, error) {
+	w := resources.UserWrite{}
+	if item == nil {
+		return w, errs.ErrInvalidBatchAction
+	}
+	if item.NodeName == "" {
+		return w, errs.ErrInvalidNode
+	}
+	if item.InboundTag == "" {
+		return w, errs.ErrInvalidInbound
+	}
+	w.Action = item.Action
+
+	switch item.Action {
+	case satrapv1.BatchCreate:
+		if item.User == nil || item.User.Spec.Email == "" {
+			return w, errs.ErrInvalidUser
+		}
+		if err := prepareUser(item.User, now); err != nil {
+			return w, err
+		}
+		w.User = item.User
+
+	case satrapv1.BatchUpdate:
+		if item.Email == "" {
+			return w, errs.ErrInvalidUser
+		}
+		user, err := s.store.GetUser(storage.WithQuorum(ctx), item.NodeName, item.InboundTag, item.Email)
+		if err != nil {
+			return w, err
+		}
+		if item.Metadata != nil {
+			setUserMetadata(user, item.Metadata)
+		}
+		if item.Spec != nil {
+			setUserSpec(user, item.Spec)
+		}
+		if item.ResourceVersion != "" {
+			user.Metadata.ResourceVersion = item.ResourceVersion
+		}
+		w.User = user
+
+	case satrapv1.BatchDelete:
+		if item.Email == "" {
+			return w, errs.ErrInvalidUser
+		}
+		// a given resourceVersion spares the read
+		if item.ResourceVersion != "" {
+			w.User = &satrapv1.InboundUser{}
+			w.User.Spec.Email = item.Email
+			w.User.Metadata.ResourceVersion = item.ResourceVersion
+			break
+		}
+		user, err := s.store.GetUser(storage.WithQuorum(ctx), item.NodeName, item.InboundTag, item.Email)
+		if err != nil {
+			return w, err
+		}
+		w.User = user
+
+	default:
+		return w, errs.ErrInvalidBatchAction
+	}
+
+	return w, nil
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	authv1 "github.com/vayzur/apadana/pkg/apis/auth/v1"
+	corev1 "github.com/vayzur/apadana/pkg/apis/core/v1"
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+	"github.com/vayzur/apadana/pkg/chapar/admission"
+	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+const (
+	tokenBytes       = 32
+	bootstrapIDBytes = 3
+)
+
+type CredentialService struct {
+	store *resources.CredentialStore
+}
+
+func NewCredentialService(store *resources.CredentialStore) *CredentialService {
+	return &CredentialService{store: store}
+}
+
+// CreateCredential stores credential with a newly generated token and returns
+// it, token included. The token cannot be read back later. Bootstrap tokens
+// are named by chapar when no name is given and expire after their ttl.
+func (s *CredentialService) CreateCredential(ctx context.Context, credential *authv1.Credential) (*authv1.Credential, error) {
+	now := time.Now()
+
+	if credential.Spec.Role == authv1.RoleBootstrap {
+		if credential.Metadata.Name == "" {
+			id, err := randomHex(bootstrapIDBytes)
+			if err != nil {
+				return nil, err
+			}
+			credential.Metadata.Name = "bootstrap-" + id
+		}
+		if credential.Spec.TTL == 0 {
+			credential.Spec.TTL = authv1.DefaultBootstrapTTL
+		}
+	}
+
+	if err := admission.ValidateCredential(credential); err != nil {
+		return nil, err
+	}
+
+	if credential.Spec.TTL > 0 {
+		expiresAt := now.Add(credential.Spec.TTL)
+		credential.Spec.ExpiresAt = &expiresAt
+	}
+	if err := s.issue(credential, now); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.CreateCredential(ctx, credential); err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// Authenticate returns the credential named name with its token, for
+// checking the signature of a request. Expired bootstrap tokens whose lease
+// has not run out yet are not found.
+func (s *CredentialService) Authenticate(ctx context.Context, name string) (*authv1.Credential, error) {
+	credential, err := s.store.GetCredential(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt := credential.Spec.ExpiresAt; expiresAt != nil && !time.Now().Before(*expiresAt) {
+		return nil, errs.ErrCredentialNotFound
+	}
+	return credential, nil
+}
+
+// JoinNode registers node for the holder of bootstrap, which is consumed,
+// and returns the node as stored with the credential it is issued. A node or
+// node credential that already exists is only replaced when bootstrap was
+// created for that node, which is how an admin lets a node join again.
+func (s *CredentialService) JoinNode(ctx context.Context, bootstrap *authv1.Credential, node *corev1.Node) (*authv1.NodeJoin, error) {
+	nodeName := node.Metadata.Name
+	if nodeName == "" {
+		return nil, errs.ErrInvalidNode
+	}
+	// the node name ends up in the auth header of the node
+	if strings.ContainsAny(nodeName, ":/ \t\r\n") {
+		return nil, errs.New(
+			errs.KindInvalid,
+			errs.ReasonValidationFailed,
+			"invalid node",
+			map[string]string{
+				"metadata.name": "must not contain ':', '/' or whitespace",
+			},
+			nil,
+		)
+	}
+	rejoin := bootstrap.Spec.NodeName != ""
+	if rejoin && bootstrap.Spec.NodeName != nodeName {
+		return nil, errs.New(
+			errs.KindInvalid,
+			errs.ReasonValidationFailed,
+			"invalid node",
+			map[string]string{
+				"metadata.name": "must be the node the bootstrap token was created for",
+			},
+			nil,
+		)
+	}
+
+	now := time.Now()
+	node.Metadata.UID = uuid.NewString()
+	node.Metadata.CreationTimestamp = now
+
+	credential := &authv1.Credential{
+		Metadata: metav1.ObjectMeta{Name: NodeCredentialName(nodeName)},
+		Spec: authv1.CredentialSpec{
+			Role:     authv1.RoleNode,
+			NodeName: nodeName,
+		},
+	}
+	if err := s.issue(credential, now); err != nil {
+		return nil, err
+	}
+
+	stored, err := s.store.JoinNode(ctx, bootstrap, node, credential, rejoin)
+	if err != nil {
+		return nil, err
+	}
+	return &authv1.NodeJoin{Node: stored, Credential: credential}, nil
+}
+
+// RevokeNodeCredentials deletes every credential bound to the node and
+// returns how many there were.
+func (s *CredentialService) RevokeNodeCredentials(ctx context.Context, nodeName string) (int, error) {
+	return s.store.DeleteNodeCredentials(ctx, nodeName)
+}
+
+// NodeCredentialName is the name of the credential a node is issued when it
+// joins.
+func NodeCredentialName(nodeName string) string {
+	return "node-" + nodeName
+}
+
+// issue fills in the metadata of a new credential and generates its token.
+func (s *CredentialService) issue(credential *authv1.Credential, now time.Time) error {
+	token, err := randomHex(tokenBytes)
+	if err != nil {
+		return err
+	}
+
+	credential.TypeMeta = metav1.TypeMeta{APIVersion: authv1.APIVersion, Kind: authv1.KindCredential}
+	credential.Metadata.UID = uuid.NewString()
+	credential.Metadata.CreationTimestamp = now
+	credential.Spec.Token = token
+	return nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *CredentialService) GetCredential(ctx context.Context, name string) (*authv1.Credential, error) {
+	credential, err := s.store.GetCredential(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	credential.Spec.Token = ""
+	return credential, nil
+}
+
+func (s *CredentialService) GetCredentials(ctx context.Context, opts metav1.ListOptions) (*authv1.CredentialList, error) {
+	list, err := s.store.GetCredentials(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, credential := range list.Items {
+		credential.Spec.Token = ""
+	}
+	return list, nil
+}
+
+func (s *CredentialService) DeleteCredential(ctx context.Context, name string) error {
+	return s.store.DeleteCredential(ctx, name)
+}
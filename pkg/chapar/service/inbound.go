@@ -6,20 +6,28 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	corev1 "github.com/vayzur/apadana/pkg/apis/core/v1"
 	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
 	satrapv1 "github.com/vayzur/apadana/pkg/apis/satrap/v1"
 	"github.com/vayzur/apadana/pkg/errs"
 
+	"github.com/vayzur/apadana/pkg/chapar/admission"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
 	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
 )
 
 type InboundService struct {
 	store *resources.InboundStore
+	nodes *resources.NodeStore
+	// ports is where ports are allocated on nodes without a port range label
+	ports resources.PortRange
 }
 
-func NewInboundService(store *resources.InboundStore) *InboundService {
+func NewInboundService(store *resources.InboundStore, nodes *resources.NodeStore, ports resources.PortRange) *InboundService {
 	return &InboundService{
 		store: store,
+		nodes: nodes,
+		ports: ports,
 	}
 }
 
@@ -27,14 +35,26 @@ func (s *InboundService) CountInbounds(ctx context.Context, nodeName string) (ui
 	return s.store.CountInbounds(ctx, nodeName)
 }
 
+// GetInbound returns the inbound with its remaining user capacity filled in.
 func (s *InboundService) GetInbound(ctx context.Context, nodeName, tag string) (*satrapv1.Inbound, error) {
-	return s.store.GetInbound(ctx, nodeName, tag)
+	inbound, err := s.store.GetInbound(ctx, nodeName, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxUsers := inbound.Spec.Capacity.MaxUsers; maxUsers > 0 {
+		count, err := s.store.CountUsers(ctx, nodeName, tag)
+		if err != nil {
+			return nil, err
+		}
+		inbound.Status = &satrapv1.InboundStatus{
+			Remaining: &satrapv1.InboundCapacity{MaxUsers: remaining(maxUsers, count)},
+		}
+	}
+	return inbound, nil
 }
 
 func (s *InboundService) DeleteInbound(ctx context.Context, nodeName, tag string) error {
-	if err := s.store.DeleteUsers(ctx, nodeName, tag); err != nil && !errors.Is(err, errs.ErrUserNotFound) {
-		return err
-	}
 	if err := s.store.DeleteInbound(ctx, nodeName, tag); err != nil && !errors.Is(err, errs.ErrInboundNotFound) {
 		return err
 	}
@@ -42,22 +62,81 @@ func (s *InboundService) DeleteInbound(ctx context.Context, nodeName, tag string
 }
 
 func (s *InboundService) CreateInbound(ctx context.Context, nodeName string, inbound *satrapv1.Inbound) error {
-	existingInbound, _ := s.GetInbound(ctx, nodeName, inbound.Spec.Config.Tag)
-	if existingInbound != nil {
-		return errs.ErrInboundConflict
+	if err := admission.ValidateInbound(inbound); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	expiresAt, err := expiry(inbound.Spec.ExpiresAt, inbound.Spec.TTL, now)
+	if err != nil {
+		return err
 	}
 
 	inbound.Metadata.UID = uuid.NewString()
-	inbound.Metadata.CreationTimestamp = time.Now()
+	inbound.Metadata.CreationTimestamp = now
+	inbound.Spec.ExpiresAt = expiresAt
+
+	var maxInbounds uint32
+	ports := s.ports
+	node, err := s.nodes.GetNode(storage.WithQuorum(ctx), nodeName)
+	switch {
+	case err == nil:
+		maxInbounds = node.Status.Capacity.MaxInbounds
+		if ports, err = nodePortRange(node, s.ports); err != nil {
+			return err
+		}
+	case !errors.Is(err, errs.ErrNodeNotFound):
+		return err
+	}
 
-	if err := s.store.CreateInbound(ctx, nodeName, inbound); err != nil {
+	if err := s.store.CreateInbound(ctx, nodeName, inbound, maxInbounds, ports); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *InboundService) GetInbounds(ctx context.Context, nodeName string) ([]*satrapv1.Inbound, error) {
-	return s.store.GetInbounds(ctx, nodeName)
+// nodePortRange returns the range set by the port range label of node, or
+// def when it has none.
+func nodePortRange(node *corev1.Node, def resources.PortRange) (resources.PortRange, error) {
+	label, ok := node.Metadata.Labels[corev1.LabelPortRange]
+	if !ok {
+		return def, nil
+	}
+	ports, err := resources.ParsePortRange(label)
+	if err != nil {
+		return resources.PortRange{}, errs.New(
+			errs.KindInvalid,
+			errs.ReasonValidationFailed,
+			"invalid node port range",
+			map[string]string{
+				"metadata.labels." + corev1.LabelPortRange: err.Error(),
+			},
+			nil,
+		)
+	}
+	return ports, nil
+}
+
+func (s *InboundService) GetInbounds(ctx context.Context, nodeName string, opts metav1.ListOptions) (*satrapv1.InboundList, error) {
+	return s.store.GetInbounds(ctx, nodeName, opts)
+}
+
+func (s *InboundService) GetClusterInbounds(ctx context.Context, opts metav1.ListOptions) (*satrapv1.ClusterInboundList, error) {
+	return s.store.GetClusterInbounds(ctx, opts)
+}
+
+// GetClusterUsers lists users across all nodes. With an email only the users
+// with that email are returned, in a single page.
+func (s *InboundService) GetClusterUsers(ctx context.Context, email string, opts metav1.ListOptions) (*satrapv1.ClusterInboundUserList, error) {
+	if email != "" {
+		return s.store.FindUsers(ctx, email, opts.LabelSelector)
+	}
+	return s.store.GetClusterUsers(ctx, opts)
+}
+
+func (s *InboundService) WatchInbounds(ctx context.Context, nodeName string, fromRevision int64) (<-chan metav1.WatchEvent, error) {
+	return s.store.WatchInbounds(ctx, nodeName, fromRevision)
 }
 
 func (s *InboundService) GetUser(ctx context.Context, nodeName, tag, email string) (*satrapv1.InboundUser, error) {
@@ -76,27 +155,54 @@ func (s *InboundService) DeleteUser(ctx context.Context, nodeName, tag, email st
 }
 
 func (s *InboundService) CreateUser(ctx context.Context, nodeName, tag string, user *satrapv1.InboundUser) error {
-	existingUser, _ := s.GetUser(ctx, nodeName, user.Spec.InboundTag, user.Spec.Email)
-	if existingUser != nil {
-		return errs.ErrUserConflict
+	inbound, err := s.parentInbound(ctx, nodeName, tag)
+	if err != nil {
+		return err
 	}
 
-	user.Metadata.UID = uuid.NewString()
-	user.Metadata.CreationTimestamp = time.Now()
+	if err := admission.ValidateUser(user, inbound); err != nil {
+		return err
+	}
+	if err := prepareUser(user, time.Now()); err != nil {
+		return err
+	}
 
-	if err := s.store.CreateUser(ctx, nodeName, tag, user); err != nil {
+	if err := s.store.CreateUser(ctx, nodeName, tag, user, inbound.Spec.Capacity.MaxUsers); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s *InboundService) GetUsers(ctx context.Context, nodeName, tag string) ([]*satrapv1.InboundUser, error) {
-	return s.store.GetUsers(ctx, nodeName, tag)
+// prepareUser fills in the fields the server owns on a new user.
+func prepareUser(user *satrapv1.InboundUser, now time.Time) error {
+	expiresAt, err := expiry(user.Spec.ExpiresAt, user.Spec.TTL, now)
+	if err != nil {
+		return err
+	}
+
+	user.Metadata.UID = uuid.NewString()
+	user.Metadata.CreationTimestamp = now
+	user.Spec.ExpiresAt = expiresAt
+	return nil
+}
+
+// parentInbound returns the inbound users are written to, or
+// errs.ErrInboundNotFound.
+func (s *InboundService) parentInbound(ctx context.Context, nodeName, tag string) (*satrapv1.Inbound, error) {
+	return s.store.GetInbound(storage.WithQuorum(ctx), nodeName, tag)
+}
+
+func (s *InboundService) GetUsers(ctx context.Context, nodeName, tag string, opts metav1.ListOptions) (*satrapv1.InboundUserList, error) {
+	return s.store.GetUsers(ctx, nodeName, tag, opts)
+}
+
+func (s *InboundService) WatchUsers(ctx context.Context, nodeName, tag string, fromRevision int64) (<-chan metav1.WatchEvent, error) {
+	return s.store.WatchUsers(ctx, nodeName, tag, fromRevision)
 }
 
 func (s *InboundService) UpdateInboundMetadata(ctx context.Context, nodeName, tag string, newMetadata *metav1.ObjectMeta) error {
-	inbound, err := s.GetInbound(ctx, nodeName, tag)
+	inbound, err := s.store.GetInbound(storage.WithQuorum(ctx), nodeName, tag)
 	if err != nil {
 		return err
 	}
@@ -104,48 +210,156 @@ func (s *InboundService) UpdateInboundMetadata(ctx context.Context, nodeName, ta
 	newMetadata.Name = inbound.Metadata.Name
 	newMetadata.UID = inbound.Metadata.UID
 	newMetadata.CreationTimestamp = inbound.Metadata.CreationTimestamp
+	if newMetadata.ResourceVersion == "" {
+		newMetadata.ResourceVersion = inbound.Metadata.ResourceVersion
+	}
 
 	inbound.Metadata = *newMetadata
-	return s.store.CreateInbound(ctx, nodeName, inbound)
+	return s.store.UpdateInbound(ctx, nodeName, inbound)
 }
 
-func (s *InboundService) UpdateInboundSpec(ctx context.Context, nodeName, tag string, newSpec *satrapv1.InboundSpec) error {
-	inbound, err := s.GetInbound(ctx, nodeName, tag)
+func (s *InboundService) UpdateInboundSpec(ctx context.Context, nodeName, tag string, newSpec *satrapv1.InboundSpec, resourceVersion string) error {
+	inbound, err := s.store.GetInbound(storage.WithQuorum(ctx), nodeName, tag)
 	if err != nil {
 		return err
 	}
 
+	if resourceVersion != "" {
+		inbound.Metadata.ResourceVersion = resourceVersion
+	}
+
 	newSpec.Config = inbound.Spec.Config
+	newSpec.TTL = inbound.Spec.TTL
+	newSpec.ExpiresAt = inbound.Spec.ExpiresAt
 
 	inbound.Spec = *newSpec
-	return s.store.CreateInbound(ctx, nodeName, inbound)
+	return s.store.UpdateInbound(ctx, nodeName, inbound)
 }
 
 func (s *InboundService) UpdateUserMetadata(ctx context.Context, nodeName, tag, email string, newMetadata *metav1.ObjectMeta) error {
-	user, err := s.GetUser(ctx, nodeName, tag, email)
+	user, err := s.GetUser(storage.WithQuorum(ctx), nodeName, tag, email)
+	if err != nil {
+		return err
+	}
+
+	setUserMetadata(user, newMetadata)
+	return s.store.UpdateUser(ctx, nodeName, tag, user)
+}
+
+func (s *InboundService) UpdateUserSpec(ctx context.Context, nodeName, tag, email string, newSpec *satrapv1.InboundUserSpec, resourceVersion string) error {
+	user, err := s.GetUser(storage.WithQuorum(ctx), nodeName, tag, email)
 	if err != nil {
 		return err
 	}
 
+	if resourceVersion != "" {
+		user.Metadata.ResourceVersion = resourceVersion
+	}
+
+	setUserSpec(user, newSpec)
+	return s.store.UpdateUser(ctx, nodeName, tag, user)
+}
+
+// setUserMetadata replaces the metadata of user with newMetadata, keeping the
+// fields owned by the server.
+func setUserMetadata(user *satrapv1.InboundUser, newMetadata *metav1.ObjectMeta) {
 	newMetadata.Name = user.Metadata.Name
 	newMetadata.UID = user.Metadata.UID
 	newMetadata.CreationTimestamp = user.Metadata.CreationTimestamp
+	if newMetadata.ResourceVersion == "" {
+		newMetadata.ResourceVersion = user.Metadata.ResourceVersion
+	}
 
 	user.Metadata = *newMetadata
-	return s.store.CreateUser(ctx, nodeName, tag, user)
 }
 
-func (s *InboundService) UpdateUserSpec(ctx context.Context, nodeName, tag, email string, newSpec *satrapv1.InboundUserSpec) error {
-	user, err := s.GetUser(ctx, nodeName, tag, email)
-	if err != nil {
-		return err
-	}
-
+// setUserSpec replaces the spec of user with newSpec, keeping the fields that
+// cannot change once the user exists.
+func setUserSpec(user *satrapv1.InboundUser, newSpec *satrapv1.InboundUserSpec) {
 	newSpec.Type = user.Spec.Type
 	newSpec.InboundTag = user.Spec.InboundTag
 	newSpec.Email = user.Spec.Email
 	newSpec.Account = user.Spec.Account
+	newSpec.TTL = user.Spec.TTL
+	newSpec.ExpiresAt = user.Spec.ExpiresAt
 
 	user.Spec = *newSpec
-	return s.store.CreateUser(ctx, nodeName, tag, user)
+}
+
+// RenewInbound makes the inbound expire ttl from now, whether or not it had
+// an expiry before.
+func (s *InboundService) RenewInbound(ctx context.Context, nodeName, tag string, ttl time.Duration, resourceVersion string) (*satrapv1.Inbound, error) {
+	if ttl <= 0 {
+		return nil, errs.ErrInvalidExpiry
+	}
+
+	inbound, err := s.store.GetInbound(storage.WithQuorum(ctx), nodeName, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	if resourceVersion != "" {
+		inbound.Metadata.ResourceVersion = resourceVersion
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	inbound.Spec.TTL = ttl
+	inbound.Spec.ExpiresAt = &expiresAt
+
+	if err := s.store.RenewInbound(ctx, nodeName, inbound); err != nil {
+		return nil, err
+	}
+	return inbound, nil
+}
+
+// RenewUser makes the user expire ttl from now, whether or not it had an
+// expiry before.
+func (s *InboundService) RenewUser(ctx context.Context, nodeName, tag, email string, ttl time.Duration, resourceVersion string) (*satrapv1.InboundUser, error) {
+	if ttl <= 0 {
+		return nil, errs.ErrInvalidExpiry
+	}
+
+	user, err := s.GetUser(storage.WithQuorum(ctx), nodeName, tag, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if resourceVersion != "" {
+		user.Metadata.ResourceVersion = resourceVersion
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	user.Spec.TTL = ttl
+	user.Spec.ExpiresAt = &expiresAt
+
+	if err := s.store.RenewUser(ctx, nodeName, tag, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// expiry decides when a new object expires: an explicit expiresAt wins,
+// otherwise a positive ttl counts from now. Nil means it never expires.
+func expiry(expiresAt *time.Time, ttl time.Duration, now time.Time) (*time.Time, error) {
+	switch {
+	case expiresAt != nil:
+		if !expiresAt.After(now) {
+			return nil, errs.ErrInvalidExpiry
+		}
+		return expiresAt, nil
+	case ttl < 0:
+		return nil, errs.ErrInvalidExpiry
+	case ttl > 0:
+		t := now.Add(ttl)
+		return &t, nil
+	}
+	return nil, nil
+}
+
+// remaining returns how much of limit is left with used taken.
+func remaining(limit, used uint32) uint32 {
+	if used >= limit {
+		return 0
+	}
+	return limit - used
 }
@@ -7,19 +7,34 @@ import (
 	"github.com/google/uuid"
 	corev1 "github.com/vayzur/apadana/pkg/apis/core/v1"
 	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
 	"github.com/vayzur/apadana/pkg/chapar/storage/resources"
 )
 
 type NodeService struct {
-	store *resources.NodeStore
+	store    *resources.NodeStore
+	inbounds *resources.InboundStore
 }
 
-func NewNodeService(store *resources.NodeStore) *NodeService {
-	return &NodeService{store: store}
+func NewNodeService(store *resources.NodeStore, inbounds *resources.InboundStore) *NodeService {
+	return &NodeService{store: store, inbounds: inbounds}
 }
 
+// GetNode returns the node with its remaining inbound capacity filled in.
 func (s *NodeService) GetNode(ctx context.Context, nodeName string) (*corev1.Node, error) {
-	return s.store.GetNode(ctx, nodeName)
+	node, err := s.store.GetNode(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxInbounds := node.Status.Capacity.MaxInbounds; maxInbounds > 0 {
+		count, err := s.inbounds.CountInbounds(ctx, nodeName)
+		if err != nil {
+			return nil, err
+		}
+		node.Status.Remaining = &corev1.NodeCapacity{MaxInbounds: remaining(maxInbounds, count)}
+	}
+	return node, nil
 }
 
 func (s *NodeService) DeleteNode(ctx context.Context, nodeName string) error {
@@ -27,12 +42,13 @@ func (s *NodeService) DeleteNode(ctx context.Context, nodeName string) error {
 }
 
 func (s *NodeService) CreateNode(ctx context.Context, node *corev1.Node) error {
-	existingNode, _ := s.GetNode(ctx, node.Metadata.Name)
+	existingNode, _ := s.store.GetNode(storage.WithQuorum(ctx), node.Metadata.Name)
 	if existingNode != nil {
 		node.Metadata.Name = existingNode.Metadata.Name
 		node.Metadata.UID = existingNode.Metadata.UID
 		node.Metadata.CreationTimestamp = existingNode.Metadata.CreationTimestamp
-		return s.store.CreateNode(ctx, node)
+		node.Metadata.ResourceVersion = existingNode.Metadata.ResourceVersion
+		return s.store.UpdateNode(ctx, node)
 	}
 
 	node.Metadata.UID = uuid.NewString()
@@ -41,16 +57,22 @@ func (s *NodeService) CreateNode(ctx context.Context, node *corev1.Node) error {
 	return s.store.CreateNode(ctx, node)
 }
 
-func (s *NodeService) GetNodes(ctx context.Context) ([]*corev1.Node, error) {
-	return s.store.GetNodes(ctx)
+func (s *NodeService) GetNodes(ctx context.Context, opts metav1.ListOptions) (*corev1.NodeList, error) {
+	return s.store.GetNodes(ctx, opts)
+}
+
+func (s *NodeService) WatchNodes(ctx context.Context, fromRevision int64) (<-chan metav1.WatchEvent, error) {
+	return s.store.WatchNodes(ctx, fromRevision)
 }
 
 func (s *NodeService) GetActiveNodes(ctx context.Context) ([]*corev1.Node, error) {
-	nodes, err := s.GetNodes(ctx)
+	list, err := s.GetNodes(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
 
+	nodes := list.Items
+
 	n := len(nodes)
 
 	activeNodes := make([]*corev1.Node, 0, n) // preallocated, no zeroing
@@ -65,18 +87,24 @@ func (s *NodeService) GetActiveNodes(ctx context.Context) ([]*corev1.Node, error
 	return activeNodes, nil
 }
 
-func (s *NodeService) UpdateNodeStatus(ctx context.Context, nodeName string, newStatus *corev1.NodeStatus) error {
-	node, err := s.GetNode(ctx, nodeName)
+// UpdateNodeStatus replaces the status of a node. A non-empty resourceVersion
+// is used as the precondition instead of the version that was just read.
+func (s *NodeService) UpdateNodeStatus(ctx context.Context, nodeName string, newStatus *corev1.NodeStatus, resourceVersion string) error {
+	node, err := s.store.GetNode(storage.WithQuorum(ctx), nodeName)
 	if err != nil {
 		return err
 	}
 
+	if resourceVersion != "" {
+		node.Metadata.ResourceVersion = resourceVersion
+	}
+
 	node.Status = *newStatus
-	return s.store.CreateNode(ctx, node)
+	return s.store.UpdateNode(ctx, node)
 }
 
 func (s *NodeService) UpdateNodeMetadata(ctx context.Context, nodeName string, newMetadata *metav1.ObjectMeta) error {
-	node, err := s.GetNode(ctx, nodeName)
+	node, err := s.store.GetNode(storage.WithQuorum(ctx), nodeName)
 	if err != nil {
 		return err
 	}
@@ -84,7 +112,10 @@ func (s *NodeService) UpdateNodeMetadata(ctx context.Context, nodeName string, n
 	newMetadata.Name = node.Metadata.Name
 	newMetadata.UID = node.Metadata.UID
 	newMetadata.CreationTimestamp = node.Metadata.CreationTimestamp
+	if newMetadata.ResourceVersion == "" {
+		newMetadata.ResourceVersion = node.Metadata.ResourceVersion
+	}
 
 	node.Metadata = *newMetadata
-	return s.store.CreateNode(ctx, node)
+	return s.store.UpdateNode(ctx, node)
 }
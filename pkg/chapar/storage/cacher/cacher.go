@@ -0,0 +1,396 @@
+package cacher
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/btree"
+	zlog "github.com/rs/zerolog/log"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+const (
+	listPageSize = 500
+
+	// observeTimeout bounds how long a write waits for its own event before
+	// returning. Past it, reads from the cache may briefly miss the write.
+	observeTimeout = 2 * time.Second
+
+	resyncBackoff = time.Second
+)
+
+var errWatchClosed = errors.New("watch closed")
+
+// Cacher is a storage.Interface that serves Get, GetList and Count from an
+// in-memory copy of every key under prefix. The copy is primed by a list and
+// kept current by a watch from the list revision, so every read reflects a
+// single revision. Writes and Watch go to the underlying store; writes return
+// once their change has reached the cache, so clients read their own writes.
+//
+// Reads with a context from storage.WithQuorum, reads outside prefix or
+// under an excluded prefix, and reads made while the cache is (re)syncing are
+// served by the underlying store.
+type Cacher struct {
+	store  storage.Interface
+	prefix string
+	// exclude lists prefixes under prefix that are not cached, for keys
+	// that are rarely read but would grow the cache without bound.
+	exclude []string
+
+	mu       sync.RWMutex
+	items    *btree.BTreeG[*storage.KeyValue]
+	revision int64
+	ready    bool
+	// changed is closed and replaced every time the cache moves forward.
+	changed chan struct{}
+}
+
+func New(store storage.Interface, prefix string, exclude ...string) *Cacher {
+	return &Cacher{
+		store:   store,
+		prefix:  prefix,
+		exclude: exclude,
+		items:   newTree(),
+		changed: make(chan struct{}),
+	}
+}
+
+func newTree() *btree.BTreeG[*storage.KeyValue] {
+	return btree.NewG(32, func(a, b *storage.KeyValue) bool {
+		return a.Key < b.Key
+	})
+}
+
+// Run keeps the cache in sync until ctx is done. A failed or compacted watch
+// drops the cache and primes it again from a fresh list.
+func (c *Cacher) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := c.sync(ctx)
+		c.setReady(false)
+		if ctx.Err() != nil {
+			return
+		}
+
+		zlog.Warn().
+			Err(err).
+			Str("component", "cacher").
+			Str("prefix", c.prefix).
+			Msg("resyncing")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(resyncBackoff):
+		}
+	}
+}
+
+func (c *Cacher) sync(ctx context.Context) error {
+	items := newTree()
+	list := &storage.List{}
+	opts := storage.ListOptions{Limit: listPageSize}
+	var revision int64
+
+	for {
+		if err := c.store.GetList(ctx, c.prefix, opts, list); err != nil {
+			return err
+		}
+		if revision == 0 {
+			revision = list.Revision
+		}
+		for _, kv := range list.Items {
+			if !c.excluded(kv.Key) {
+				items.ReplaceOrInsert(kv)
+			}
+		}
+		if list.Continue == "" {
+			break
+		}
+		opts.Continue = list.Continue
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, err := c.store.Watch(watchCtx, c.prefix, revision+1)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.items = items
+	c.revision = revision
+	c.ready = true
+	c.notify()
+	c.mu.Unlock()
+
+	zlog.Info().
+		Str("component", "cacher").
+		Str("prefix", c.prefix).
+		Int("items", items.Len()).
+		Int64("revision", revision).
+		Msg("synced")
+
+	for ev := range events {
+		if ev.Type == storage.EventError {
+			return ev.Err
+		}
+		c.apply(ev)
+	}
+	return errWatchClosed
+}
+
+func (c *Cacher) apply(ev storage.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case c.excluded(ev.Key):
+		// only the revision moves forward
+	case ev.Type == storage.EventDeleted:
+		c.items.Delete(&storage.KeyValue{Key: ev.Key})
+	default:
+		kv := ev.KeyValue
+		c.items.ReplaceOrInsert(&kv)
+	}
+	if ev.Revision > c.revision {
+		c.revision = ev.Revision
+	}
+	c.notify()
+}
+
+func (c *Cacher) setReady(ready bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ready = ready
+	c.notify()
+}
+
+// notify wakes writers waiting in observe. c.mu must be held for writing.
+func (c *Cacher) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// cached reports whether a read of key can be served from the cache. On
+// true c.mu is held for reading and must be released by the caller.
+func (c *Cacher) cached(ctx context.Context, key string) bool {
+	if storage.IsQuorum(ctx) || !strings.HasPrefix(key, c.prefix) || c.excluded(key) {
+		return false
+	}
+	c.mu.RLock()
+	if !c.ready {
+		c.mu.RUnlock()
+		return false
+	}
+	return true
+}
+
+// excluded reports whether key, or every key under it, is left out of the
+// cache.
+func (c *Cacher) excluded(key string) bool {
+	for _, prefix := range c.exclude {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Cacher) Get(ctx context.Context, key string, out *storage.KeyValue) error {
+	if !c.cached(ctx, key) {
+		return c.store.Get(ctx, key, out)
+	}
+	defer c.mu.RUnlock()
+
+	kv, ok := c.items.Get(&storage.KeyValue{Key: key})
+	if !ok {
+		return errs.ErrResourceNotFound
+	}
+	*out = *kv
+	return nil
+}
+
+// GetList serves first pages from the cache. Their continue tokens carry the
+// cache revision, so follow-up pages are read from the underlying store at
+// that same revision.
+func (c *Cacher) GetList(ctx context.Context, prefix string, opts storage.ListOptions, out *storage.List) error {
+	if opts.Continue != "" || !c.cached(ctx, prefix) {
+		return c.store.GetList(ctx, prefix, opts, out)
+	}
+	defer c.mu.RUnlock()
+
+	*out = storage.List{Revision: c.revision}
+	var count int64
+	c.items.AscendGreaterOrEqual(&storage.KeyValue{Key: max(prefix, opts.StartKey)}, func(kv *storage.KeyValue) bool {
+		if !strings.HasPrefix(kv.Key, prefix) || opts.EndKey != "" && kv.Key >= opts.EndKey {
+			return false
+		}
+		count++
+		if opts.Limit <= 0 || count <= opts.Limit {
+			// callers own what they are handed, as with Get; the cached
+			// item must not change under them, or they under it
+			item := *kv
+			out.Items = append(out.Items, &item)
+		}
+		return true
+	})
+
+	if opts.Limit > 0 && count > opts.Limit {
+		last := out.Items[len(out.Items)-1].Key
+		out.Continue = storage.EncodeContinue(c.revision, last+"\x00")
+		out.RemainingItemCount = count - opts.Limit
+	}
+	return nil
+}
+
+func (c *Cacher) Grant(ctx context.Context, ttl uint64) (int64, error) {
+	return c.store.Grant(ctx, ttl)
+}
+
+func (c *Cacher) Count(ctx context.Context, key string) (uint32, error) {
+	if !c.cached(ctx, key) {
+		return c.store.Count(ctx, key)
+	}
+	defer c.mu.RUnlock()
+
+	var count uint32
+	c.items.AscendGreaterOrEqual(&storage.KeyValue{Key: key}, func(kv *storage.KeyValue) bool {
+		if !strings.HasPrefix(kv.Key, key) {
+			return false
+		}
+		count++
+		return true
+	})
+	return count, nil
+}
+
+func (c *Cacher) Create(ctx context.Context, key string, obj []byte, ttl uint64) error {
+	return c.write(ctx, func() error {
+		return c.store.Create(ctx, key, obj, ttl)
+	}, storage.CreateOp(key, obj, ttl))
+}
+
+func (c *Cacher) Update(ctx context.Context, key string, obj []byte, ttl uint64, resourceVersion int64) error {
+	return c.write(ctx, func() error {
+		return c.store.Update(ctx, key, obj, ttl, resourceVersion)
+	}, storage.UpdateOp(key, obj, ttl, resourceVersion))
+}
+
+func (c *Cacher) Delete(ctx context.Context, key string) error {
+	return c.write(ctx, func() error {
+		return c.store.Delete(ctx, key)
+	}, storage.DeleteOp(key))
+}
+
+func (c *Cacher) Txn(ctx context.Context, ops ...storage.Op) error {
+	return c.write(ctx, func() error {
+		return c.store.Txn(ctx, ops...)
+	}, ops...)
+}
+
+func (c *Cacher) Watch(ctx context.Context, prefix string, fromRevision int64) (<-chan storage.Event, error) {
+	return c.store.Watch(ctx, prefix, fromRevision)
+}
+
+func (c *Cacher) ReadinessCheck() error {
+	return c.store.ReadinessCheck()
+}
+
+// write runs fn and, when it succeeds, waits until every op is reflected in
+// the cache.
+func (c *Cacher) write(ctx context.Context, fn func() error, ops ...storage.Op) error {
+	c.mu.RLock()
+	before := make([]int64, len(ops))
+	for i, op := range ops {
+		before[i] = max(c.lastRevision(op.Key), op.ResourceVersion)
+	}
+	c.mu.RUnlock()
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	c.observe(ctx, func() bool {
+		for i, op := range ops {
+			if !c.reflects(op, before[i]) {
+				return false
+			}
+		}
+		return true
+	})
+	return nil
+}
+
+// lastRevision returns the highest revision cached for key, or for any key
+// under it when it ends with a slash. c.mu must be held.
+func (c *Cacher) lastRevision(key string) int64 {
+	var rev int64
+	c.items.AscendGreaterOrEqual(&storage.KeyValue{Key: key}, func(kv *storage.KeyValue) bool {
+		if kv.Key != key && !(strings.HasSuffix(key, "/") && strings.HasPrefix(kv.Key, key)) {
+			return false
+		}
+		rev = max(rev, kv.Revision)
+		return true
+	})
+	return rev
+}
+
+// reflects reports whether op, committed after revision before, is visible
+// in the cache. A key written again since then counts as reflected too.
+// c.mu must be held.
+func (c *Cacher) reflects(op storage.Op, before int64) bool {
+	if c.excluded(op.Key) {
+		return true
+	}
+	if op.Type != storage.OpDelete {
+		kv, ok := c.items.Get(&storage.KeyValue{Key: op.Key})
+		return ok && kv.Revision > before
+	}
+
+	reflected := true
+	c.items.AscendGreaterOrEqual(&storage.KeyValue{Key: op.Key}, func(kv *storage.KeyValue) bool {
+		if kv.Key != op.Key && !(strings.HasSuffix(op.Key, "/") && strings.HasPrefix(kv.Key, op.Key)) {
+			return false
+		}
+		if kv.Revision <= before {
+			reflected = false
+			return false
+		}
+		return true
+	})
+	return reflected
+}
+
+// observe blocks until done holds, the cache stops being ready, ctx is done
+// or observeTimeout passes.
+func (c *Cacher) observe(ctx context.Context, done func() bool) {
+	timer := time.NewTimer(observeTimeout)
+	defer timer.Stop()
+
+	for {
+		c.mu.RLock()
+		if !c.ready || done() {
+			c.mu.RUnlock()
+			return
+		}
+		changed := c.changed
+		c.mu.RUnlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			zlog.Warn().
+				Str("component", "cacher").
+				Str("prefix", c.prefix).
+				Msg("write not observed in time")
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+type ListOptions struct {
+	Limit    int64
+	Continue string
+	// StartKey and EndKey narrow the list to the keys under the prefix from
+	// StartKey, up to but excluding EndKey. Either may be empty. Pages after
+	// the first start from their continue token but still stop at EndKey.
+	StartKey string
+	EndKey   string
+}
+
+type List struct {
+	Items              []*KeyValue
+	Revision           int64
+	Continue           string
+	RemainingItemCount int64
+}
+
+type continueToken struct {
+	Revision int64  `json:"rev"`
+	StartKey string `json:"start"`
+}
+
+// EncodeContinue builds an opaque token for resuming a list at startKey from
+// the snapshot at revision.
+func EncodeContinue(revision int64, startKey string) string {
+	data, _ := json.Marshal(&continueToken{Revision: revision, StartKey: startKey})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeContinue validates token against the prefix being listed and returns
+// the snapshot revision and the key to resume from.
+func DecodeContinue(token, prefix string) (int64, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, "", errs.ErrInvalidContinue
+	}
+
+	t := &continueToken{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return 0, "", errs.ErrInvalidContinue
+	}
+
+	if t.Revision <= 0 || !strings.HasPrefix(t.StartKey, prefix) {
+		return 0, "", errs.ErrInvalidContinue
+	}
+
+	return t.Revision, t.StartKey, nil
+}
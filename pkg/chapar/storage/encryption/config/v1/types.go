@@ -0,0 +1,13 @@
+package v1
+
+type EncryptionKey struct {
+	Name string `mapstructure:"name" yaml:"name"`
+	// Secret is the base64 encoded AES key, 16, 24 or 32 bytes long.
+	Secret string `mapstructure:"secret" yaml:"secret"`
+}
+
+type EncryptionConfig struct {
+	// Keys are tried by name when reading; the first one encrypts every new
+	// write. Leaving it empty stores values in plain text.
+	Keys []EncryptionKey `mapstructure:"keys" yaml:"keys"`
+}
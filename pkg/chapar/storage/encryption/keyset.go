@@ -0,0 +1,162 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	encryptionconfigv1 "github.com/vayzur/apadana/pkg/chapar/storage/encryption/config/v1"
+)
+
+// prefix marks encrypted values; it is followed by the key name, a colon,
+// the wrapped data key and the sealed value.
+const prefix = "enc:aesgcm:v1:"
+
+const dataKeySize = 32
+
+// KeySet does envelope encryption: every value is sealed with its own random
+// data key, and only that data key is encrypted with a configured key.
+type KeySet struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+func NewKeySet(cfg *encryptionconfigv1.EncryptionConfig) (*KeySet, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("no encryption keys configured")
+	}
+
+	ks := &KeySet{
+		primary: cfg.Keys[0].Name,
+		keys:    make(map[string]cipher.AEAD, len(cfg.Keys)),
+	}
+
+	for _, k := range cfg.Keys {
+		if k.Name == "" || strings.Contains(k.Name, ":") {
+			return nil, fmt.Errorf("invalid encryption key name %q", k.Name)
+		}
+		if _, ok := ks.keys[k.Name]; ok {
+			return nil, fmt.Errorf("duplicate encryption key %q", k.Name)
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(k.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", k.Name, err)
+		}
+		aead, err := newAEAD(secret)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q: %w", k.Name, err)
+		}
+		ks.keys[k.Name] = aead
+	}
+
+	return ks, nil
+}
+
+// Encrypt seals plaintext with the primary key. The storage key is bound as
+// additional data so a value cannot be copied under another key.
+func (ks *KeySet) Encrypt(key string, plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := seal(ks.keys[ks.primary], dataKey, []byte(ks.primary))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := seal(aead, plaintext, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(prefix)+len(ks.primary)+1+2+len(wrapped)+len(sealed))
+	out = append(out, prefix...)
+	out = append(out, ks.primary...)
+	out = append(out, ':')
+	out = binary.BigEndian.AppendUint16(out, uint16(len(wrapped)))
+	out = append(out, wrapped...)
+	out = append(out, sealed...)
+	return out, nil
+}
+
+// Decrypt opens a value written by Encrypt. Values without the encryption
+// prefix are returned unchanged. stale reports that the value should be
+// rewritten because it is not encrypted with the primary key.
+func (ks *KeySet) Decrypt(key string, data []byte) (plaintext []byte, stale bool, err error) {
+	if !bytes.HasPrefix(data, []byte(prefix)) {
+		return data, true, nil
+	}
+	data = data[len(prefix):]
+
+	i := bytes.IndexByte(data, ':')
+	if i < 0 {
+		return nil, false, errors.New("malformed encrypted value")
+	}
+	name := string(data[:i])
+	data = data[i+1:]
+
+	kek, ok := ks.keys[name]
+	if !ok {
+		return nil, false, fmt.Errorf("unknown encryption key %q", name)
+	}
+
+	if len(data) < 2 {
+		return nil, false, errors.New("malformed encrypted value")
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < n {
+		return nil, false, errors.New("malformed encrypted value")
+	}
+
+	dataKey, err := open(kek, data[:n], []byte(name))
+	if err != nil {
+		return nil, false, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, false, err
+	}
+	plaintext, err = open(aead, data[n:], []byte(key))
+	if err != nil {
+		return nil, false, err
+	}
+
+	return plaintext, name != ks.primary, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, data, additionalData []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted value")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
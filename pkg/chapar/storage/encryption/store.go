@@ -0,0 +1,204 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+const rotatePageSize = 500
+
+// Store encrypts the values of keys under the given prefixes before they
+// reach the wrapped storage.Interface and decrypts them on the way back.
+// Keys outside those prefixes are passed through untouched.
+type Store struct {
+	store    storage.Interface
+	keys     *KeySet
+	prefixes []string
+}
+
+func NewStore(store storage.Interface, keys *KeySet, prefixes ...string) *Store {
+	return &Store{
+		store:    store,
+		keys:     keys,
+		prefixes: prefixes,
+	}
+}
+
+func (s *Store) Get(ctx context.Context, key string, out *storage.KeyValue) error {
+	if err := s.store.Get(ctx, key, out); err != nil {
+		return err
+	}
+	return s.decrypt(out)
+}
+
+func (s *Store) Create(ctx context.Context, key string, obj []byte, ttl uint64) error {
+	val, err := s.encrypt(key, obj)
+	if err != nil {
+		return err
+	}
+	return s.store.Create(ctx, key, val, ttl)
+}
+
+func (s *Store) Update(ctx context.Context, key string, obj []byte, ttl uint64, resourceVersion int64) error {
+	val, err := s.encrypt(key, obj)
+	if err != nil {
+		return err
+	}
+	return s.store.Update(ctx, key, val, ttl, resourceVersion)
+}
+
+func (s *Store) Delete(ctx context.Context, key string) error {
+	return s.store.Delete(ctx, key)
+}
+
+func (s *Store) Txn(ctx context.Context, ops ...storage.Op) error {
+	encrypted := make([]storage.Op, len(ops))
+	for i, op := range ops {
+		if op.Type != storage.OpDelete {
+			val, err := s.encrypt(op.Key, op.Value)
+			if err != nil {
+				return err
+			}
+			op.Value = val
+		}
+		encrypted[i] = op
+	}
+	return s.store.Txn(ctx, encrypted...)
+}
+
+func (s *Store) GetList(ctx context.Context, prefix string, opts storage.ListOptions, out *storage.List) error {
+	if err := s.store.GetList(ctx, prefix, opts, out); err != nil {
+		return err
+	}
+	for _, kv := range out.Items {
+		if err := s.decrypt(kv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Grant(ctx context.Context, ttl uint64) (int64, error) {
+	return s.store.Grant(ctx, ttl)
+}
+
+func (s *Store) Count(ctx context.Context, key string) (uint32, error) {
+	return s.store.Count(ctx, key)
+}
+
+func (s *Store) Watch(ctx context.Context, prefix string, fromRevision int64) (<-chan storage.Event, error) {
+	events, err := s.store.Watch(ctx, prefix, fromRevision)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan storage.Event)
+
+	go func() {
+		defer close(out)
+
+		for ev := range events {
+			if ev.Type != storage.EventError {
+				if err := s.decrypt(&ev.KeyValue); err != nil {
+					ev = storage.Event{Type: storage.EventError, Err: err}
+				}
+			}
+
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (s *Store) ReadinessCheck() error {
+	return s.store.ReadinessCheck()
+}
+
+// Rotate rewrites every value under the encrypted prefixes that is stored in
+// plain text or with a key other than the primary one, and returns how many
+// values were rewritten. Values changed concurrently are skipped, since any
+// write goes through the primary key anyway.
+func (s *Store) Rotate(ctx context.Context) (int, error) {
+	rewritten := 0
+
+	for _, prefix := range s.prefixes {
+		opts := storage.ListOptions{Limit: rotatePageSize}
+		for {
+			list := &storage.List{}
+			if err := s.store.GetList(ctx, prefix, opts, list); err != nil {
+				return rewritten, err
+			}
+
+			for _, kv := range list.Items {
+				plaintext, stale, err := s.keys.Decrypt(kv.Key, kv.Value)
+				if err != nil {
+					return rewritten, fmt.Errorf("%q: %w", kv.Key, err)
+				}
+				if !stale {
+					continue
+				}
+
+				val, err := s.keys.Encrypt(kv.Key, plaintext)
+				if err != nil {
+					return rewritten, fmt.Errorf("%q: %w", kv.Key, err)
+				}
+				if err := s.store.Update(ctx, kv.Key, val, 0, kv.Revision); err != nil {
+					if errors.Is(err, errs.ErrResourceVersionConflict) {
+						continue
+					}
+					return rewritten, fmt.Errorf("%q: %w", kv.Key, err)
+				}
+				rewritten++
+			}
+
+			if list.Continue == "" {
+				break
+			}
+			opts.Continue = list.Continue
+		}
+	}
+
+	return rewritten, nil
+}
+
+func (s *Store) encrypted(key string) bool {
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) encrypt(key string, obj []byte) ([]byte, error) {
+	if !s.encrypted(key) {
+		return obj, nil
+	}
+	val, err := s.keys.Encrypt(key, obj)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt %q: %w", key, err)
+	}
+	return val, nil
+}
+
+func (s *Store) decrypt(kv *storage.KeyValue) error {
+	if !s.encrypted(kv.Key) {
+		return nil
+	}
+	plaintext, _, err := s.keys.Decrypt(kv.Key, kv.Value)
+	if err != nil {
+		return fmt.Errorf("decrypt %q: %w", kv.Key, err)
+	}
+	kv.Value = plaintext
+	return nil
+}
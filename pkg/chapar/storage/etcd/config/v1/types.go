@@ -10,4 +10,7 @@ type EtcdTLSConfig struct {
 type EtcdConfig struct {
 	Servers []string      `mapstructure:"servers" yaml:"servers"`
 	TLS     EtcdTLSConfig `mapstructure:"tls" yaml:"tls"`
+	// Prefix is prepended to every key, lease lookup and watch, so several
+	// clusters or other applications can share one etcd, e.g. "/apadana/prod".
+	Prefix string `mapstructure:"prefix" yaml:"prefix"`
 }
@@ -5,10 +5,12 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"os"
+	"strings"
 	"time"
 
 	etcdconfigv1 "github.com/vayzur/apadana/pkg/chapar/storage/etcd/config/v1"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/namespace"
 )
 
 const (
@@ -55,5 +57,17 @@ func NewClient(cfg *etcdconfigv1.EtcdConfig, ctx context.Context) (*clientv3.Cli
 		return nil, err
 	}
 
+	if prefix := NormalizePrefix(cfg.Prefix); prefix != "" {
+		etcdClient.KV = namespace.NewKV(etcdClient.KV, prefix)
+		etcdClient.Watcher = namespace.NewWatcher(etcdClient.Watcher, prefix)
+		etcdClient.Lease = namespace.NewLease(etcdClient.Lease, prefix)
+	}
+
 	return etcdClient, nil
 }
+
+// NormalizePrefix drops the trailing slash of a configured prefix, since
+// every resource key already starts with one.
+func NormalizePrefix(prefix string) string {
+	return strings.TrimSuffix(prefix, "/")
+}
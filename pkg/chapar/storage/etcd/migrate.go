@@ -0,0 +1,66 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const migratePageSize = 500
+
+// MigratePrefix copies every key under prefixes from the from root to the to
+// root, using a client without a namespace. Copies stay attached to the lease
+// of the original, so they expire at the same time. Keys that already exist
+// at the destination, or change while being copied, are skipped. With
+// deleteSource the original is removed in the same transaction as the copy.
+func MigratePrefix(ctx context.Context, client *clientv3.Client, from, to string, prefixes []string, deleteSource bool) (moved, skipped int, err error) {
+	from = NormalizePrefix(from)
+	to = NormalizePrefix(to)
+	if from == to {
+		return 0, 0, fmt.Errorf("source and destination prefix are both %q", from)
+	}
+
+	for _, prefix := range prefixes {
+		start := from + prefix
+		end := clientv3.GetPrefixRangeEnd(start)
+
+		for {
+			resp, err := client.Get(ctx, start, clientv3.WithRange(end), clientv3.WithLimit(migratePageSize))
+			if err != nil {
+				return moved, skipped, fmt.Errorf("%q: %w", start, err)
+			}
+
+			for _, kv := range resp.Kvs {
+				src := string(kv.Key)
+				dst := to + src[len(from):]
+
+				then := []clientv3.Op{clientv3.OpPut(dst, string(kv.Value), clientv3.WithLease(clientv3.LeaseID(kv.Lease)))}
+				if deleteSource {
+					then = append(then, clientv3.OpDelete(src))
+				}
+
+				txn, err := client.Txn(ctx).If(
+					clientv3.Compare(clientv3.CreateRevision(dst), "=", 0),
+					clientv3.Compare(clientv3.ModRevision(src), "=", kv.ModRevision),
+				).Then(then...).Commit()
+				if err != nil {
+					return moved, skipped, fmt.Errorf("%q: %w", src, err)
+				}
+
+				if txn.Succeeded {
+					moved++
+				} else {
+					skipped++
+				}
+			}
+
+			if !resp.More || len(resp.Kvs) == 0 {
+				break
+			}
+			start = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+		}
+	}
+
+	return moved, skipped, nil
+}
@@ -2,14 +2,19 @@ package etcd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/vayzur/apadana/pkg/chapar/storage"
 	"github.com/vayzur/apadana/pkg/errs"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
+const watchChanSize = 100
+
 type EtcdStorage struct {
 	client *clientv3.Client
 }
@@ -20,7 +25,7 @@ func NewEtcdStorage(client *clientv3.Client) *EtcdStorage {
 	}
 }
 
-func (e *EtcdStorage) Get(ctx context.Context, key string, out *[]byte) error {
+func (e *EtcdStorage) Get(ctx context.Context, key string, out *storage.KeyValue) error {
 	resp, err := e.client.Get(ctx, key)
 	if err != nil {
 		return fmt.Errorf("%q: %w", key, err)
@@ -30,7 +35,12 @@ func (e *EtcdStorage) Get(ctx context.Context, key string, out *[]byte) error {
 		return errs.ErrResourceNotFound
 	}
 
-	*out = resp.Kvs[0].Value
+	kv := resp.Kvs[0]
+	*out = storage.KeyValue{
+		Key:      string(kv.Key),
+		Value:    kv.Value,
+		Revision: kv.ModRevision,
+	}
 	return nil
 }
 
@@ -53,6 +63,10 @@ func (e *EtcdStorage) Create(ctx context.Context, key string, obj []byte, ttl ui
 	return nil
 }
 
+func (e *EtcdStorage) Update(ctx context.Context, key string, obj []byte, ttl uint64, resourceVersion int64) error {
+	return e.Txn(ctx, storage.UpdateOp(key, obj, ttl, resourceVersion))
+}
+
 func (e *EtcdStorage) Delete(ctx context.Context, key string) error {
 	opts := []clientv3.OpOption{}
 
@@ -72,21 +86,167 @@ func (e *EtcdStorage) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
-func (e *EtcdStorage) GetList(ctx context.Context, prefix string, out *[][]byte) error {
-	resp, err := e.client.Get(ctx, prefix, clientv3.WithPrefix())
+func (e *EtcdStorage) Txn(ctx context.Context, ops ...storage.Op) error {
+	// ops with the same ttl share one lease, so keys written together also expire together
+	leases := make(map[uint64]clientv3.LeaseID)
+	defer func() {
+		for _, id := range leases {
+			e.revoke(id)
+		}
+	}()
+
+	var cmps []clientv3.Cmp
+	var thenOps, elseOps []clientv3.Op
+	var checked []storage.Op
+
+	for _, op := range ops {
+		var opts []clientv3.OpOption
+
+		switch {
+		case op.Type == storage.OpDelete:
+		case op.Lease != 0:
+			opts = append(opts, clientv3.WithLease(clientv3.LeaseID(op.Lease)))
+		case op.TTL != 0:
+			id, ok := leases[op.TTL]
+			if !ok {
+				lease, err := e.client.Grant(ctx, int64(op.TTL))
+				if err != nil {
+					return fmt.Errorf("create lease failed %q: %w", op.Key, err)
+				}
+				id = lease.ID
+				leases[op.TTL] = id
+			}
+			opts = append(opts, clientv3.WithLease(id))
+		}
+
+		switch op.Type {
+		case storage.OpCreate:
+			cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(op.Key), "=", 0))
+			thenOps = append(thenOps, clientv3.OpPut(op.Key, string(op.Value), opts...))
+		case storage.OpUpdate:
+			if op.TTL == 0 && op.Lease == 0 {
+				opts = append(opts, clientv3.WithIgnoreLease())
+			} else {
+				// the previous lease is released below once the key has moved off it
+				opts = append(opts, clientv3.WithPrevKV())
+			}
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(op.Key), "=", op.ResourceVersion))
+			thenOps = append(thenOps, clientv3.OpPut(op.Key, string(op.Value), opts...))
+		case storage.OpDelete:
+			if op.ResourceVersion != 0 {
+				cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(op.Key), "=", op.ResourceVersion))
+			}
+			if strings.HasSuffix(op.Key, "/") {
+				opts = append(opts, clientv3.WithPrefix())
+			}
+			thenOps = append(thenOps, clientv3.OpDelete(op.Key, opts...))
+		case storage.OpPut:
+			// a previous lease is released below, as for updates
+			opts = append(opts, clientv3.WithPrevKV())
+			thenOps = append(thenOps, clientv3.OpPut(op.Key, string(op.Value), opts...))
+		default:
+			return fmt.Errorf("unknown op type %d for %q", op.Type, op.Key)
+		}
+
+		if op.Type == storage.OpCreate || op.Type == storage.OpUpdate || op.ResourceVersion != 0 {
+			checked = append(checked, op)
+			elseOps = append(elseOps, clientv3.OpGet(op.Key, clientv3.WithKeysOnly()))
+		}
+	}
+
+	resp, err := e.client.Txn(ctx).If(cmps...).Then(thenOps...).Else(elseOps...).Commit()
+	if err != nil {
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			return errs.ErrLeaseNotFound
+		}
+		return fmt.Errorf("txn failed: %w", err)
+	}
+
+	if !resp.Succeeded {
+		// the else branch read every guarded key, find out which precondition broke
+		for i, op := range checked {
+			kvs := resp.Responses[i].GetResponseRange().GetKvs()
+			if op.Type == storage.OpCreate && len(kvs) != 0 {
+				return errs.ErrResourceExists
+			}
+		}
+		return errs.ErrResourceVersionConflict
+	}
+
+	clear(leases)
+
+	for _, r := range resp.Responses {
+		if prev := r.GetResponsePut().GetPrevKv(); prev != nil {
+			e.release(clientv3.LeaseID(prev.Lease))
+		}
+	}
+
+	return nil
+}
+
+func (e *EtcdStorage) GetList(ctx context.Context, prefix string, opts storage.ListOptions, out *storage.List) error {
+	key := max(prefix, opts.StartKey)
+	end := clientv3.GetPrefixRangeEnd(prefix)
+	if opts.EndKey != "" {
+		end = min(end, opts.EndKey)
+	}
+	getOpts := []clientv3.OpOption{clientv3.WithRange(end)}
+
+	var rev int64
+	if opts.Continue != "" {
+		var err error
+		rev, key, err = storage.DecodeContinue(opts.Continue, prefix)
+		if err != nil {
+			return err
+		}
+		getOpts = append(getOpts, clientv3.WithRev(rev))
+	}
+	if opts.Limit > 0 {
+		getOpts = append(getOpts, clientv3.WithLimit(opts.Limit))
+	}
+
+	resp, err := e.client.Get(ctx, key, getOpts...)
 	if err != nil {
-		return err
+		if errors.Is(err, rpctypes.ErrCompacted) {
+			return errs.ErrResourceExpired
+		}
+		return fmt.Errorf("%q: %w", prefix, err)
 	}
 
-	kvs := make([][]byte, 0, len(resp.Kvs))
+	if rev == 0 {
+		rev = resp.Header.Revision
+	}
+
+	kvs := make([]*storage.KeyValue, 0, len(resp.Kvs))
 	for _, kv := range resp.Kvs {
-		kvs = append(kvs, kv.Value)
+		kvs = append(kvs, &storage.KeyValue{
+			Key:      string(kv.Key),
+			Value:    kv.Value,
+			Revision: kv.ModRevision,
+		})
+	}
+
+	*out = storage.List{
+		Items:    kvs,
+		Revision: rev,
+	}
+
+	if resp.More && len(kvs) > 0 {
+		out.Continue = storage.EncodeContinue(rev, kvs[len(kvs)-1].Key+"\x00")
+		out.RemainingItemCount = resp.Count - int64(len(kvs))
 	}
 
-	*out = kvs
 	return nil
 }
 
+func (e *EtcdStorage) Grant(ctx context.Context, ttl uint64) (int64, error) {
+	lease, err := e.client.Grant(ctx, int64(ttl))
+	if err != nil {
+		return 0, fmt.Errorf("create lease failed: %w", err)
+	}
+	return int64(lease.ID), nil
+}
+
 func (e *EtcdStorage) Count(ctx context.Context, key string) (uint32, error) {
 	resp, err := e.client.Get(ctx, key, clientv3.WithPrefix(), clientv3.WithCountOnly())
 	if err != nil {
@@ -95,6 +255,67 @@ func (e *EtcdStorage) Count(ctx context.Context, key string) (uint32, error) {
 	return uint32(resp.Count), nil
 }
 
+func (e *EtcdStorage) Watch(ctx context.Context, prefix string, fromRevision int64) (<-chan storage.Event, error) {
+	opts := []clientv3.OpOption{clientv3.WithPrevKV()}
+
+	if strings.HasSuffix(prefix, "/") {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	if fromRevision > 0 {
+		opts = append(opts, clientv3.WithRev(fromRevision))
+	}
+
+	wch := e.client.Watch(clientv3.WithRequireLeader(ctx), prefix, opts...)
+	out := make(chan storage.Event, watchChanSize)
+
+	go func() {
+		defer close(out)
+
+		for resp := range wch {
+			if err := resp.Err(); err != nil {
+				if errors.Is(err, rpctypes.ErrCompacted) {
+					err = errs.ErrResourceExpired
+				}
+				select {
+				case out <- storage.Event{Type: storage.EventError, Err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+
+			for _, ev := range resp.Events {
+				event := storage.Event{
+					KeyValue: storage.KeyValue{
+						Key:      string(ev.Kv.Key),
+						Value:    ev.Kv.Value,
+						Revision: ev.Kv.ModRevision,
+					},
+				}
+
+				switch {
+				case ev.Type == clientv3.EventTypeDelete:
+					event.Type = storage.EventDeleted
+					if ev.PrevKv != nil {
+						event.Value = ev.PrevKv.Value
+					}
+				case ev.IsCreate():
+					event.Type = storage.EventAdded
+				default:
+					event.Type = storage.EventModified
+				}
+
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
 func (e *EtcdStorage) ReadinessCheck() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -109,3 +330,29 @@ func (e *EtcdStorage) ReadinessCheck() error {
 	}
 	return lastErr
 }
+
+// release revokes a lease that has no keys attached anymore.
+func (e *EtcdStorage) release(leaseID clientv3.LeaseID) {
+	if leaseID == clientv3.NoLease {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := e.client.TimeToLive(ctx, leaseID, clientv3.WithAttachedKeys())
+	if err != nil || resp.TTL == -1 || len(resp.Keys) != 0 {
+		return
+	}
+	e.client.Revoke(ctx, leaseID)
+}
+
+func (e *EtcdStorage) revoke(leaseID clientv3.LeaseID) {
+	if leaseID == clientv3.NoLease {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e.client.Revoke(ctx, leaseID)
+}
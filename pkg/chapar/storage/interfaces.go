@@ -3,10 +3,31 @@ package storage
 import "context"
 
 type Interface interface {
-	Get(ctx context.Context, key string, out *[]byte) error
+	Get(ctx context.Context, key string, out *KeyValue) error
 	Create(ctx context.Context, key string, obj []byte, ttl uint64) error
+	// Update replaces the value stored at key only if its current revision
+	// still equals resourceVersion, and fails with errs.ErrResourceVersionConflict otherwise.
+	// A zero ttl keeps the key on its current lease; a non-zero ttl moves it to
+	// a new lease, and the old one is revoked once no other key uses it.
+	Update(ctx context.Context, key string, obj []byte, ttl uint64, resourceVersion int64) error
 	Delete(ctx context.Context, key string) error
-	GetList(ctx context.Context, key string, out *[][]byte) error
+	// Txn applies all ops atomically: either every precondition holds and all
+	// ops are committed in one revision, or nothing is written and
+	// errs.ErrResourceExists or errs.ErrResourceVersionConflict is returned.
+	Txn(ctx context.Context, ops ...Op) error
+	// GetList returns the keys under prefix in key order, at most opts.Limit
+	// of them when set. Follow-up pages passing out.Continue are read from the
+	// same revision as the first page.
+	GetList(ctx context.Context, prefix string, opts ListOptions, out *List) error
 	Count(ctx context.Context, key string) (uint32, error)
+	// Grant creates a lease that expires after ttl seconds, for ops to attach
+	// keys to through Op.Lease. A lease is dropped once it expires or its last
+	// key is deleted; ops attaching to it then fail with
+	// errs.ErrLeaseNotFound.
+	Grant(ctx context.Context, ttl uint64) (int64, error)
+	// Watch streams changes under prefix starting at fromRevision, or at the
+	// current revision when fromRevision is 0. The channel is closed when ctx
+	// is done or the watch fails, in which case a final EventError is sent.
+	Watch(ctx context.Context, prefix string, fromRevision int64) (<-chan Event, error)
 	ReadinessCheck() error
 }
@@ -0,0 +1,455 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+const (
+	// historySize is how many past events are kept for watches that start
+	// from an older revision; anything before that behaves like a compacted revision.
+	historySize = 1000
+
+	watchChanSize = 100
+
+	expiryInterval = 500 * time.Millisecond
+)
+
+type item struct {
+	value       []byte
+	modRevision int64
+	lease       int64
+}
+
+type lease struct {
+	expiresAt time.Time
+	keys      map[string]struct{}
+}
+
+type watcher struct {
+	prefix string
+	ch     chan storage.Event
+}
+
+// MemoryStorage is a storage.Interface kept entirely in process memory. It
+// follows etcd semantics closely enough to run chapar without a cluster:
+// every write bumps a global revision, keys written with a ttl are attached
+// to a lease and deleted together when it expires, and watches can resume
+// from recent revisions.
+type MemoryStorage struct {
+	mu        sync.RWMutex
+	items     map[string]*item
+	leases    map[int64]*lease
+	revision  int64
+	nextLease int64
+	history   []storage.Event
+	compacted int64
+	watchers  map[*watcher]struct{}
+}
+
+func NewMemoryStorage(ctx context.Context) *MemoryStorage {
+	m := &MemoryStorage{
+		items:    make(map[string]*item),
+		leases:   make(map[int64]*lease),
+		watchers: make(map[*watcher]struct{}),
+	}
+	go m.runExpiry(ctx)
+	return m
+}
+
+func (m *MemoryStorage) Get(ctx context.Context, key string, out *storage.KeyValue) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	it, ok := m.items[key]
+	if !ok {
+		return errs.ErrResourceNotFound
+	}
+
+	*out = storage.KeyValue{
+		Key:      key,
+		Value:    it.value,
+		Revision: it.modRevision,
+	}
+	return nil
+}
+
+func (m *MemoryStorage) Create(ctx context.Context, key string, obj []byte, ttl uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revision++
+	m.put(key, obj, m.grant(ttl))
+	return nil
+}
+
+func (m *MemoryStorage) Update(ctx context.Context, key string, obj []byte, ttl uint64, resourceVersion int64) error {
+	return m.Txn(ctx, storage.UpdateOp(key, obj, ttl, resourceVersion))
+}
+
+func (m *MemoryStorage) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := m.match(key)
+	if len(keys) == 0 {
+		return errs.ErrResourceNotFound
+	}
+
+	m.revision++
+	for _, k := range keys {
+		m.delete(k)
+	}
+	return nil
+}
+
+func (m *MemoryStorage) Txn(ctx context.Context, ops ...storage.Op) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var conflict error
+	for _, op := range ops {
+		if _, ok := m.leases[op.Lease]; op.Lease != 0 && op.Type != storage.OpDelete && !ok {
+			return errs.ErrLeaseNotFound
+		}
+		it, exists := m.items[op.Key]
+		switch op.Type {
+		case storage.OpCreate:
+			if exists {
+				return errs.ErrResourceExists
+			}
+		case storage.OpUpdate:
+			if !exists || it.modRevision != op.ResourceVersion {
+				conflict = errs.ErrResourceVersionConflict
+			}
+		case storage.OpDelete:
+			if op.ResourceVersion != 0 && (!exists || it.modRevision != op.ResourceVersion) {
+				conflict = errs.ErrResourceVersionConflict
+			}
+		}
+	}
+	if conflict != nil {
+		return conflict
+	}
+
+	if !m.changes(ops) {
+		return nil
+	}
+
+	m.revision++
+	leases := make(map[uint64]int64)
+	for _, op := range ops {
+		if op.Type == storage.OpDelete {
+			for _, k := range m.match(op.Key) {
+				m.delete(k)
+			}
+			continue
+		}
+
+		if op.Lease != 0 {
+			m.put(op.Key, op.Value, op.Lease)
+			continue
+		}
+		if op.Type == storage.OpUpdate && op.TTL == 0 {
+			// an earlier op of the txn may have deleted the key, and its lease
+			// with it
+			var lease int64
+			if it, ok := m.items[op.Key]; ok {
+				lease = it.lease
+			}
+			m.put(op.Key, op.Value, lease)
+			continue
+		}
+
+		id, ok := leases[op.TTL]
+		if !ok {
+			id = m.grant(op.TTL)
+			leases[op.TTL] = id
+		}
+		m.put(op.Key, op.Value, id)
+	}
+
+	return nil
+}
+
+// GetList pages through the current state; unlike etcd, follow-up pages are
+// not pinned to the revision of the first one.
+func (m *MemoryStorage) GetList(ctx context.Context, prefix string, opts storage.ListOptions, out *storage.List) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := m.match(prefix)
+	start := opts.StartKey
+	if opts.Continue != "" {
+		var err error
+		_, start, err = storage.DecodeContinue(opts.Continue, prefix)
+		if err != nil {
+			return err
+		}
+	}
+	keys = keys[sort.SearchStrings(keys, start):]
+	if opts.EndKey != "" {
+		keys = keys[:sort.SearchStrings(keys, opts.EndKey)]
+	}
+
+	total := len(keys)
+	if opts.Limit > 0 && int64(total) > opts.Limit {
+		keys = keys[:opts.Limit]
+	}
+
+	kvs := make([]*storage.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		it := m.items[k]
+		kvs = append(kvs, &storage.KeyValue{
+			Key:      k,
+			Value:    it.value,
+			Revision: it.modRevision,
+		})
+	}
+
+	*out = storage.List{
+		Items:    kvs,
+		Revision: m.revision,
+	}
+
+	if len(kvs) < total {
+		out.Continue = storage.EncodeContinue(m.revision, kvs[len(kvs)-1].Key+"\x00")
+		out.RemainingItemCount = int64(total - len(kvs))
+	}
+
+	return nil
+}
+
+func (m *MemoryStorage) Grant(ctx context.Context, ttl uint64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.grant(ttl), nil
+}
+
+func (m *MemoryStorage) Count(ctx context.Context, key string) (uint32, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return uint32(len(m.match(key))), nil
+}
+
+func (m *MemoryStorage) Watch(ctx context.Context, prefix string, fromRevision int64) (<-chan storage.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var replay []storage.Event
+	if fromRevision > 0 && fromRevision <= m.revision {
+		if fromRevision <= m.compacted {
+			ch := make(chan storage.Event, 1)
+			ch <- storage.Event{Type: storage.EventError, Err: errs.ErrResourceExpired}
+			close(ch)
+			return ch, nil
+		}
+		for _, ev := range m.history {
+			if ev.Revision >= fromRevision && matches(prefix, ev.Key) {
+				replay = append(replay, ev)
+			}
+		}
+	}
+
+	w := &watcher{
+		prefix: prefix,
+		// one more slot is kept free for the error that ends a slow watch
+		ch: make(chan storage.Event, len(replay)+watchChanSize+1),
+	}
+	for _, ev := range replay {
+		w.ch <- ev
+	}
+	m.watchers[w] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		m.stop(w)
+		m.mu.Unlock()
+	}()
+
+	return w.ch, nil
+}
+
+func (m *MemoryStorage) ReadinessCheck() error {
+	return nil
+}
+
+// changes reports whether applying ops would write anything, since like etcd
+// a transaction that only deletes missing keys does not create a revision.
+func (m *MemoryStorage) changes(ops []storage.Op) bool {
+	for _, op := range ops {
+		if op.Type != storage.OpDelete || len(m.match(op.Key)) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// grant attaches a new lease for ttl seconds; it must be called with m.mu held.
+func (m *MemoryStorage) grant(ttl uint64) int64 {
+	if ttl == 0 {
+		return 0
+	}
+
+	m.nextLease++
+	m.leases[m.nextLease] = &lease{
+		expiresAt: time.Now().Add(time.Duration(ttl) * time.Second),
+		keys:      make(map[string]struct{}),
+	}
+	return m.nextLease
+}
+
+// put writes key at the current revision; it must be called with m.mu held.
+func (m *MemoryStorage) put(key string, obj []byte, leaseID int64) {
+	value := append([]byte(nil), obj...)
+
+	eventType := storage.EventAdded
+	if old, ok := m.items[key]; ok {
+		eventType = storage.EventModified
+		if old.lease != leaseID {
+			m.detach(key, old.lease)
+		}
+	}
+
+	m.items[key] = &item{
+		value:       value,
+		modRevision: m.revision,
+		lease:       leaseID,
+	}
+	if l, ok := m.leases[leaseID]; ok {
+		l.keys[key] = struct{}{}
+	}
+
+	m.notify(storage.Event{
+		Type: eventType,
+		KeyValue: storage.KeyValue{
+			Key:      key,
+			Value:    value,
+			Revision: m.revision,
+		},
+	})
+}
+
+// delete removes key at the current revision; it must be called with m.mu held.
+func (m *MemoryStorage) delete(key string) {
+	it := m.items[key]
+	delete(m.items, key)
+	m.detach(key, it.lease)
+
+	m.notify(storage.Event{
+		Type: storage.EventDeleted,
+		KeyValue: storage.KeyValue{
+			Key:      key,
+			Value:    it.value,
+			Revision: m.revision,
+		},
+	})
+}
+
+// detach removes key from its lease and drops the lease once it is unused.
+func (m *MemoryStorage) detach(key string, leaseID int64) {
+	if l, ok := m.leases[leaseID]; ok {
+		delete(l.keys, key)
+		if len(l.keys) == 0 {
+			delete(m.leases, leaseID)
+		}
+	}
+}
+
+// match returns the sorted keys selected by key, treating a trailing slash
+// as a prefix the same way the etcd backend does.
+func (m *MemoryStorage) match(key string) []string {
+	if !strings.HasSuffix(key, "/") {
+		if _, ok := m.items[key]; ok {
+			return []string{key}
+		}
+		return nil
+	}
+
+	var keys []string
+	for k := range m.items {
+		if strings.HasPrefix(k, key) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func matches(prefix, key string) bool {
+	if strings.HasSuffix(prefix, "/") {
+		return strings.HasPrefix(key, prefix)
+	}
+	return key == prefix
+}
+
+// notify records ev and fans it out; it must be called with m.mu held. A
+// watcher that cannot keep up is dropped rather than blocking writers.
+func (m *MemoryStorage) notify(ev storage.Event) {
+	m.history = append(m.history, ev)
+	if len(m.history) > historySize {
+		m.compacted = m.history[0].Revision
+		m.history = m.history[1:]
+	}
+
+	for w := range m.watchers {
+		if !matches(w.prefix, ev.Key) {
+			continue
+		}
+		if len(w.ch) >= cap(w.ch)-1 {
+			// the watcher fell behind and has missed ev; like a watch whose
+			// revision etcd compacted, it has to list again
+			w.ch <- storage.Event{Type: storage.EventError, Err: errs.ErrResourceExpired}
+			m.stop(w)
+			continue
+		}
+		w.ch <- ev
+	}
+}
+
+func (m *MemoryStorage) stop(w *watcher) {
+	if _, ok := m.watchers[w]; !ok {
+		return
+	}
+	delete(m.watchers, w)
+	close(w.ch)
+}
+
+// runExpiry revokes expired leases, deleting all of their keys in a single
+// revision like etcd does.
+func (m *MemoryStorage) runExpiry(ctx context.Context) {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			for id, l := range m.leases {
+				if now.Before(l.expiresAt) {
+					continue
+				}
+				delete(m.leases, id)
+				if len(l.keys) == 0 {
+					continue
+				}
+				m.revision++
+				for key := range l.keys {
+					m.delete(key)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
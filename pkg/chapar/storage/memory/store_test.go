@@ -0,0 +1,39 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+)
+
+func TestTxnUpdateAfterDelete(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewMemoryStorage(ctx)
+
+	if err := m.Create(ctx, "/a/x", []byte("old"), 60); err != nil {
+		t.Fatal(err)
+	}
+	var kv storage.KeyValue
+	if err := m.Get(ctx, "/a/x", &kv); err != nil {
+		t.Fatal(err)
+	}
+
+	// the update keeps the lease of the key, which the delete before it
+	// already released
+	err := m.Txn(ctx,
+		storage.DeleteOp("/a/"),
+		storage.UpdateOp("/a/x", []byte("new"), 0, kv.Revision),
+	)
+	if err != nil {
+		t.Fatalf("Txn: %v", err)
+	}
+
+	if err := m.Get(ctx, "/a/x", &kv); err != nil {
+		t.Fatal(err)
+	}
+	if string(kv.Value) != "new" {
+		t.Errorf("value = %q, want %q", kv.Value, "new")
+	}
+}
@@ -0,0 +1,16 @@
+package storage
+
+import "context"
+
+type quorumKey struct{}
+
+// WithQuorum marks reads made with the returned context as requiring the
+// latest committed state, so that caches in front of the backend are bypassed.
+func WithQuorum(ctx context.Context) context.Context {
+	return context.WithValue(ctx, quorumKey{}, true)
+}
+
+func IsQuorum(ctx context.Context) bool {
+	quorum, _ := ctx.Value(quorumKey{}).(bool)
+	return quorum
+}
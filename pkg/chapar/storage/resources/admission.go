@@ -0,0 +1,110 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// admitRetries bounds how often a create is retried after losing the guard
+// to a concurrent create under the same parent.
+const admitRetries = 10
+
+// admit commits creates, together with ops, only while the keys under prefix
+// plus the new ones stay within limit, and fails with exceeded otherwise. A
+// zero limit admits any number of keys.
+//
+// Every admission bumps guard in the same transaction, conditional on the
+// guard revision read before counting, so no other create under prefix can
+// commit between the count and the write. Keys that go away only make the
+// count conservative. Guards are empty and kept after their parent is
+// deleted, to be reused if it is created again.
+func admit(ctx context.Context, store storage.Interface, guard, prefix string, limit uint32, exceeded error, creates []storage.Op, ops ...storage.Op) error {
+	return admitFunc(ctx, store, guard, prefix, limit, exceeded, func(context.Context) ([]storage.Op, error) {
+		return creates, nil
+	}, ops...)
+}
+
+// admitFunc is admit with the creates built by prepare once the guard has
+// been read, on every attempt. What prepare reads under prefix therefore
+// cannot gain keys before the creates commit. It is passed a quorum context.
+func admitFunc(ctx context.Context, store storage.Interface, guard, prefix string, limit uint32, exceeded error, prepare func(context.Context) ([]storage.Op, error), ops ...storage.Op) error {
+	ctx = storage.WithQuorum(ctx)
+
+	for range admitRetries {
+		guardOp := storage.CreateOp(guard, nil, 0)
+		kv := &storage.KeyValue{}
+		if err := store.Get(ctx, guard, kv); err == nil {
+			guardOp = storage.UpdateOp(guard, nil, 0, kv.Revision)
+		} else if !errors.Is(err, errs.ErrResourceNotFound) {
+			return err
+		}
+
+		creates, err := prepare(ctx)
+		if err != nil {
+			return err
+		}
+
+		if limit > 0 {
+			count, err := store.Count(ctx, prefix)
+			if err != nil {
+				return err
+			}
+			if uint64(count)+uint64(len(creates)) > uint64(limit) {
+				// an existing key is reported as such, full or not
+				exists, err := anyExists(ctx, store, creates)
+				if err != nil {
+					return err
+				}
+				if exists {
+					return errs.ErrResourceExists
+				}
+				return exceeded
+			}
+		}
+
+		txn := append(append(slices.Clip(creates), guardOp), ops...)
+		err = store.Txn(ctx, txn...)
+		switch {
+		case errors.Is(err, errs.ErrResourceVersionConflict):
+			// another create moved the guard, unless the conflict is in ops
+			if guardOp.Type == storage.OpCreate {
+				return err
+			}
+			if err := store.Get(ctx, guard, kv); err == nil && kv.Revision == guardOp.ResourceVersion {
+				return errs.ErrResourceVersionConflict
+			}
+			continue
+		case errors.Is(err, errs.ErrResourceExists) && guardOp.Type == storage.OpCreate:
+			// either one of creates or the guard was created concurrently
+			exists, err := anyExists(ctx, store, creates)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return errs.ErrResourceExists
+			}
+			continue
+		}
+		return err
+	}
+
+	return errs.ErrResourceVersionConflict
+}
+
+func anyExists(ctx context.Context, store storage.Interface, creates []storage.Op) (bool, error) {
+	kv := &storage.KeyValue{}
+	for _, op := range creates {
+		err := store.Get(ctx, op.Key, kv)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, errs.ErrResourceNotFound) {
+			return false, err
+		}
+	}
+	return false, nil
+}
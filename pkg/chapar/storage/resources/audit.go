@@ -0,0 +1,156 @@
+package resources
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+	auditv1 "github.com/vayzur/apadana/pkg/apis/audit/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+	"github.com/vayzur/apadana/pkg/labels"
+)
+
+// auditLeaseBuckets is how many leases events share over their retention:
+// the events of each bucket expire together, at most a bucket after their
+// retention ends.
+const auditLeaseBuckets = 100
+
+type AuditStore struct {
+	store storage.Interface
+	// retention is how long events are kept; zero keeps them forever.
+	retention time.Duration
+
+	mu     sync.Mutex
+	bucket time.Time
+	lease  int64
+}
+
+func NewAuditStore(store storage.Interface, retention time.Duration) *AuditStore {
+	return &AuditStore{store: store, retention: retention}
+}
+
+// AuditEventName names an event of t. Names sort in the order of t, with
+// random bytes to tell apart events of the same nanosecond.
+func AuditEventName(t time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%020d-%s", t.UnixNano(), hex.EncodeToString(b))
+}
+
+func (s *AuditStore) CreateEvent(ctx context.Context, event *auditv1.Event) error {
+	val, err := codec.Encode(auditv1.KindEvent, event)
+	if err != nil {
+		return errs.New(
+			errs.KindInternal,
+			errs.ReasonMarshalFailed,
+			"create audit event failed",
+			nil,
+			err,
+		)
+	}
+
+	key := auditEventKey(event.Metadata.Name)
+	err = s.create(ctx, key, val)
+	if errors.Is(err, errs.ErrLeaseNotFound) {
+		// the lease is gone before its time, e.g. after a restore of etcd
+		s.mu.Lock()
+		s.lease = 0
+		s.mu.Unlock()
+		err = s.create(ctx, key, val)
+	}
+	if err != nil {
+		return errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"create audit event failed",
+			map[string]string{
+				"name": event.Metadata.Name,
+			},
+			err,
+		)
+	}
+	return nil
+}
+
+func (s *AuditStore) create(ctx context.Context, key string, val []byte) error {
+	op := storage.CreateOp(key, val, 0)
+	if s.retention > 0 {
+		lease, err := s.leaseAt(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+		op.Lease = lease
+	}
+	return s.store.Txn(ctx, op)
+}
+
+// leaseAt returns the lease of the bucket of now, granting it for the first
+// event of the bucket.
+func (s *AuditStore) leaseAt(ctx context.Context, now time.Time) (int64, error) {
+	width := max(s.retention/auditLeaseBuckets, time.Second)
+	bucket := now.Truncate(width)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lease != 0 && s.bucket.Equal(bucket) {
+		return s.lease, nil
+	}
+
+	expiresIn := bucket.Add(width + s.retention).Sub(now)
+	lease, err := s.store.Grant(ctx, uint64((expiresIn+time.Second-1)/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	s.bucket, s.lease = bucket, lease
+	return lease, nil
+}
+
+// GetEvents lists events oldest first. Since and Until bound the keys read,
+// as events are named by their time.
+func (s *AuditStore) GetEvents(ctx context.Context, opts auditv1.ListOptions) (*auditv1.EventList, error) {
+	events := []*auditv1.Event{}
+	filtered := opts.NodeName != "" || opts.Resource != ""
+
+	var start, end string
+	if opts.Since != nil {
+		start = auditTimeKey(*opts.Since)
+	}
+	if opts.Until != nil {
+		end = auditTimeKey(*opts.Until)
+	}
+
+	meta, err := listRange(ctx, s.store, AuditPrefix, start, end, opts.ListOptions, filtered, func(kv *storage.KeyValue, sel labels.Selector) bool {
+		event := &auditv1.Event{}
+		if _, err := codec.Decode(auditv1.KindEvent, kv.Value, event); err != nil {
+			zlog.Error().Err(err).Str("component", "store").Str("resource", "auditEvent").Msg("unmarshal failed")
+			return false
+		}
+		if !matchesEvent(event, opts) || !sel.Matches(event.Metadata.Labels) {
+			return false
+		}
+		events = append(events, event)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditv1.EventList{Metadata: meta, Items: events}, nil
+}
+
+func matchesEvent(event *auditv1.Event, opts auditv1.ListOptions) bool {
+	switch {
+	case opts.NodeName != "" && event.Object.NodeName != opts.NodeName:
+		return false
+	case opts.Resource != "" && event.Object.Resource != opts.Resource:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package resources
+
+import (
+	"context"
+	"errors"
+
+	satrapv1 "github.com/vayzur/apadana/pkg/apis/satrap/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// batchChunkSize is the number of users written per transaction. Each takes
+// up to two ops, plus one for the admission guard, which keeps a chunk within
+// etcd's default limit of 128 ops per transaction.
+const batchChunkSize = 60
+
+var errDuplicateKey = errors.New("duplicate key in chunk")
+
+// UserWrite is one write of a batch. Updates and deletes are conditional on
+// User.Metadata.ResourceVersion; deletes only read it and User.Spec.Email.
+type UserWrite struct {
+	Action satrapv1.BatchAction
+	User   *satrapv1.InboundUser
+}
+
+// ApplyUsers applies writes to the users of one inbound and returns an error,
+// or nil, per write. Writes are committed in transactions of batchChunkSize;
+// when one of them fails its writes are retried one by one, so that every
+// write gets its own outcome. Creates are admitted against maxUsers.
+func (s *InboundStore) ApplyUsers(ctx context.Context, nodeName, tag string, writes []UserWrite, maxUsers uint32) []error {
+	results := make([]error, len(writes))
+
+	for start := 0; start < len(writes); start += batchChunkSize {
+		end := min(start+batchChunkSize, len(writes))
+		if err := s.applyUsers(ctx, nodeName, tag, writes[start:end], maxUsers); err == nil {
+			continue
+		}
+		for i := start; i < end; i++ {
+			results[i] = s.applyUser(ctx, nodeName, tag, writes[i], maxUsers)
+		}
+	}
+
+	return results
+}
+
+// applyUsers commits writes in a single transaction.
+func (s *InboundStore) applyUsers(ctx context.Context, nodeName, tag string, writes []UserWrite, maxUsers uint32) error {
+	var creates, ops []storage.Op
+	seen := make(map[string]struct{}, len(writes))
+
+	for _, w := range writes {
+		email := w.User.Spec.Email
+		key := userKey(nodeName, tag, email)
+		if _, ok := seen[key]; ok {
+			return errDuplicateKey
+		}
+		seen[key] = struct{}{}
+
+		switch w.Action {
+		case satrapv1.BatchCreate:
+			val, err := encodeUser(w.User)
+			if err != nil {
+				return err
+			}
+			ttl := leaseTTL(w.User.Spec.ExpiresAt)
+			creates = append(creates, storage.CreateOp(key, val, ttl))
+			ops = append(ops, storage.PutOp(userEmailIndexKey(nodeName, tag, email), nil, ttl))
+		case satrapv1.BatchUpdate:
+			rev, err := storage.ParseResourceVersion(w.User.Metadata.ResourceVersion)
+			if err != nil {
+				return err
+			}
+			val, err := encodeUser(w.User)
+			if err != nil {
+				return err
+			}
+			ops = append(ops, storage.UpdateOp(key, val, 0, rev))
+		case satrapv1.BatchDelete:
+			rev, err := storage.ParseResourceVersion(w.User.Metadata.ResourceVersion)
+			if err != nil {
+				return err
+			}
+			ops = append(ops,
+				storage.Op{Type: storage.OpDelete, Key: key, ResourceVersion: rev},
+				storage.DeleteOp(userEmailIndexKey(nodeName, tag, email)),
+			)
+		}
+	}
+
+	if len(creates) == 0 {
+		return s.store.Txn(ctx, ops...)
+	}
+	return admit(ctx, s.store, usersGuardKey(nodeName, tag), usersKey(nodeName, tag), maxUsers, errs.ErrInboundCapacityExceeded, creates, ops...)
+}
+
+func (s *InboundStore) applyUser(ctx context.Context, nodeName, tag string, w UserWrite, maxUsers uint32) error {
+	switch w.Action {
+	case satrapv1.BatchCreate:
+		return s.CreateUser(ctx, nodeName, tag, w.User, maxUsers)
+	case satrapv1.BatchUpdate:
+		return s.UpdateUser(ctx, nodeName, tag, w.User)
+	}
+
+	email := w.User.Spec.Email
+	rev, err := storage.ParseResourceVersion(w.User.Metadata.ResourceVersion)
+	if err != nil {
+		return err
+	}
+
+	key := userKey(nodeName, tag, email)
+	err = s.store.Txn(ctx,
+		storage.Op{Type: storage.OpDelete, Key: key, ResourceVersion: rev},
+		storage.DeleteOp(userEmailIndexKey(nodeName, tag, email)),
+	)
+	if errors.Is(err, errs.ErrResourceVersionConflict) {
+		kv := &storage.KeyValue{}
+		if err := s.store.Get(storage.WithQuorum(ctx), key, kv); errors.Is(err, errs.ErrResourceNotFound) {
+			return errs.ErrUserNotFound
+		}
+		return err
+	}
+	if err != nil {
+		return errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"delete inbound user failed",
+			map[string]string{
+				"nodeName": nodeName,
+				"tag":      tag,
+				"email":    email,
+			},
+			err,
+		)
+	}
+
+	return nil
+}
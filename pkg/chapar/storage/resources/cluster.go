@@ -0,0 +1,136 @@
+package resources
+
+import (
+	"context"
+	"errors"
+
+	zlog "github.com/rs/zerolog/log"
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+	satrapv1 "github.com/vayzur/apadana/pkg/apis/satrap/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+	"github.com/vayzur/apadana/pkg/labels"
+)
+
+// GetClusterInbounds lists the inbounds of every node, ordered by node name
+// and tag.
+func (s *InboundStore) GetClusterInbounds(ctx context.Context, opts metav1.ListOptions) (*satrapv1.ClusterInboundList, error) {
+	items := []*satrapv1.ClusterInbound{}
+
+	meta, err := list(ctx, s.store, InboundsPrefix, opts, func(kv *storage.KeyValue, sel labels.Selector) bool {
+		inbound, err := decodeInbound(kv)
+		if err != nil {
+			zlog.Error().Err(err).Str("component", "inbound").Str("key", kv.Key).Msg("unmarshal failed")
+			return false
+		}
+		if !sel.Matches(inbound.Metadata.Labels) {
+			return false
+		}
+		items = append(items, &satrapv1.ClusterInbound{
+			NodeName: splitKey(kv.Key, InboundsPrefix)[0],
+			Inbound:  inbound,
+		})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &satrapv1.ClusterInboundList{Metadata: meta, Items: items}, nil
+}
+
+// GetClusterUsers lists the users of every inbound on every node, ordered by
+// node name, tag and email.
+func (s *InboundStore) GetClusterUsers(ctx context.Context, opts metav1.ListOptions) (*satrapv1.ClusterInboundUserList, error) {
+	items := []*satrapv1.ClusterInboundUser{}
+
+	meta, err := list(ctx, s.store, UsersPrefix, opts, func(kv *storage.KeyValue, sel labels.Selector) bool {
+		user, err := decodeUser(kv)
+		if err != nil {
+			zlog.Error().Err(err).Str("component", "inboundUser").Str("key", kv.Key).Msg("unmarshal failed")
+			return false
+		}
+		if !sel.Matches(user.Metadata.Labels) {
+			return false
+		}
+		parts := splitKey(kv.Key, UsersPrefix)
+		items = append(items, &satrapv1.ClusterInboundUser{
+			NodeName:   parts[0],
+			InboundTag: parts[1],
+			User:       user,
+		})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &satrapv1.ClusterInboundUserList{Metadata: meta, Items: items}, nil
+}
+
+// FindUsers returns the user with email on every inbound of every node it
+// exists on, looked up through the email index. Index entries whose user is
+// gone, because it expired or its inbound was deleted, are dropped.
+func (s *InboundStore) FindUsers(ctx context.Context, email, labelSelector string) (*satrapv1.ClusterInboundUserList, error) {
+	sel, err := parseSelector(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := UserEmailIndexPrefix + email + "/"
+	out := &storage.List{}
+	if err := s.store.GetList(ctx, prefix, storage.ListOptions{}, out); err != nil {
+		return nil, errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"find users failed",
+			map[string]string{
+				"email": email,
+			},
+			err,
+		)
+	}
+
+	items := []*satrapv1.ClusterInboundUser{}
+	for _, kv := range out.Items {
+		parts := splitKey(kv.Key, prefix)
+		if len(parts) != 2 {
+			continue
+		}
+		nodeName, tag := parts[0], parts[1]
+
+		user, err := s.GetUser(ctx, nodeName, tag, email)
+		if errors.Is(err, errs.ErrUserNotFound) {
+			// a cached read may trail the index, only trust a quorum read
+			user, err = s.GetUser(storage.WithQuorum(ctx), nodeName, tag, email)
+			if errors.Is(err, errs.ErrUserNotFound) {
+				s.dropIndexEntry(ctx, kv)
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !sel.Matches(user.Metadata.Labels) {
+			continue
+		}
+		items = append(items, &satrapv1.ClusterInboundUser{
+			NodeName:   nodeName,
+			InboundTag: tag,
+			User:       user,
+		})
+	}
+
+	meta := metav1.ListMeta{ResourceVersion: storage.FormatResourceVersion(out.Revision)}
+	return &satrapv1.ClusterInboundUserList{Metadata: meta, Items: items}, nil
+}
+
+// dropIndexEntry deletes a stale index entry unless it was rewritten since
+// it was read.
+func (s *InboundStore) dropIndexEntry(ctx context.Context, kv *storage.KeyValue) {
+	op := storage.Op{Type: storage.OpDelete, Key: kv.Key, ResourceVersion: kv.Revision}
+	if err := s.store.Txn(ctx, op); err != nil && !errors.Is(err, errs.ErrResourceVersionConflict) {
+		zlog.Warn().Err(err).Str("component", "inboundUser").Str("key", kv.Key).Msg("index cleanup failed")
+	}
+}
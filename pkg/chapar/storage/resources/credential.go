@@ -0,0 +1,281 @@
+package resources
+
+import (
+	"context"
+	"errors"
+
+	zlog "github.com/rs/zerolog/log"
+	authv1 "github.com/vayzur/apadana/pkg/apis/auth/v1"
+	corev1 "github.com/vayzur/apadana/pkg/apis/core/v1"
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+	"github.com/vayzur/apadana/pkg/labels"
+)
+
+type CredentialStore struct {
+	store storage.Interface
+}
+
+func NewCredentialStore(store storage.Interface) *CredentialStore {
+	return &CredentialStore{store: store}
+}
+
+func (s *CredentialStore) GetCredential(ctx context.Context, name string) (*authv1.Credential, error) {
+	key := credentialKey(name)
+	out := &storage.KeyValue{}
+
+	if err := s.store.Get(ctx, key, out); err != nil {
+		if errors.Is(err, errs.ErrResourceNotFound) {
+			return nil, errs.ErrCredentialNotFound
+		}
+		return nil, errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"get credential failed",
+			map[string]string{
+				"name": name,
+			},
+			err,
+		)
+	}
+
+	credential, err := decodeCredential(out)
+	if err != nil {
+		return nil, errs.New(
+			errs.KindInternal,
+			errs.ReasonUnmarshalFailed,
+			"get credential failed",
+			map[string]string{
+				"name": name,
+			},
+			err,
+		)
+	}
+
+	return credential, nil
+}
+
+func (s *CredentialStore) CreateCredential(ctx context.Context, credential *authv1.Credential) error {
+	c := *credential
+	c.Metadata.ResourceVersion = ""
+	val, err := codec.Encode(authv1.KindCredential, &c)
+	if err != nil {
+		return errs.New(
+			errs.KindInternal,
+			errs.ReasonMarshalFailed,
+			"create credential failed",
+			nil,
+			err,
+		)
+	}
+
+	key := credentialKey(credential.Metadata.Name)
+	if err := s.store.Txn(ctx, storage.CreateOp(key, val, leaseTTL(credential.Spec.ExpiresAt))); err != nil {
+		if errors.Is(err, errs.ErrResourceExists) {
+			return errs.ErrCredentialConflict
+		}
+		return errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"create credential failed",
+			map[string]string{
+				"name": credential.Metadata.Name,
+			},
+			err,
+		)
+	}
+
+	return nil
+}
+
+// JoinNode deletes the bootstrap credential, as long as it is still at its
+// resourceVersion, and creates node and issued in one transaction, so that a
+// bootstrap token is only ever spent on a join that succeeds. An existing
+// node, or credential by the name of issued, fails the join with
+// errs.ErrNodeConflict unless rejoin is set: an existing node is then kept as
+// it is and its credential replaced. It returns the node as stored.
+func (s *CredentialStore) JoinNode(ctx context.Context, bootstrap *authv1.Credential, node *corev1.Node, issued *authv1.Credential, rejoin bool) (*corev1.Node, error) {
+	rev, err := storage.ParseResourceVersion(bootstrap.Metadata.ResourceVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	c := *issued
+	c.Metadata.ResourceVersion = ""
+	credentialVal, err := codec.Encode(authv1.KindCredential, &c)
+	if err != nil {
+		return nil, errs.New(
+			errs.KindInternal,
+			errs.ReasonMarshalFailed,
+			"join node failed",
+			nil,
+			err,
+		)
+	}
+
+	key := nodeKey(node.Metadata.Name)
+	existing := &storage.KeyValue{}
+	switch err := s.store.Get(storage.WithQuorum(ctx), key, existing); {
+	case err == nil:
+		if !rejoin {
+			return nil, errs.ErrNodeConflict
+		}
+		stored, err := decodeNode(existing)
+		if err != nil {
+			return nil, errs.New(
+				errs.KindInternal,
+				errs.ReasonUnmarshalFailed,
+				"join node failed",
+				map[string]string{
+					"nodeName": node.Metadata.Name,
+				},
+				err,
+			)
+		}
+		node = stored
+	case errors.Is(err, errs.ErrResourceNotFound):
+		existing = nil
+	default:
+		return nil, errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"join node failed",
+			map[string]string{
+				"nodeName": node.Metadata.Name,
+			},
+			err,
+		)
+	}
+
+	ops := []storage.Op{
+		{Type: storage.OpDelete, Key: credentialKey(bootstrap.Metadata.Name), ResourceVersion: rev},
+	}
+	if rejoin {
+		ops = append(ops, storage.PutOp(credentialKey(issued.Metadata.Name), credentialVal, 0))
+	} else {
+		ops = append(ops, storage.CreateOp(credentialKey(issued.Metadata.Name), credentialVal, 0))
+	}
+	if existing == nil {
+		nodeVal, err := encodeNode(node)
+		if err != nil {
+			return nil, errs.New(
+				errs.KindInternal,
+				errs.ReasonMarshalFailed,
+				"join node failed",
+				nil,
+				err,
+			)
+		}
+		ops = append(ops, storage.CreateOp(key, nodeVal, 0))
+	}
+
+	if err := s.store.Txn(ctx, ops...); err != nil {
+		switch {
+		case errors.Is(err, errs.ErrResourceExists):
+			return nil, errs.ErrNodeConflict
+		case errors.Is(err, errs.ErrResourceVersionConflict) || errors.Is(err, errs.ErrResourceNotFound):
+			return nil, errs.ErrCredentialNotFound
+		}
+		return nil, errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"join node failed",
+			map[string]string{
+				"name":     bootstrap.Metadata.Name,
+				"nodeName": node.Metadata.Name,
+			},
+			err,
+		)
+	}
+	return node, nil
+}
+
+// DeleteNodeCredentials deletes every credential bound to the node and
+// returns how many there were.
+func (s *CredentialStore) DeleteNodeCredentials(ctx context.Context, nodeName string) (int, error) {
+	names := []string{}
+	err := walk(ctx, s.store, CredentialsPrefix, func(kv *storage.KeyValue) error {
+		credential, err := decodeCredential(kv)
+		if err != nil {
+			return err
+		}
+		if credential.Spec.Role == authv1.RoleNode && credential.Spec.NodeName == nodeName {
+			names = append(names, credential.Metadata.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"delete node credentials failed",
+			map[string]string{
+				"nodeName": nodeName,
+			},
+			err,
+		)
+	}
+
+	deleted := 0
+	for _, name := range names {
+		if err := s.DeleteCredential(ctx, name); err != nil {
+			if errors.Is(err, errs.ErrCredentialNotFound) {
+				continue
+			}
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+func (s *CredentialStore) DeleteCredential(ctx context.Context, name string) error {
+	key := credentialKey(name)
+	if err := s.store.Delete(ctx, key); err != nil {
+		if errors.Is(err, errs.ErrResourceNotFound) {
+			return errs.ErrCredentialNotFound
+		}
+		return errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"delete credential failed",
+			map[string]string{
+				"name": name,
+			},
+			err,
+		)
+	}
+	return nil
+}
+
+func (s *CredentialStore) GetCredentials(ctx context.Context, opts metav1.ListOptions) (*authv1.CredentialList, error) {
+	credentials := []*authv1.Credential{}
+
+	meta, err := list(ctx, s.store, CredentialsPrefix, opts, func(kv *storage.KeyValue, sel labels.Selector) bool {
+		credential, err := decodeCredential(kv)
+		if err != nil {
+			zlog.Error().Err(err).Str("component", "store").Str("resource", "credential").Msg("unmarshal failed")
+			return false
+		}
+		if !sel.Matches(credential.Metadata.Labels) {
+			return false
+		}
+		credentials = append(credentials, credential)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &authv1.CredentialList{Metadata: meta, Items: credentials}, nil
+}
+
+func decodeCredential(kv *storage.KeyValue) (*authv1.Credential, error) {
+	credential := &authv1.Credential{}
+	if _, err := codec.Decode(authv1.KindCredential, kv.Value, credential); err != nil {
+		return nil, err
+	}
+	credential.Metadata.ResourceVersion = storage.FormatResourceVersion(kv.Revision)
+	return credential, nil
+}
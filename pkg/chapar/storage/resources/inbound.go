@@ -2,17 +2,22 @@ package resources
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"sync"
+	"time"
 
 	zlog "github.com/rs/zerolog/log"
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
 	satrapv1 "github.com/vayzur/apadana/pkg/apis/satrap/v1"
 	"github.com/vayzur/apadana/pkg/chapar/storage"
 	"github.com/vayzur/apadana/pkg/errs"
+	"github.com/vayzur/apadana/pkg/labels"
 )
 
+// indexChunkSize is the number of index entries deleted per transaction,
+// within etcd's default limit of 128 ops.
+const indexChunkSize = 120
+
 var (
 	inboundPool = sync.Pool{
 		New: func() any { return &satrapv1.Inbound{} },
@@ -31,8 +36,8 @@ func NewInboundStore(store storage.Interface) *InboundStore {
 }
 
 func (s *InboundStore) GetInbound(ctx context.Context, nodeName, tag string) (*satrapv1.Inbound, error) {
-	key := fmt.Sprintf("/inbounds/%s/%s", nodeName, tag)
-	out := &[]byte{}
+	key := inboundKey(nodeName, tag)
+	out := &storage.KeyValue{}
 
 	if err := s.store.Get(ctx, key, out); err != nil {
 		if errors.Is(err, errs.ErrResourceNotFound) {
@@ -50,8 +55,8 @@ func (s *InboundStore) GetInbound(ctx context.Context, nodeName, tag string) (*s
 		)
 	}
 
-	inbound := &satrapv1.Inbound{}
-	if err := json.Unmarshal(*out, inbound); err != nil {
+	inbound, err := decodeInbound(out)
+	if err != nil {
 		return nil, errs.New(
 			errs.KindInternal,
 			errs.ReasonUnmarshalFailed,
@@ -67,12 +72,51 @@ func (s *InboundStore) GetInbound(ctx context.Context, nodeName, tag string) (*s
 	return inbound, nil
 }
 
-func (s *InboundStore) CreateInbound(ctx context.Context, nodeName string, inbound *satrapv1.Inbound) error {
-	val, err := json.Marshal(inbound)
+// CreateInbound creates inbound unless the node already has maxInbounds
+// inbounds, in which case errs.ErrNodeCapacityExceeded is returned. A zero
+// maxInbounds means no limit. An inbound without a port is given the lowest
+// one of ports not used by another inbound of the node; one with ports fails
+// with a port conflict if any of them is used.
+func (s *InboundStore) CreateInbound(ctx context.Context, nodeName string, inbound *satrapv1.Inbound, maxInbounds uint32, ports PortRange) error {
+	key := inboundKey(nodeName, inbound.Spec.Config.Tag)
+	needsPort := NeedsPort(&inbound.Spec.Config)
+
+	prepare := func(ctx context.Context) ([]storage.Op, error) {
+		if needsPort {
+			inbound.Spec.Config.PortList = nil
+		}
+		if err := s.assignPort(ctx, nodeName, inbound, ports); err != nil {
+			return nil, err
+		}
+
+		val, err := encodeInbound(inbound)
+		if err != nil {
+			return nil, errs.New(
+				errs.KindInternal,
+				errs.ReasonMarshalFailed,
+				"create inbound failed",
+				map[string]string{
+					"nodeName": nodeName,
+					"tag":      inbound.Spec.Config.Tag,
+				},
+				err,
+			)
+		}
+		return []storage.Op{storage.CreateOp(key, val, leaseTTL(inbound.Spec.ExpiresAt))}, nil
+	}
+
+	err := admitFunc(ctx, s.store, inboundsGuardKey(nodeName), inboundsKey(nodeName), maxInbounds, errs.ErrNodeCapacityExceeded, prepare)
 	if err != nil {
+		if errors.Is(err, errs.ErrResourceExists) {
+			return errs.ErrInboundConflict
+		}
+		var e *errs.Error
+		if errors.As(err, &e) {
+			return err
+		}
 		return errs.New(
 			errs.KindInternal,
-			errs.ReasonMarshalFailed,
+			errs.ReasonUnknown,
 			"create inbound failed",
 			map[string]string{
 				"nodeName": nodeName,
@@ -82,12 +126,48 @@ func (s *InboundStore) CreateInbound(ctx context.Context, nodeName string, inbou
 		)
 	}
 
-	key := fmt.Sprintf("/inbounds/%s/%s", nodeName, inbound.Spec.Config.Tag)
-	if err := s.store.Create(ctx, key, val, uint64(inbound.Spec.TTL.Seconds())); err != nil {
+	return nil
+}
+
+// UpdateInbound writes inbound without touching its expiry.
+func (s *InboundStore) UpdateInbound(ctx context.Context, nodeName string, inbound *satrapv1.Inbound) error {
+	return s.updateInbound(ctx, nodeName, inbound, 0)
+}
+
+// RenewInbound writes inbound and moves it to a lease ending at its ExpiresAt.
+func (s *InboundStore) RenewInbound(ctx context.Context, nodeName string, inbound *satrapv1.Inbound) error {
+	return s.updateInbound(ctx, nodeName, inbound, leaseTTL(inbound.Spec.ExpiresAt))
+}
+
+func (s *InboundStore) updateInbound(ctx context.Context, nodeName string, inbound *satrapv1.Inbound, ttl uint64) error {
+	rev, err := storage.ParseResourceVersion(inbound.Metadata.ResourceVersion)
+	if err != nil {
+		return err
+	}
+
+	val, err := encodeInbound(inbound)
+	if err != nil {
+		return errs.New(
+			errs.KindInternal,
+			errs.ReasonMarshalFailed,
+			"update inbound failed",
+			map[string]string{
+				"nodeName": nodeName,
+				"tag":      inbound.Spec.Config.Tag,
+			},
+			err,
+		)
+	}
+
+	key := inboundKey(nodeName, inbound.Spec.Config.Tag)
+	if err := s.store.Update(ctx, key, val, ttl, rev); err != nil {
+		if errors.Is(err, errs.ErrResourceVersionConflict) {
+			return err
+		}
 		return errs.New(
 			errs.KindInternal,
 			errs.ReasonUnknown,
-			"create inbound failed",
+			"update inbound failed",
 			map[string]string{
 				"nodeName": nodeName,
 				"tag":      inbound.Spec.Config.Tag,
@@ -99,9 +179,13 @@ func (s *InboundStore) CreateInbound(ctx context.Context, nodeName string, inbou
 	return nil
 }
 
+// DeleteInbound removes an inbound together with all of its users in a single
+// transaction, so a failure never leaves orphaned users or a half-deleted inbound.
 func (s *InboundStore) DeleteInbound(ctx context.Context, nodeName, tag string) error {
-	key := fmt.Sprintf("/inbounds/%s/%s", nodeName, tag)
-	if err := s.store.Delete(ctx, key); err != nil {
+	key := inboundKey(nodeName, tag)
+	out := &storage.KeyValue{}
+
+	if err := s.store.Get(storage.WithQuorum(ctx), key, out); err != nil {
 		if errors.Is(err, errs.ErrResourceNotFound) {
 			return errs.ErrInboundNotFound
 		}
@@ -116,44 +200,103 @@ func (s *InboundStore) DeleteInbound(ctx context.Context, nodeName, tag string)
 			err,
 		)
 	}
-	return nil
-}
 
-func (s *InboundStore) GetInbounds(ctx context.Context, nodeName string) ([]*satrapv1.Inbound, error) {
-	key := fmt.Sprintf("/inbounds/%s/", nodeName)
-	out := &[][]byte{}
+	index, err := s.userIndexKeys(ctx, nodeName, tag)
+	if err != nil {
+		return errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"delete inbound failed",
+			map[string]string{
+				"nodeName": nodeName,
+				"tag":      tag,
+			},
+			err,
+		)
+	}
+	// what does not fit in the transaction is deleted after it
+	n := min(len(index), indexChunkSize)
+
+	ops := []storage.Op{
+		storage.DeleteOp(usersKey(nodeName, tag)),
+		{Type: storage.OpDelete, Key: key, ResourceVersion: out.Revision},
+	}
+	for _, k := range index[:n] {
+		ops = append(ops, storage.DeleteOp(k))
+	}
 
-	if err := s.store.GetList(ctx, key, out); err != nil {
-		return nil, errs.New(
+	if err := s.store.Txn(ctx, ops...); err != nil {
+		if errors.Is(err, errs.ErrResourceVersionConflict) {
+			return err
+		}
+		return errs.New(
 			errs.KindInternal,
 			errs.ReasonUnknown,
-			"get inbounds failed",
+			"delete inbound failed",
 			map[string]string{
 				"nodeName": nodeName,
+				"tag":      tag,
 			},
 			err,
 		)
 	}
 
-	inbounds := make([]*satrapv1.Inbound, 0, len(*out))
+	for start := n; start < len(index); start += indexChunkSize {
+		var chunk []storage.Op
+		for _, k := range index[start:min(start+indexChunkSize, len(index))] {
+			chunk = append(chunk, storage.DeleteOp(k))
+		}
+		if err := s.store.Txn(ctx, chunk...); err != nil {
+			// stale entries are dropped by the next lookup that finds them
+			zlog.Warn().Err(err).Str("component", "inbound").Str("nodeName", nodeName).Str("tag", tag).Msg("index cleanup failed")
+			break
+		}
+	}
+	return nil
+}
+
+// userIndexKeys returns the email index keys of the users of an inbound.
+// Users created after it has read them are not included.
+func (s *InboundStore) userIndexKeys(ctx context.Context, nodeName, tag string) ([]string, error) {
+	var keys []string
+	err := walk(storage.WithQuorum(ctx), s.store, usersKey(nodeName, tag), func(kv *storage.KeyValue) error {
+		if parts := splitKey(kv.Key, UsersPrefix); len(parts) == 3 {
+			keys = append(keys, userEmailIndexKey(parts[0], parts[1], parts[2]))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (s *InboundStore) GetInbounds(ctx context.Context, nodeName string, opts metav1.ListOptions) (*satrapv1.InboundList, error) {
+	inbounds := []*satrapv1.Inbound{}
 
-	for _, v := range *out {
+	meta, err := list(ctx, s.store, inboundsKey(nodeName), opts, func(kv *storage.KeyValue, sel labels.Selector) bool {
 		inbound := inboundPool.Get().(*satrapv1.Inbound)
 		*inbound = satrapv1.Inbound{}
 
-		if err := json.Unmarshal(v, inbound); err != nil {
+		if _, err := codec.Decode(satrapv1.KindInbound, kv.Value, inbound); err != nil {
 			zlog.Error().Err(err).Str("component", "inbound").Str("nodeName", nodeName).Msg("unmarshal failed")
 			inboundPool.Put(inbound)
-			continue
+			return false
 		}
+		if !sel.Matches(inbound.Metadata.Labels) {
+			inboundPool.Put(inbound)
+			return false
+		}
+		inbound.Metadata.ResourceVersion = storage.FormatResourceVersion(kv.Revision)
 		inbounds = append(inbounds, inbound)
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return inbounds, nil
+	return &satrapv1.InboundList{Metadata: meta, Items: inbounds}, nil
 }
 
 func (s *InboundStore) CountInbounds(ctx context.Context, nodeName string) (uint32, error) {
-	key := fmt.Sprintf("/inbounds/%s/", nodeName)
+	key := inboundsKey(nodeName)
 	count, err := s.store.Count(ctx, key)
 	if err != nil {
 		return 0, errs.New(
@@ -171,8 +314,8 @@ func (s *InboundStore) CountInbounds(ctx context.Context, nodeName string) (uint
 }
 
 func (s *InboundStore) GetUser(ctx context.Context, nodeName, tag, email string) (*satrapv1.InboundUser, error) {
-	key := fmt.Sprintf("/inboundUsers/%s/%s/%s", nodeName, tag, email)
-	out := &[]byte{}
+	key := userKey(nodeName, tag, email)
+	out := &storage.KeyValue{}
 
 	if err := s.store.Get(ctx, key, out); err != nil {
 		if errors.Is(err, errs.ErrResourceNotFound) {
@@ -191,8 +334,8 @@ func (s *InboundStore) GetUser(ctx context.Context, nodeName, tag, email string)
 		)
 	}
 
-	user := &satrapv1.InboundUser{}
-	if err := json.Unmarshal(*out, user); err != nil {
+	user, err := decodeUser(out)
+	if err != nil {
 		return nil, errs.New(
 			errs.KindInternal,
 			errs.ReasonUnmarshalFailed,
@@ -209,8 +352,11 @@ func (s *InboundStore) GetUser(ctx context.Context, nodeName, tag, email string)
 	return user, nil
 }
 
-func (s *InboundStore) CreateUser(ctx context.Context, nodeName, tag string, inboundUser *satrapv1.InboundUser) error {
-	val, err := json.Marshal(inboundUser)
+// CreateUser creates inboundUser unless the inbound already has maxUsers
+// users, in which case errs.ErrInboundCapacityExceeded is returned. A zero
+// maxUsers means no limit.
+func (s *InboundStore) CreateUser(ctx context.Context, nodeName, tag string, inboundUser *satrapv1.InboundUser, maxUsers uint32) error {
+	val, err := encodeUser(inboundUser)
 	if err != nil {
 		return errs.New(
 			errs.KindInternal,
@@ -225,8 +371,18 @@ func (s *InboundStore) CreateUser(ctx context.Context, nodeName, tag string, inb
 		)
 	}
 
-	key := fmt.Sprintf("/inboundUsers/%s/%s/%s", nodeName, tag, inboundUser.Spec.Email)
-	if err := s.store.Create(ctx, key, val, uint64(inboundUser.Spec.TTL.Seconds())); err != nil {
+	// the index entry shares the user's lease, so that they expire together
+	ttl := leaseTTL(inboundUser.Spec.ExpiresAt)
+	key := userKey(nodeName, tag, inboundUser.Spec.Email)
+	create := storage.CreateOp(key, val, ttl)
+	index := storage.PutOp(userEmailIndexKey(nodeName, tag, inboundUser.Spec.Email), nil, ttl)
+	if err := admit(ctx, s.store, usersGuardKey(nodeName, tag), usersKey(nodeName, tag), maxUsers, errs.ErrInboundCapacityExceeded, []storage.Op{create}, index); err != nil {
+		if errors.Is(err, errs.ErrResourceExists) {
+			return errs.ErrUserConflict
+		}
+		if errors.Is(err, errs.ErrInboundCapacityExceeded) || errors.Is(err, errs.ErrResourceVersionConflict) {
+			return err
+		}
 		return errs.New(
 			errs.KindInternal,
 			errs.ReasonUnknown,
@@ -243,83 +399,119 @@ func (s *InboundStore) CreateUser(ctx context.Context, nodeName, tag string, inb
 	return nil
 }
 
-func (s *InboundStore) DeleteUser(ctx context.Context, nodeName, tag, email string) error {
-	key := fmt.Sprintf("/inboundUsers/%s/%s/%s", nodeName, tag, email)
-	if err := s.store.Delete(ctx, key); err != nil {
-		if errors.Is(err, errs.ErrResourceNotFound) {
-			return errs.ErrUserNotFound
-		}
+// UpdateUser writes inboundUser without touching its expiry.
+func (s *InboundStore) UpdateUser(ctx context.Context, nodeName, tag string, inboundUser *satrapv1.InboundUser) error {
+	return s.updateUser(ctx, nodeName, tag, inboundUser, 0)
+}
+
+// RenewUser writes inboundUser and moves it to a lease ending at its ExpiresAt.
+func (s *InboundStore) RenewUser(ctx context.Context, nodeName, tag string, inboundUser *satrapv1.InboundUser) error {
+	return s.updateUser(ctx, nodeName, tag, inboundUser, leaseTTL(inboundUser.Spec.ExpiresAt))
+}
+
+func (s *InboundStore) updateUser(ctx context.Context, nodeName, tag string, inboundUser *satrapv1.InboundUser, ttl uint64) error {
+	rev, err := storage.ParseResourceVersion(inboundUser.Metadata.ResourceVersion)
+	if err != nil {
+		return err
+	}
+
+	val, err := encodeUser(inboundUser)
+	if err != nil {
 		return errs.New(
 			errs.KindInternal,
-			errs.ReasonUnknown,
-			"delete inbound user failed",
+			errs.ReasonMarshalFailed,
+			"update inbound user failed",
 			map[string]string{
 				"nodeName": nodeName,
 				"tag":      tag,
-				"email":    email,
+				"email":    inboundUser.Spec.Email,
 			},
 			err,
 		)
 	}
-	return nil
-}
 
-func (s *InboundStore) DeleteUsers(ctx context.Context, nodeName, tag string) error {
-	key := fmt.Sprintf("/inboundUsers/%s/%s/", nodeName, tag)
-	if err := s.store.Delete(ctx, key); err != nil {
-		if errors.Is(err, errs.ErrResourceNotFound) {
-			return errs.ErrUserNotFound
+	key := userKey(nodeName, tag, inboundUser.Spec.Email)
+	ops := []storage.Op{storage.UpdateOp(key, val, ttl, rev)}
+	if ttl != 0 {
+		// the index entry moves to the new lease along with the user
+		ops = append(ops, storage.PutOp(userEmailIndexKey(nodeName, tag, inboundUser.Spec.Email), nil, ttl))
+	}
+	if err := s.store.Txn(ctx, ops...); err != nil {
+		if errors.Is(err, errs.ErrResourceVersionConflict) {
+			return err
 		}
 		return errs.New(
 			errs.KindInternal,
 			errs.ReasonUnknown,
-			"delete inbound users failed",
+			"update inbound user failed",
 			map[string]string{
 				"nodeName": nodeName,
 				"tag":      tag,
+				"email":    inboundUser.Spec.Email,
 			},
 			err,
 		)
 	}
+
 	return nil
 }
 
-func (s *InboundStore) GetUsers(ctx context.Context, nodeName, tag string) ([]*satrapv1.InboundUser, error) {
-	key := fmt.Sprintf("/inboundUsers/%s/%s/", nodeName, tag)
-	out := &[][]byte{}
-
-	if err := s.store.GetList(ctx, key, out); err != nil {
-		return nil, errs.New(
+// DeleteUser removes the user and then its index entry. An entry left behind
+// by a failure is dropped by the next lookup that finds it stale.
+func (s *InboundStore) DeleteUser(ctx context.Context, nodeName, tag, email string) error {
+	key := userKey(nodeName, tag, email)
+	if err := s.store.Delete(ctx, key); err != nil {
+		if errors.Is(err, errs.ErrResourceNotFound) {
+			return errs.ErrUserNotFound
+		}
+		return errs.New(
 			errs.KindInternal,
 			errs.ReasonUnknown,
-			"get inbound users failed",
+			"delete inbound user failed",
 			map[string]string{
 				"nodeName": nodeName,
 				"tag":      tag,
+				"email":    email,
 			},
 			err,
 		)
 	}
 
-	users := make([]*satrapv1.InboundUser, 0, len(*out))
+	if err := s.store.Txn(ctx, storage.DeleteOp(userEmailIndexKey(nodeName, tag, email))); err != nil {
+		zlog.Warn().Err(err).Str("component", "inboundUser").Str("nodeName", nodeName).Str("tag", tag).Str("email", email).Msg("index cleanup failed")
+	}
+	return nil
+}
+
+func (s *InboundStore) GetUsers(ctx context.Context, nodeName, tag string, opts metav1.ListOptions) (*satrapv1.InboundUserList, error) {
+	users := []*satrapv1.InboundUser{}
 
-	for _, v := range *out {
+	meta, err := list(ctx, s.store, usersKey(nodeName, tag), opts, func(kv *storage.KeyValue, sel labels.Selector) bool {
 		user := userPool.Get().(*satrapv1.InboundUser)
 		*user = satrapv1.InboundUser{}
 
-		if err := json.Unmarshal(v, user); err != nil {
+		if _, err := codec.Decode(satrapv1.KindInboundUser, kv.Value, user); err != nil {
 			zlog.Error().Err(err).Str("component", "inboundUser").Str("nodeName", nodeName).Str("tag", tag).Msg("unmarshal failed")
 			userPool.Put(user)
-			continue
+			return false
 		}
+		if !sel.Matches(user.Metadata.Labels) {
+			userPool.Put(user)
+			return false
+		}
+		user.Metadata.ResourceVersion = storage.FormatResourceVersion(kv.Revision)
 		users = append(users, user)
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return users, nil
+	return &satrapv1.InboundUserList{Metadata: meta, Items: users}, nil
 }
 
 func (s *InboundStore) CountUsers(ctx context.Context, nodeName, tag string) (uint32, error) {
-	key := fmt.Sprintf("/inboundUsers/%s/%s/", nodeName, tag)
+	key := usersKey(nodeName, tag)
 	count, err := s.store.Count(ctx, key)
 	if err != nil {
 		return 0, errs.New(
@@ -336,3 +528,98 @@ func (s *InboundStore) CountUsers(ctx context.Context, nodeName, tag string) (ui
 
 	return count, nil
 }
+
+// CountPerNode counts the inbounds and the users of every node, reading each
+// prefix once.
+func (s *InboundStore) CountPerNode(ctx context.Context) (inbounds, users map[string]uint32, err error) {
+	inbounds, err = countPerNode(ctx, s.store, InboundsPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	users, err = countPerNode(ctx, s.store, UsersPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	return inbounds, users, nil
+}
+
+func countPerNode(ctx context.Context, store storage.Interface, prefix string) (map[string]uint32, error) {
+	counts := make(map[string]uint32)
+	err := walk(ctx, store, prefix, func(kv *storage.KeyValue) error {
+		counts[splitKey(kv.Key, prefix)[0]]++
+		return nil
+	})
+	if err != nil {
+		return nil, errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"count per node failed",
+			map[string]string{
+				"prefix": prefix,
+			},
+			err,
+		)
+	}
+	return counts, nil
+}
+
+func (s *InboundStore) WatchInbounds(ctx context.Context, nodeName string, fromRevision int64) (<-chan metav1.WatchEvent, error) {
+	key := inboundsKey(nodeName)
+	return watch(ctx, s.store, key, fromRevision, func(kv *storage.KeyValue) (any, error) {
+		return decodeInbound(kv)
+	})
+}
+
+func (s *InboundStore) WatchUsers(ctx context.Context, nodeName, tag string, fromRevision int64) (<-chan metav1.WatchEvent, error) {
+	key := usersKey(nodeName, tag)
+	return watch(ctx, s.store, key, fromRevision, func(kv *storage.KeyValue) (any, error) {
+		return decodeUser(kv)
+	})
+}
+
+func decodeInbound(kv *storage.KeyValue) (*satrapv1.Inbound, error) {
+	inbound := &satrapv1.Inbound{}
+	if _, err := codec.Decode(satrapv1.KindInbound, kv.Value, inbound); err != nil {
+		return nil, err
+	}
+	inbound.Metadata.ResourceVersion = storage.FormatResourceVersion(kv.Revision)
+	return inbound, nil
+}
+
+func decodeUser(kv *storage.KeyValue) (*satrapv1.InboundUser, error) {
+	user := &satrapv1.InboundUser{}
+	if _, err := codec.Decode(satrapv1.KindInboundUser, kv.Value, user); err != nil {
+		return nil, err
+	}
+	user.Metadata.ResourceVersion = storage.FormatResourceVersion(kv.Revision)
+	return user, nil
+}
+
+// encodeInbound marshals inbound without its resourceVersion, which is owned
+// by the backend, and without its status, which is computed on read.
+func encodeInbound(inbound *satrapv1.Inbound) ([]byte, error) {
+	i := *inbound
+	i.Metadata.ResourceVersion = ""
+	i.Status = nil
+	return codec.Encode(satrapv1.KindInbound, &i)
+}
+
+// encodeUser marshals user without its resourceVersion, which is owned by the backend.
+func encodeUser(user *satrapv1.InboundUser) ([]byte, error) {
+	u := *user
+	u.Metadata.ResourceVersion = ""
+	return codec.Encode(satrapv1.KindInboundUser, &u)
+}
+
+// leaseTTL converts an expiry time into the lease ttl in seconds, rounding up
+// so the key never disappears before expiresAt. Zero means no expiry.
+func leaseTTL(expiresAt *time.Time) uint64 {
+	if expiresAt == nil {
+		return 0
+	}
+	ttl := time.Until(*expiresAt)
+	if ttl <= 0 {
+		return 1
+	}
+	return uint64((ttl + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,110 @@
+package resources
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "github.com/vayzur/apadana/pkg/apis/core/v1"
+	satrapv1 "github.com/vayzur/apadana/pkg/apis/satrap/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage/scheme"
+)
+
+// Every resource lives under one of these prefixes. They are relative to the
+// storage root; the etcd backend places them under EtcdConfig.Prefix.
+const (
+	NodesPrefix    = "/nodes/"
+	InboundsPrefix = "/inbounds/"
+	UsersPrefix    = "/inboundUsers/"
+
+	// CredentialsPrefix holds the named credentials requests are signed with.
+	CredentialsPrefix = "/credentials/"
+
+	// AuditPrefix holds audit events, named by the time of their request so
+	// that they list in order. They are kept out of the watch cache.
+	AuditPrefix = "/audit/"
+
+	// AdmissionPrefix holds the guard keys that serialize creates against
+	// capacity limits.
+	AdmissionPrefix = "/admission/"
+
+	// UserEmailIndexPrefix holds an empty key per user, named
+	// <email>/<nodeName>/<tag>, to find a user's inbounds across nodes.
+	UserEmailIndexPrefix = "/index/usersByEmail/"
+)
+
+// Prefixes lists the prefixes of all persistent keys, for tools that need to
+// walk the whole tree.
+var Prefixes = []string{NodesPrefix, InboundsPrefix, UsersPrefix, CredentialsPrefix, AuditPrefix, AdmissionPrefix, UserEmailIndexPrefix}
+
+// EncryptedPrefixes lists the prefixes whose values hold secrets and are
+// encrypted when keys are configured.
+var EncryptedPrefixes = []string{UsersPrefix, CredentialsPrefix}
+
+// Kind ties a persisted kind to the prefix its objects are stored under.
+type Kind struct {
+	Prefix string
+	Name   string
+	New    func() scheme.Object
+}
+
+// Kinds lists every persisted kind, parents before their children, that
+// backups and migrations cover. Credentials are left out so that their tokens
+// never end up in an archive.
+var Kinds = []Kind{
+	{NodesPrefix, corev1.KindNode, func() scheme.Object { return &corev1.Node{} }},
+	{InboundsPrefix, satrapv1.KindInbound, func() scheme.Object { return &satrapv1.Inbound{} }},
+	{UsersPrefix, satrapv1.KindInboundUser, func() scheme.Object { return &satrapv1.InboundUser{} }},
+}
+
+func nodeKey(nodeName string) string {
+	return NodesPrefix + nodeName
+}
+
+func inboundsKey(nodeName string) string {
+	return fmt.Sprintf("%s%s/", InboundsPrefix, nodeName)
+}
+
+func inboundKey(nodeName, tag string) string {
+	return fmt.Sprintf("%s%s/%s", InboundsPrefix, nodeName, tag)
+}
+
+func usersKey(nodeName, tag string) string {
+	return fmt.Sprintf("%s%s/%s/", UsersPrefix, nodeName, tag)
+}
+
+func userKey(nodeName, tag, email string) string {
+	return fmt.Sprintf("%s%s/%s/%s", UsersPrefix, nodeName, tag, email)
+}
+
+func credentialKey(name string) string {
+	return CredentialsPrefix + name
+}
+
+func auditEventKey(name string) string {
+	return AuditPrefix + name
+}
+
+// auditTimeKey sorts before the keys of the events of t and after those of
+// earlier events.
+func auditTimeKey(t time.Time) string {
+	return fmt.Sprintf("%s%020d", AuditPrefix, t.UnixNano())
+}
+
+func inboundsGuardKey(nodeName string) string {
+	return AdmissionPrefix + inboundsKey(nodeName)[1:]
+}
+
+func usersGuardKey(nodeName, tag string) string {
+	return AdmissionPrefix + usersKey(nodeName, tag)[1:]
+}
+
+func userEmailIndexKey(nodeName, tag, email string) string {
+	return fmt.Sprintf("%s%s/%s/%s", UserEmailIndexPrefix, email, nodeName, tag)
+}
+
+// splitKey returns the slash separated parts of key below prefix, e.g. the
+// node name and tag of an inbound key.
+func splitKey(key, prefix string) []string {
+	return strings.Split(strings.TrimPrefix(key, prefix), "/")
+}
@@ -0,0 +1,108 @@
+package resources
+
+import (
+	"context"
+	"errors"
+
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+	"github.com/vayzur/apadana/pkg/labels"
+)
+
+// visitFunc decodes one listed value and keeps it when its labels match sel.
+// It reports whether the value was kept.
+type visitFunc func(kv *storage.KeyValue, sel labels.Selector) bool
+
+// list reads one page under prefix and passes every value to visit. With a
+// label selector, further pages of the same revision are read until
+// opts.Limit values were kept or prefix is exhausted, and no remaining item
+// count is reported. A malformed or expired continue token is returned as is
+// so callers can surface it to the client.
+func list(ctx context.Context, store storage.Interface, prefix string, opts metav1.ListOptions, visit visitFunc) (metav1.ListMeta, error) {
+	return listRange(ctx, store, prefix, "", "", opts, false, visit)
+}
+
+// listRange is list for the keys under prefix from start, up to but
+// excluding end, either of which may be empty, and for a visit that also
+// filters on more than labels, which filtered tells. Such lists are paged
+// like those with a selector.
+func listRange(ctx context.Context, store storage.Interface, prefix, start, end string, opts metav1.ListOptions, filtered bool, visit visitFunc) (metav1.ListMeta, error) {
+	if opts.Limit < 0 {
+		return metav1.ListMeta{}, errs.ErrInvalidLimit
+	}
+
+	sel, err := parseSelector(opts.LabelSelector)
+	if err != nil {
+		return metav1.ListMeta{}, err
+	}
+	filtered = filtered || !sel.Empty()
+
+	out := &storage.List{}
+	listOpts := storage.ListOptions{Limit: opts.Limit, Continue: opts.Continue, StartKey: start, EndKey: end}
+	var kept int64
+	var next string
+
+	for {
+		if err := store.GetList(ctx, prefix, listOpts, out); err != nil {
+			if errors.Is(err, errs.ErrInvalidContinue) || errors.Is(err, errs.ErrResourceExpired) {
+				return metav1.ListMeta{}, err
+			}
+			return metav1.ListMeta{}, errs.New(
+				errs.KindInternal,
+				errs.ReasonUnknown,
+				"list failed",
+				map[string]string{
+					"prefix": prefix,
+				},
+				err,
+			)
+		}
+
+		next = out.Continue
+		for i, kv := range out.Items {
+			if !visit(kv, sel) {
+				continue
+			}
+			kept++
+			if filtered && kept == opts.Limit && i < len(out.Items)-1 {
+				// the rest of the page is left to the next one
+				next = storage.EncodeContinue(out.Revision, kv.Key+"\x00")
+				break
+			}
+		}
+
+		if !filtered || opts.Limit == 0 || kept == opts.Limit || out.Continue == "" {
+			break
+		}
+		// full pages, so that a sparse match does not take ever more reads
+		listOpts = storage.ListOptions{Limit: opts.Limit, Continue: out.Continue, EndKey: end}
+	}
+
+	meta := metav1.ListMeta{
+		ResourceVersion: storage.FormatResourceVersion(out.Revision),
+		Continue:        next,
+	}
+	if next != "" && !filtered {
+		remaining := out.RemainingItemCount
+		meta.RemainingItemCount = &remaining
+	}
+
+	return meta, nil
+}
+
+func parseSelector(labelSelector string) (labels.Selector, error) {
+	sel, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, errs.New(
+			errs.KindInvalid,
+			errs.ReasonInvalidLabelSelector,
+			"invalid label selector",
+			map[string]string{
+				"labelSelector": labelSelector,
+			},
+			err,
+		)
+	}
+	return sel, nil
+}
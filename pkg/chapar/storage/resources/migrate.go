@@ -0,0 +1,60 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+const migratePageSize = 500
+
+// Migrate rewrites every stored object that is not at its storage version or
+// not in the configured encoding, and returns how many were rewritten.
+// Objects modified concurrently are skipped, since the write that changed
+// them already used the current version and encoding.
+func Migrate(ctx context.Context, store storage.Interface) (int, error) {
+	migrated := 0
+
+	for _, k := range Kinds {
+		opts := storage.ListOptions{Limit: migratePageSize}
+		for {
+			list := &storage.List{}
+			if err := store.GetList(ctx, k.Prefix, opts, list); err != nil {
+				return migrated, err
+			}
+
+			for _, kv := range list.Items {
+				obj := k.New()
+				stale, err := codec.Decode(k.Name, kv.Value, obj)
+				if err != nil {
+					return migrated, fmt.Errorf("%q: %w", kv.Key, err)
+				}
+				if !stale {
+					continue
+				}
+
+				val, err := codec.Encode(k.Name, obj)
+				if err != nil {
+					return migrated, fmt.Errorf("%q: %w", kv.Key, err)
+				}
+				if err := store.Update(ctx, kv.Key, val, 0, kv.Revision); err != nil {
+					if errors.Is(err, errs.ErrResourceVersionConflict) {
+						continue
+					}
+					return migrated, fmt.Errorf("%q: %w", kv.Key, err)
+				}
+				migrated++
+			}
+
+			if list.Continue == "" {
+				break
+			}
+			opts.Continue = list.Continue
+		}
+	}
+
+	return migrated, nil
+}
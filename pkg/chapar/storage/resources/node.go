@@ -2,15 +2,15 @@ package resources
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"sync"
 
 	zlog "github.com/rs/zerolog/log"
 	corev1 "github.com/vayzur/apadana/pkg/apis/core/v1"
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
 	"github.com/vayzur/apadana/pkg/chapar/storage"
 	"github.com/vayzur/apadana/pkg/errs"
+	"github.com/vayzur/apadana/pkg/labels"
 )
 
 var nodePool = sync.Pool{
@@ -26,8 +26,8 @@ func NewNodeStore(store storage.Interface) *NodeStore {
 }
 
 func (s *NodeStore) GetNode(ctx context.Context, nodeName string) (*corev1.Node, error) {
-	key := fmt.Sprintf("/nodes/%s", nodeName)
-	out := &[]byte{}
+	key := nodeKey(nodeName)
+	out := &storage.KeyValue{}
 
 	if err := s.store.Get(ctx, key, out); err != nil {
 		if errors.Is(err, errs.ErrResourceNotFound) {
@@ -44,8 +44,8 @@ func (s *NodeStore) GetNode(ctx context.Context, nodeName string) (*corev1.Node,
 		)
 	}
 
-	node := &corev1.Node{}
-	if err := json.Unmarshal(*out, node); err != nil {
+	node, err := decodeNode(out)
+	if err != nil {
 		return nil, errs.New(
 			errs.KindInternal,
 			errs.ReasonUnmarshalFailed,
@@ -61,7 +61,7 @@ func (s *NodeStore) GetNode(ctx context.Context, nodeName string) (*corev1.Node,
 }
 
 func (s *NodeStore) DeleteNode(ctx context.Context, nodeName string) error {
-	key := fmt.Sprintf("/nodes/%s", nodeName)
+	key := nodeKey(nodeName)
 	if err := s.store.Delete(ctx, key); err != nil {
 		if errors.Is(err, errs.ErrResourceNotFound) {
 			return errs.ErrNodeNotFound
@@ -80,7 +80,7 @@ func (s *NodeStore) DeleteNode(ctx context.Context, nodeName string) error {
 }
 
 func (s *NodeStore) CreateNode(ctx context.Context, node *corev1.Node) error {
-	val, err := json.Marshal(node)
+	val, err := encodeNode(node)
 	if err != nil {
 		return errs.New(
 			errs.KindInternal,
@@ -91,7 +91,7 @@ func (s *NodeStore) CreateNode(ctx context.Context, node *corev1.Node) error {
 		)
 	}
 
-	key := fmt.Sprintf("/nodes/%s", node.Metadata.Name)
+	key := nodeKey(node.Metadata.Name)
 	if err := s.store.Create(ctx, key, val, 0); err != nil {
 		return errs.New(
 			errs.KindInternal,
@@ -105,33 +105,91 @@ func (s *NodeStore) CreateNode(ctx context.Context, node *corev1.Node) error {
 	return nil
 }
 
-func (s *NodeStore) GetNodes(ctx context.Context) ([]*corev1.Node, error) {
-	key := "/nodes/"
-	out := &[][]byte{}
+func (s *NodeStore) UpdateNode(ctx context.Context, node *corev1.Node) error {
+	rev, err := storage.ParseResourceVersion(node.Metadata.ResourceVersion)
+	if err != nil {
+		return err
+	}
 
-	if err := s.store.GetList(ctx, key, out); err != nil {
-		return nil, errs.New(
+	val, err := encodeNode(node)
+	if err != nil {
+		return errs.New(
+			errs.KindInternal,
+			errs.ReasonMarshalFailed,
+			"update node failed",
+			map[string]string{
+				"nodeName": node.Metadata.Name,
+			},
+			err,
+		)
+	}
+
+	key := nodeKey(node.Metadata.Name)
+	if err := s.store.Update(ctx, key, val, 0, rev); err != nil {
+		if errors.Is(err, errs.ErrResourceVersionConflict) {
+			return err
+		}
+		return errs.New(
 			errs.KindInternal,
 			errs.ReasonUnknown,
-			"get nodes failed",
-			nil,
+			"update node failed",
+			map[string]string{
+				"nodeName": node.Metadata.Name,
+			},
 			err,
 		)
 	}
 
-	nodes := make([]*corev1.Node, 0, len(*out))
+	return nil
+}
+
+func (s *NodeStore) GetNodes(ctx context.Context, opts metav1.ListOptions) (*corev1.NodeList, error) {
+	nodes := []*corev1.Node{}
 
-	for _, v := range *out {
+	meta, err := list(ctx, s.store, NodesPrefix, opts, func(kv *storage.KeyValue, sel labels.Selector) bool {
 		node := nodePool.Get().(*corev1.Node)
 		*node = corev1.Node{}
 
-		if err := json.Unmarshal(v, node); err != nil {
+		if _, err := codec.Decode(corev1.KindNode, kv.Value, node); err != nil {
 			zlog.Error().Err(err).Str("component", "store").Str("resource", "node").Msg("unmarshal failed")
 			nodePool.Put(node)
-			continue
+			return false
 		}
+		if !sel.Matches(node.Metadata.Labels) {
+			nodePool.Put(node)
+			return false
+		}
+		node.Metadata.ResourceVersion = storage.FormatResourceVersion(kv.Revision)
 		nodes = append(nodes, node)
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nodes, nil
+	return &corev1.NodeList{Metadata: meta, Items: nodes}, nil
+}
+
+func (s *NodeStore) WatchNodes(ctx context.Context, fromRevision int64) (<-chan metav1.WatchEvent, error) {
+	return watch(ctx, s.store, NodesPrefix, fromRevision, func(kv *storage.KeyValue) (any, error) {
+		return decodeNode(kv)
+	})
+}
+
+func decodeNode(kv *storage.KeyValue) (*corev1.Node, error) {
+	node := &corev1.Node{}
+	if _, err := codec.Decode(corev1.KindNode, kv.Value, node); err != nil {
+		return nil, err
+	}
+	node.Metadata.ResourceVersion = storage.FormatResourceVersion(kv.Revision)
+	return node, nil
+}
+
+// encodeNode marshals node without its resourceVersion, which is owned by the
+// backend, and without its remaining capacity, which is computed on read.
+func encodeNode(node *corev1.Node) ([]byte, error) {
+	n := *node
+	n.Metadata.ResourceVersion = ""
+	n.Status.Remaining = nil
+	return codec.Encode(corev1.KindNode, &n)
 }
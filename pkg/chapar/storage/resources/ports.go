@@ -0,0 +1,164 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	satrapv1 "github.com/vayzur/apadana/pkg/apis/satrap/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// PortRange is a closed range of ports. The zero value is empty.
+type PortRange struct {
+	From uint32
+	To   uint32
+}
+
+// DefaultPortRange is where ports are allocated when no range is configured.
+var DefaultPortRange = PortRange{From: 20000, To: 29999}
+
+// ParsePortRange reads a range written as "from-to".
+func ParsePortRange(s string) (PortRange, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok {
+		return PortRange{}, fmt.Errorf("port range %q is not in the form from-to", s)
+	}
+	f, err := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	t, err := strconv.ParseUint(strings.TrimSpace(to), 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	if f == 0 || f > t {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	return PortRange{From: uint32(f), To: uint32(t)}, nil
+}
+
+func (r PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
+// free returns the lowest port of r that none of used covers.
+func (r PortRange) free(used []conf.PortRange) (uint32, bool) {
+	if r.From == 0 {
+		return 0, false
+	}
+	taken := make(map[uint32]struct{})
+	for _, u := range used {
+		for p := max(u.From, r.From); p <= min(u.To, r.To); p++ {
+			taken[p] = struct{}{}
+		}
+	}
+	for p := r.From; p <= r.To; p++ {
+		if _, ok := taken[p]; !ok {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// NeedsPort reports whether cfg leaves its port to be allocated: it sets
+// none, or port 0, and does not listen on a unix socket.
+func NeedsPort(cfg *conf.InboundDetourConfig) bool {
+	if listensOnSocket(cfg) {
+		return false
+	}
+	return cfg.PortList == nil || len(cfg.PortList.Range) == 0
+}
+
+// inboundPorts returns the ports cfg listens on.
+func inboundPorts(cfg *conf.InboundDetourConfig) []conf.PortRange {
+	if listensOnSocket(cfg) || cfg.PortList == nil {
+		return nil
+	}
+	return cfg.PortList.Range
+}
+
+func listensOnSocket(cfg *conf.InboundDetourConfig) bool {
+	if cfg.ListenOn == nil || !cfg.ListenOn.Family().IsDomain() {
+		return false
+	}
+	domain := cfg.ListenOn.Domain()
+	return filepath.IsAbs(domain) || strings.HasPrefix(domain, "@")
+}
+
+// overlap returns a port both a and b cover.
+func overlap(a, b []conf.PortRange) (uint32, bool) {
+	for _, x := range a {
+		for _, y := range b {
+			if from := max(x.From, y.From); from <= min(x.To, y.To) {
+				return from, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// usedPorts returns the ports of every inbound on the node but the one
+// tagged tag.
+func (s *InboundStore) usedPorts(ctx context.Context, nodeName, tag string) ([]conf.PortRange, error) {
+	used := []conf.PortRange{}
+	err := walk(ctx, s.store, inboundsKey(nodeName), func(kv *storage.KeyValue) error {
+		inbound := &satrapv1.Inbound{}
+		if _, err := codec.Decode(satrapv1.KindInbound, kv.Value, inbound); err != nil {
+			return err
+		}
+		if inbound.Spec.Config.Tag != tag {
+			used = append(used, inboundPorts(&inbound.Spec.Config)...)
+		}
+		return nil
+	})
+	return used, err
+}
+
+// assignPort checks the ports of inbound against the other inbounds of the
+// node, or gives it the lowest free port of ports when it has none.
+func (s *InboundStore) assignPort(ctx context.Context, nodeName string, inbound *satrapv1.Inbound, ports PortRange) error {
+	cfg := &inbound.Spec.Config
+	used, err := s.usedPorts(ctx, nodeName, cfg.Tag)
+	if err != nil {
+		return err
+	}
+
+	if !NeedsPort(cfg) {
+		if port, ok := overlap(inboundPorts(cfg), used); ok {
+			return errs.New(
+				errs.KindConflict,
+				errs.ReasonPortConflict,
+				"port already in use on node",
+				map[string]string{
+					"nodeName": nodeName,
+					"tag":      cfg.Tag,
+					"port":     strconv.FormatUint(uint64(port), 10),
+				},
+				nil,
+			)
+		}
+		return nil
+	}
+
+	port, ok := ports.free(used)
+	if !ok {
+		return errs.New(
+			errs.KindCapacityExceeded,
+			errs.ReasonPortsExhausted,
+			"no free port on node",
+			map[string]string{
+				"nodeName":  nodeName,
+				"tag":       cfg.Tag,
+				"portRange": ports.String(),
+			},
+			nil,
+		)
+	}
+	cfg.PortList = &conf.PortList{Range: []conf.PortRange{{From: port, To: port}}}
+	return nil
+}
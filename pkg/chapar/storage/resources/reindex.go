@@ -0,0 +1,97 @@
+package resources
+
+import (
+	"context"
+	"errors"
+
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+const reindexPageSize = 500
+
+// Reindex brings the user email index in line with the stored users: it adds
+// the entries that are missing and removes those whose user no longer
+// exists. It is safe to run while chapar is serving, since users created
+// meanwhile index themselves.
+func Reindex(ctx context.Context, store storage.Interface) (added, removed int, err error) {
+	// index key -> revision of every existing entry
+	entries := make(map[string]int64)
+	if err := walk(ctx, store, UserEmailIndexPrefix, func(kv *storage.KeyValue) error {
+		entries[kv.Key] = kv.Revision
+		return nil
+	}); err != nil {
+		return 0, 0, err
+	}
+
+	if err := walk(ctx, store, UsersPrefix, func(kv *storage.KeyValue) error {
+		parts := splitKey(kv.Key, UsersPrefix)
+		if len(parts) != 3 {
+			return nil
+		}
+		key := userEmailIndexKey(parts[0], parts[1], parts[2])
+		if _, ok := entries[key]; ok {
+			delete(entries, key)
+			return nil
+		}
+		user, err := decodeUser(kv)
+		if err != nil {
+			return err
+		}
+		if err := store.Txn(ctx, storage.PutOp(key, nil, leaseTTL(user.Spec.ExpiresAt))); err != nil {
+			return err
+		}
+		added++
+		return nil
+	}); err != nil {
+		return added, 0, err
+	}
+
+	// what is left had no user when the users were walked
+	quorum := storage.WithQuorum(ctx)
+	for key, rev := range entries {
+		parts := splitKey(key, UserEmailIndexPrefix)
+		if len(parts) != 3 {
+			continue
+		}
+		kv := &storage.KeyValue{}
+		err := store.Get(quorum, userKey(parts[1], parts[2], parts[0]), kv)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, errs.ErrResourceNotFound) {
+			return added, removed, err
+		}
+
+		op := storage.Op{Type: storage.OpDelete, Key: key, ResourceVersion: rev}
+		if err := store.Txn(ctx, op); err != nil {
+			if errors.Is(err, errs.ErrResourceVersionConflict) {
+				continue
+			}
+			return added, removed, err
+		}
+		removed++
+	}
+
+	return added, removed, nil
+}
+
+// walk calls fn for every key under prefix, reading it page by page.
+func walk(ctx context.Context, store storage.Interface, prefix string, fn func(kv *storage.KeyValue) error) error {
+	opts := storage.ListOptions{Limit: reindexPageSize}
+	for {
+		list := &storage.List{}
+		if err := store.GetList(ctx, prefix, opts, list); err != nil {
+			return err
+		}
+		for _, kv := range list.Items {
+			if err := fn(kv); err != nil {
+				return err
+			}
+		}
+		if list.Continue == "" {
+			return nil
+		}
+		opts.Continue = list.Continue
+	}
+}
@@ -0,0 +1,81 @@
+package resources
+
+import (
+	"encoding/json"
+	"time"
+
+	auditv1 "github.com/vayzur/apadana/pkg/apis/audit/v1"
+	authv1 "github.com/vayzur/apadana/pkg/apis/auth/v1"
+	corev1 "github.com/vayzur/apadana/pkg/apis/core/v1"
+	satrapv1 "github.com/vayzur/apadana/pkg/apis/satrap/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage/scheme"
+)
+
+// codec encodes every stored object at its current storage version and
+// upgrades older ones as they are read.
+var codec = newScheme()
+
+// Codec returns the scheme stored objects are encoded with.
+func Codec() *scheme.Scheme {
+	return codec
+}
+
+// SetEncoding selects the encoding objects are written with, "json" (the
+// default) or "cbor". Objects in either encoding are read regardless. It must
+// be called before the stores are used.
+func SetEncoding(name string) error {
+	serializer, err := scheme.SerializerByName(name)
+	if err != nil {
+		return err
+	}
+	codec.SetSerializer(serializer)
+	return nil
+}
+
+func newScheme() *scheme.Scheme {
+	s := scheme.New()
+
+	s.AddKind(corev1.KindNode, corev1.APIVersion)
+	s.AddKind(satrapv1.KindInbound, satrapv1.APIVersion)
+	s.AddKind(satrapv1.KindInboundUser, satrapv1.APIVersion)
+	s.AddKind(authv1.KindCredential, authv1.APIVersion)
+	s.AddKind(auditv1.KindEvent, auditv1.APIVersion)
+
+	// inbound configs are xray types with custom JSON (un)marshalers
+	s.PinSerializer(satrapv1.KindInbound, scheme.JSON)
+
+	// objects written before versioning have no apiVersion
+	s.AddConversion(corev1.KindNode, "", corev1.APIVersion, func(obj map[string]any) error { return nil })
+	s.AddConversion(satrapv1.KindInbound, "", satrapv1.APIVersion, expiresAtFromTTL)
+	s.AddConversion(satrapv1.KindInboundUser, "", satrapv1.APIVersion, expiresAtFromTTL)
+
+	return s
+}
+
+// expiresAtFromTTL fills in spec.expiresAt for objects created before it
+// existed, counting their ttl from the creation time.
+func expiresAtFromTTL(obj map[string]any) error {
+	spec, _ := obj["spec"].(map[string]any)
+	metadata, _ := obj["metadata"].(map[string]any)
+	if spec == nil || metadata == nil || spec["expiresAt"] != nil {
+		return nil
+	}
+
+	n, ok := spec["ttl"].(json.Number)
+	if !ok {
+		return nil
+	}
+	ttl, err := n.Int64()
+	if err != nil || ttl <= 0 {
+		return err
+	}
+
+	created, _ := metadata["creationTimestamp"].(string)
+	creationTimestamp, err := time.Parse(time.RFC3339Nano, created)
+	if err != nil {
+		return err
+	}
+
+	spec["expiresAt"] = creationTimestamp.Add(time.Duration(ttl)).Format(time.RFC3339Nano)
+	return nil
+}
@@ -0,0 +1,60 @@
+package resources
+
+import (
+	"context"
+
+	zlog "github.com/rs/zerolog/log"
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+	"github.com/vayzur/apadana/pkg/chapar/storage"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// watch turns raw storage events under prefix into API watch events. Values
+// that fail to decode are logged and skipped, the same way lists skip them.
+func watch(ctx context.Context, store storage.Interface, prefix string, fromRevision int64, decode func(*storage.KeyValue) (any, error)) (<-chan metav1.WatchEvent, error) {
+	events, err := store.Watch(ctx, prefix, fromRevision)
+	if err != nil {
+		return nil, errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"watch failed",
+			map[string]string{
+				"prefix": prefix,
+			},
+			err,
+		)
+	}
+
+	out := make(chan metav1.WatchEvent)
+
+	go func() {
+		defer close(out)
+
+		for ev := range events {
+			var event metav1.WatchEvent
+
+			if ev.Type == storage.EventError {
+				apiErr, ok := ev.Err.(*errs.Error)
+				if !ok {
+					apiErr = errs.New(errs.KindInternal, errs.ReasonUnknown, "watch failed", nil, ev.Err)
+				}
+				event = metav1.WatchEvent{Type: metav1.Error, Object: apiErr}
+			} else {
+				obj, err := decode(&ev.KeyValue)
+				if err != nil {
+					zlog.Error().Err(err).Str("component", "store").Str("key", ev.Key).Msg("unmarshal failed")
+					continue
+				}
+				event = metav1.WatchEvent{Type: metav1.WatchEventType(ev.Type), Object: obj}
+			}
+
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
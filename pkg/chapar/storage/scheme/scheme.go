@@ -0,0 +1,226 @@
+package scheme
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+)
+
+// Object is any persisted type; embedding metav1.TypeMeta satisfies it.
+type Object interface {
+	GetTypeMeta() *metav1.TypeMeta
+}
+
+// ConversionFunc upgrades a decoded object from one apiVersion to the next.
+// It works on the generic JSON form, so old versions need no Go types.
+type ConversionFunc func(obj map[string]any) error
+
+type conversion struct {
+	to string
+	fn ConversionFunc
+}
+
+type kindInfo struct {
+	version     string
+	conversions map[string]conversion
+	// serializer overrides the scheme serializer when set
+	serializer Serializer
+}
+
+// Scheme knows the storage version of every persisted kind and how to
+// convert older stored versions to it. Objects written before versioning
+// have no apiVersion and are registered as version "".
+//
+// Objects are written with the scheme serializer, JSON unless changed with
+// SetSerializer, and read with whichever serializer wrote them.
+type Scheme struct {
+	kinds      map[string]*kindInfo
+	serializer Serializer
+}
+
+func New() *Scheme {
+	return &Scheme{
+		kinds:      make(map[string]*kindInfo),
+		serializer: JSON,
+	}
+}
+
+// SetSerializer changes the serializer new objects are written with. It is
+// not safe to call while the scheme is in use.
+func (s *Scheme) SetSerializer(serializer Serializer) {
+	s.serializer = serializer
+}
+
+// PinSerializer makes kind always be written with serializer, for types that
+// only round-trip through it.
+func (s *Scheme) PinSerializer(kind string, serializer Serializer) {
+	s.kinds[kind].serializer = serializer
+}
+
+func (s *Scheme) serializerFor(k *kindInfo) Serializer {
+	if k.serializer != nil {
+		return k.serializer
+	}
+	return s.serializer
+}
+
+// AddKind registers kind with the apiVersion new objects are written as.
+func (s *Scheme) AddKind(kind, storageVersion string) {
+	s.kinds[kind] = &kindInfo{
+		version:     storageVersion,
+		conversions: make(map[string]conversion),
+	}
+}
+
+// AddConversion registers fn to convert kind from one apiVersion to another.
+// Decoding follows these conversions until it reaches the storage version.
+func (s *Scheme) AddConversion(kind, from, to string, fn ConversionFunc) {
+	s.kinds[kind].conversions[from] = conversion{to: to, fn: fn}
+}
+
+// Encode stamps obj with the storage version of kind and marshals it.
+func (s *Scheme) Encode(kind string, obj Object) ([]byte, error) {
+	k, ok := s.kinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown kind %q", kind)
+	}
+	return s.encode(s.serializerFor(k), k, kind, obj)
+}
+
+// EncodeJSON is Encode with JSON as serializer, for objects leaving storage.
+func (s *Scheme) EncodeJSON(kind string, obj Object) ([]byte, error) {
+	k, ok := s.kinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown kind %q", kind)
+	}
+	return s.encode(JSON, k, kind, obj)
+}
+
+func (s *Scheme) encode(serializer Serializer, k *kindInfo, kind string, obj Object) ([]byte, error) {
+	tm := obj.GetTypeMeta()
+	tm.APIVersion = k.version
+	tm.Kind = kind
+	return serializer.Marshal(obj)
+}
+
+// Decode unmarshals data into obj, converting it to the storage version
+// first when it was written by an older one. stale reports whether the
+// stored value is due for a rewrite, because it was converted or written
+// with a serializer other than the one kind is now written with.
+func (s *Scheme) Decode(kind string, data []byte, into Object) (stale bool, err error) {
+	k, ok := s.kinds[kind]
+	if !ok {
+		return false, fmt.Errorf("unknown kind %q", kind)
+	}
+
+	serializer, err := detect(data)
+	if err != nil {
+		return false, err
+	}
+	stale = serializer != s.serializerFor(k)
+
+	// decode straight into the current type and only look at the stored
+	// version afterwards, so the common case parses data once
+	decodeErr := serializer.Unmarshal(data, into)
+	tm := *into.GetTypeMeta()
+	if decodeErr != nil {
+		// older versions may not fit the current type
+		tm = metav1.TypeMeta{}
+		if err := serializer.Unmarshal(data, &tm); err != nil {
+			return false, err
+		}
+		if tm.APIVersion == k.version {
+			return false, decodeErr
+		}
+	}
+	if tm.Kind != "" && tm.Kind != kind {
+		return false, fmt.Errorf("stored kind is %q, expected %q", tm.Kind, kind)
+	}
+	if tm.APIVersion == k.version {
+		return stale, nil
+	}
+
+	data, err = s.convert(kind, k, tm.APIVersion, serializer, data)
+	if err != nil {
+		return false, err
+	}
+	reflect.ValueOf(into).Elem().SetZero()
+	if err := JSON.Unmarshal(data, into); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// convert returns data converted to the storage version of kind, as JSON.
+func (s *Scheme) convert(kind string, k *kindInfo, version string, serializer Serializer, data []byte) ([]byte, error) {
+	data, err := toJSON(serializer, data)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := map[string]any{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// keep durations and other large integers exact
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+
+	// a chain longer than the number of conversions means a cycle
+	for range len(k.conversions) {
+		if version == k.version {
+			break
+		}
+		c, ok := k.conversions[version]
+		if !ok {
+			break
+		}
+		if err := c.fn(obj); err != nil {
+			return nil, fmt.Errorf("convert %s from %q to %q: %w", kind, version, c.to, err)
+		}
+		version = c.to
+	}
+	if version != k.version {
+		return nil, fmt.Errorf("no conversion for %s from %q to %q", kind, version, k.version)
+	}
+
+	obj["apiVersion"] = k.version
+	obj["kind"] = kind
+	return json.Marshal(obj)
+}
+
+// toJSON re-encodes data as JSON so that conversions always see the same
+// generic form. Byte strings holding JSON, which is how raw JSON fields are
+// written in binary encodings, are embedded as JSON again.
+func toJSON(serializer Serializer, data []byte) ([]byte, error) {
+	if serializer == JSON {
+		return data, nil
+	}
+
+	var obj any
+	if err := serializer.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	return json.Marshal(embedRawJSON(obj))
+}
+
+func embedRawJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			v[key] = embedRawJSON(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = embedRawJSON(value)
+		}
+	case []byte:
+		if json.Valid(v) {
+			return json.RawMessage(v)
+		}
+	}
+	return v
+}
@@ -0,0 +1,116 @@
+package scheme
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Serializer turns objects into stored bytes and back. Every serializer
+// must be able to tell its own output apart from the others', so stored
+// objects can be read regardless of which one wrote them.
+type Serializer interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+	// Recognizes reports whether data was written by this serializer.
+	Recognizes(data []byte) bool
+}
+
+var (
+	JSON Serializer = jsonSerializer{}
+	CBOR Serializer = newCBORSerializer()
+
+	serializers = []Serializer{JSON, CBOR}
+)
+
+// SerializerByName returns the serializer called name. An empty name
+// selects JSON.
+func SerializerByName(name string) (Serializer, error) {
+	if name == "" {
+		return JSON, nil
+	}
+	for _, s := range serializers {
+		if s.Name() == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown encoding %q", name)
+}
+
+func detect(data []byte) (Serializer, error) {
+	for _, s := range serializers {
+		if s.Recognizes(data) {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unrecognized encoding")
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Name() string { return "json" }
+
+func (jsonSerializer) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonSerializer) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+func (jsonSerializer) Recognizes(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return len(data) > 0 && data[0] == '{'
+}
+
+// cborMagic is the CBOR self-described tag (RFC 8949, 3.4.6) every CBOR
+// value is prefixed with. It cannot start a JSON document.
+var cborMagic = []byte{0xd9, 0xd9, 0xf7}
+
+var typeOfGenericMap = reflect.TypeOf(map[string]any(nil))
+
+// cborSerializer writes CBOR using the json struct tags, so the same types
+// serve both encodings. Times are kept as RFC 3339 strings with nanoseconds,
+// since the default of unix seconds would lose precision.
+type cborSerializer struct {
+	enc cbor.EncMode
+	dec cbor.DecMode
+}
+
+func newCBORSerializer() *cborSerializer {
+	enc, err := cbor.EncOptions{
+		Time: cbor.TimeRFC3339Nano,
+		Sort: cbor.SortNone,
+	}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	dec, err := cbor.DecOptions{
+		DefaultMapType: typeOfGenericMap,
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return &cborSerializer{enc: enc, dec: dec}
+}
+
+func (*cborSerializer) Name() string { return "cbor" }
+
+func (s *cborSerializer) Marshal(v any) ([]byte, error) {
+	data, err := s.enc.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(cborMagic[:len(cborMagic):len(cborMagic)], data...), nil
+}
+
+func (s *cborSerializer) Unmarshal(data []byte, v any) error {
+	if !s.Recognizes(data) {
+		return fmt.Errorf("missing cbor prefix")
+	}
+	return s.dec.Unmarshal(data[len(cborMagic):], v)
+}
+
+func (*cborSerializer) Recognizes(data []byte) bool {
+	return bytes.HasPrefix(data, cborMagic)
+}
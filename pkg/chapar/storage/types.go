@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"strconv"
+
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// KeyValue is a raw value read from the backend together with the revision
+// it was last modified at.
+type KeyValue struct {
+	Key      string
+	Value    []byte
+	Revision int64
+}
+
+type EventType string
+
+const (
+	EventAdded    EventType = "ADDED"
+	EventModified EventType = "MODIFIED"
+	EventDeleted  EventType = "DELETED"
+	EventError    EventType = "ERROR"
+)
+
+// Event is a single change observed by Watch. For EventDeleted the value is
+// the one the key held before deletion and Revision is the deletion revision.
+type Event struct {
+	Type EventType
+	KeyValue
+	Err error
+}
+
+type OpType int
+
+const (
+	// OpCreate writes a key that must not exist yet.
+	OpCreate OpType = iota
+	// OpUpdate writes a key that must still be at ResourceVersion.
+	OpUpdate
+	// OpDelete removes a key, or every key under it when it ends with a
+	// slash. A non-zero ResourceVersion makes the delete conditional.
+	OpDelete
+	// OpPut writes a key whether or not it exists.
+	OpPut
+)
+
+type Op struct {
+	Type  OpType
+	Key   string
+	Value []byte
+	TTL   uint64
+	// Lease attaches the key to a lease from Interface.Grant instead of one
+	// for TTL, which is then ignored.
+	Lease           int64
+	ResourceVersion int64
+}
+
+func CreateOp(key string, obj []byte, ttl uint64) Op {
+	return Op{Type: OpCreate, Key: key, Value: obj, TTL: ttl}
+}
+
+func UpdateOp(key string, obj []byte, ttl uint64, resourceVersion int64) Op {
+	return Op{Type: OpUpdate, Key: key, Value: obj, TTL: ttl, ResourceVersion: resourceVersion}
+}
+
+func DeleteOp(key string) Op {
+	return Op{Type: OpDelete, Key: key}
+}
+
+func PutOp(key string, obj []byte, ttl uint64) Op {
+	return Op{Type: OpPut, Key: key, Value: obj, TTL: ttl}
+}
+
+func FormatResourceVersion(rev int64) string {
+	return strconv.FormatInt(rev, 10)
+}
+
+func ParseResourceVersion(resourceVersion string) (int64, error) {
+	rev, err := strconv.ParseInt(resourceVersion, 10, 64)
+	if err != nil || rev <= 0 {
+		return 0, errs.ErrInvalidResourceVersion
+	}
+	return rev, nil
+}
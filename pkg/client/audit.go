@@ -0,0 +1,87 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+	auditv1 "github.com/vayzur/apadana/pkg/apis/audit/v1"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// ListAuditEvents lists the stored audit events that match opts, oldest
+// first.
+func (c *Client) ListAuditEvents(opts auditv1.ListOptions) (*auditv1.EventList, error) {
+	url := withListOptions(fmt.Sprintf("%s/api/v1/audit/events", c.address), opts.ListOptions)
+
+	query := neturl.Values{}
+	if opts.NodeName != "" {
+		query.Set("nodeName", opts.NodeName)
+	}
+	if opts.Resource != "" {
+		query.Set("resource", opts.Resource)
+	}
+	if opts.Since != nil {
+		query.Set("since", opts.Since.Format(time.RFC3339Nano))
+	}
+	if opts.Until != nil {
+		query.Set("until", opts.Until.Format(time.RFC3339Nano))
+	}
+	if len(query) > 0 {
+		sep := "?"
+		if strings.Contains(url, "?") {
+			sep = "&"
+		}
+		url += sep + query.Encode()
+	}
+
+	status, resp, err := c.httpClient.Do(http.MethodGet, url, c.token, nil)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "client").Str("resource", "auditEvents").Str("action", "list").Msg("failed")
+		return nil, err
+	}
+
+	if status == http.StatusOK {
+		events := &auditv1.EventList{}
+		if err := json.Unmarshal(resp, events); err != nil {
+			zlog.Error().Err(err).Str("component", "apadana").Str("resource", "auditEvents").Str("action", "list").Int("status", status).Str("resp", string(resp)).Msg("unmarshal failed")
+			return nil, errs.New(
+				errs.KindInternal,
+				errs.ReasonUnmarshalFailed,
+				"audit events unmarshal failed",
+				map[string]string{
+					"status": strconv.Itoa(status),
+					"resp":   string(resp),
+				},
+				nil,
+			)
+		}
+		return events, nil
+	}
+
+	zlog.Error().Str("component", "apadana").Str("resource", "auditEvents").Str("action", "list").Int("status", status).Str("resp", string(resp)).Msg("failed")
+
+	switch status {
+	case http.StatusGone:
+		return nil, errs.ErrResourceExpired
+	case http.StatusNotFound, http.StatusBadRequest:
+		if e := apiError(resp); e != nil {
+			return nil, e
+		}
+	}
+	return nil, errs.New(
+		errs.KindInternal,
+		errs.ReasonUnknown,
+		"list audit events failed",
+		map[string]string{
+			"status": strconv.Itoa(status),
+			"resp":   string(resp),
+		},
+		nil,
+	)
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	zlog "github.com/rs/zerolog/log"
+	satrapv1 "github.com/vayzur/apadana/pkg/apis/satrap/v1"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// BatchInboundUsers applies items to the users of one inbound and returns one
+// result per item, in order. The node and inbound of the items are ignored.
+// Batches larger than satrapv1.MaxBatchItems are sent in several requests.
+func (c *Client) BatchInboundUsers(nodeName, tag string, items []*satrapv1.InboundUserBatchOp) ([]*satrapv1.InboundUserBatchResult, error) {
+	if nodeName == "" {
+		return nil, errs.ErrInvalidNode
+	}
+	if tag == "" {
+		return nil, errs.ErrInvalidInbound
+	}
+	url := fmt.Sprintf("%s/api/v1/nodes/%s/inbounds/%s/users:batch", c.address, nodeName, tag)
+	return c.batchUsers(url, items)
+}
+
+// BatchUsers applies items, each naming its own node and inbound, and
+// returns one result per item, in order. Batches larger than
+// satrapv1.MaxBatchItems are sent in several requests.
+func (c *Client) BatchUsers(items []*satrapv1.InboundUserBatchOp) ([]*satrapv1.InboundUserBatchResult, error) {
+	url := fmt.Sprintf("%s/api/v1/users:batch", c.address)
+	return c.batchUsers(url, items)
+}
+
+func (c *Client) batchUsers(url string, items []*satrapv1.InboundUserBatchOp) ([]*satrapv1.InboundUserBatchResult, error) {
+	results := make([]*satrapv1.InboundUserBatchResult, 0, len(items))
+
+	for start := 0; start < len(items); start += satrapv1.MaxBatchItems {
+		end := min(start+satrapv1.MaxBatchItems, len(items))
+		list, err := c.postBatch(url, &satrapv1.InboundUserBatch{Items: items[start:end]})
+		if err != nil {
+			return results, err
+		}
+		results = append(results, list.Items...)
+	}
+
+	return results, nil
+}
+
+func (c *Client) postBatch(url string, batch *satrapv1.InboundUserBatch) (*satrapv1.InboundUserBatchResultList, error) {
+	status, resp, err := c.httpClient.Do(http.MethodPost, url, c.token, batch)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "client").Str("resource", "inboundUser").Str("action", "batch").Int("items", len(batch.Items)).Msg("failed")
+		return nil, err
+	}
+
+	if status == http.StatusOK {
+		list := &satrapv1.InboundUserBatchResultList{}
+		if err := json.Unmarshal(resp, list); err != nil {
+			zlog.Error().Err(err).Str("component", "apadana").Str("resource", "inboundUser").Str("action", "batch").Int("status", status).Str("resp", string(resp)).Msg("unmarshal failed")
+			return nil, errs.New(
+				errs.KindInternal,
+				errs.ReasonUnmarshalFailed,
+				"batch results unmarshal failed",
+				map[string]string{
+					"status": strconv.Itoa(status),
+					"resp":   string(resp),
+				},
+				nil,
+			)
+		}
+		return list, nil
+	}
+
+	zlog.Error().Str("component", "apadana").Str("resource", "inboundUser").Str("action", "batch").Int("status", status).Str("resp", string(resp)).Msg("failed")
+
+	return nil, errs.New(
+		errs.KindInternal,
+		errs.ReasonUnknown,
+		"batch users failed",
+		map[string]string{
+			"status": strconv.Itoa(status),
+			"resp":   string(resp),
+		},
+		nil,
+	)
+}
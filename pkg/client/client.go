@@ -1,8 +1,13 @@
 package client
 
 import (
+	"encoding/json"
+	neturl "net/url"
+	"strconv"
 	"time"
 
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+	"github.com/vayzur/apadana/pkg/errs"
 	"github.com/vayzur/apadana/pkg/httputil"
 )
 
@@ -12,11 +17,68 @@ type Client struct {
 	token      string
 }
 
-func New(address, token string, timeout time.Duration) *Client {
+type Option func(*Client)
+
+// WithCredential signs requests as the named credential, whose token is the
+// one passed to New.
+func WithCredential(name string) Option {
+	return func(c *Client) {
+		c.httpClient.SetCredential(name)
+	}
+}
+
+func New(address, token string, timeout time.Duration, opts ...Option) *Client {
 	httpClient := httputil.New(timeout)
-	return &Client{
+	c := &Client{
 		httpClient: httpClient,
 		address:    address,
 		token:      token,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SetCredential switches the client to signing requests as the named
+// credential with token. It must not be called while requests are in flight.
+func (c *Client) SetCredential(name, token string) {
+	c.httpClient.SetCredential(name)
+	c.token = token
+}
+
+func withResourceVersion(url, resourceVersion string) string {
+	if resourceVersion == "" {
+		return url
+	}
+	return url + "?resourceVersion=" + resourceVersion
+}
+
+func withListOptions(url string, opts metav1.ListOptions) string {
+	query := neturl.Values{}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.FormatInt(opts.Limit, 10))
+	}
+	if opts.Continue != "" {
+		query.Set("continue", opts.Continue)
+	}
+	if opts.LabelSelector != "" {
+		query.Set("labelSelector", opts.LabelSelector)
+	}
+	if len(query) == 0 {
+		return url
+	}
+	return url + "?" + query.Encode()
+}
+
+// apiError decodes the error chapar answered with, or returns nil when resp
+// does not hold one.
+func apiError(resp []byte) *errs.Error {
+	body := struct {
+		Error *errs.Error `json:"error"`
+	}{}
+	if err := json.Unmarshal(resp, &body); err != nil || body.Error == nil || body.Error.Kind == "" {
+		return nil
+	}
+	return body.Error
 }
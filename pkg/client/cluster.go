@@ -0,0 +1,157 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+
+	zlog "github.com/rs/zerolog/log"
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+	satrapv1 "github.com/vayzur/apadana/pkg/apis/satrap/v1"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// GetClusterInbounds returns the inbounds of every node matching
+// labelSelector, fetching them page by page.
+func (c *Client) GetClusterInbounds(labelSelector string) ([]*satrapv1.ClusterInbound, error) {
+	return collect(c.ClusterInbounds(metav1.ListOptions{LabelSelector: labelSelector}))
+}
+
+// ClusterInbounds iterates over the inbounds of every node, requesting the
+// next page as the previous one is consumed.
+func (c *Client) ClusterInbounds(opts metav1.ListOptions) iter.Seq2[*satrapv1.ClusterInbound, error] {
+	return paginate(opts, func(opts metav1.ListOptions) ([]*satrapv1.ClusterInbound, metav1.ListMeta, error) {
+		list, err := c.ListClusterInbounds(opts)
+		if err != nil {
+			return nil, metav1.ListMeta{}, err
+		}
+		return list.Items, list.Metadata, nil
+	})
+}
+
+func (c *Client) ListClusterInbounds(opts metav1.ListOptions) (*satrapv1.ClusterInboundList, error) {
+	url := withListOptions(fmt.Sprintf("%s/api/v1/inbounds", c.address), opts)
+	status, resp, err := c.httpClient.Do(http.MethodGet, url, c.token, nil)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "client").Str("resource", "inbounds").Str("action", "list").Msg("failed")
+		return nil, err
+	}
+
+	if status == http.StatusOK {
+		inbounds := &satrapv1.ClusterInboundList{}
+		if err := json.Unmarshal(resp, inbounds); err != nil {
+			zlog.Error().Err(err).Str("component", "apadana").Str("resource", "inbounds").Str("action", "list").Int("status", status).Str("resp", string(resp)).Msg("unmarshal failed")
+			return nil, errs.New(
+				errs.KindInternal,
+				errs.ReasonUnmarshalFailed,
+				"cluster inbounds unmarshal failed",
+				map[string]string{
+					"status": strconv.Itoa(status),
+					"resp":   string(resp),
+				},
+				nil,
+			)
+		}
+		return inbounds, nil
+	}
+
+	if status == http.StatusGone {
+		return nil, errs.ErrResourceExpired
+	}
+
+	zlog.Error().Str("component", "apadana").Str("resource", "inbounds").Str("action", "list").Int("status", status).Str("resp", string(resp)).Msg("failed")
+
+	return nil, errs.New(
+		errs.KindInternal,
+		errs.ReasonUnknown,
+		"get cluster inbounds failed",
+		map[string]string{
+			"status": strconv.Itoa(status),
+			"resp":   string(resp),
+		},
+		nil,
+	)
+}
+
+// ClusterUsers iterates over the users of every inbound on every node,
+// requesting the next page as the previous one is consumed.
+func (c *Client) ClusterUsers(opts metav1.ListOptions) iter.Seq2[*satrapv1.ClusterInboundUser, error] {
+	return paginate(opts, func(opts metav1.ListOptions) ([]*satrapv1.ClusterInboundUser, metav1.ListMeta, error) {
+		list, err := c.ListClusterUsers("", opts)
+		if err != nil {
+			return nil, metav1.ListMeta{}, err
+		}
+		return list.Items, list.Metadata, nil
+	})
+}
+
+// GetUsersByEmail returns the user with email on every inbound of every node
+// it exists on.
+func (c *Client) GetUsersByEmail(email string) ([]*satrapv1.ClusterInboundUser, error) {
+	if email == "" {
+		return nil, errs.ErrInvalidUser
+	}
+	list, err := c.ListClusterUsers(email, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// ListClusterUsers lists users across nodes. With an email the result is the
+// users with that email, in a single page.
+func (c *Client) ListClusterUsers(email string, opts metav1.ListOptions) (*satrapv1.ClusterInboundUserList, error) {
+	url := withListOptions(fmt.Sprintf("%s/api/v1/users", c.address), opts)
+	if email != "" {
+		sep := "?"
+		if opts.Limit > 0 || opts.Continue != "" || opts.LabelSelector != "" {
+			sep = "&"
+		}
+		url += sep + "email=" + neturl.QueryEscape(email)
+	}
+	status, resp, err := c.httpClient.Do(http.MethodGet, url, c.token, nil)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "client").Str("resource", "inboundUsers").Str("action", "list").Str("email", email).Msg("failed")
+		return nil, err
+	}
+
+	if status == http.StatusOK {
+		users := &satrapv1.ClusterInboundUserList{}
+		if err := json.Unmarshal(resp, users); err != nil {
+			zlog.Error().Err(err).Str("component", "apadana").Str("resource", "inboundUsers").Str("action", "list").Str("email", email).Int("status", status).Str("resp", string(resp)).Msg("unmarshal failed")
+			return nil, errs.New(
+				errs.KindInternal,
+				errs.ReasonUnmarshalFailed,
+				"cluster users unmarshal failed",
+				map[string]string{
+					"email":  email,
+					"status": strconv.Itoa(status),
+					"resp":   string(resp),
+				},
+				nil,
+			)
+		}
+		return users, nil
+	}
+
+	if status == http.StatusGone {
+		return nil, errs.ErrResourceExpired
+	}
+
+	zlog.Error().Str("component", "apadana").Str("resource", "inboundUsers").Str("action", "list").Str("email", email).Int("status", status).Str("resp", string(resp)).Msg("failed")
+
+	return nil, errs.New(
+		errs.KindInternal,
+		errs.ReasonUnknown,
+		"get cluster users failed",
+		map[string]string{
+			"email":  email,
+			"status": strconv.Itoa(status),
+			"resp":   string(resp),
+		},
+		nil,
+	)
+}
@@ -0,0 +1,275 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	zlog "github.com/rs/zerolog/log"
+	authv1 "github.com/vayzur/apadana/pkg/apis/auth/v1"
+	corev1 "github.com/vayzur/apadana/pkg/apis/core/v1"
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+// CreateCredential creates credential and returns it with the token chapar
+// generated for it. The token is not returned again.
+func (c *Client) CreateCredential(credential *authv1.Credential) (*authv1.Credential, error) {
+	url := fmt.Sprintf("%s/api/v1/credentials", c.address)
+	status, resp, err := c.httpClient.Do(http.MethodPost, url, c.token, credential)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "client").Str("resource", "credential").Str("action", "create").Msg("failed")
+		return nil, err
+	}
+
+	if status == http.StatusCreated {
+		created := &authv1.Credential{}
+		if err := json.Unmarshal(resp, created); err != nil {
+			zlog.Error().Err(err).Str("component", "apadana").Str("resource", "credential").Str("action", "create").Int("status", status).Msg("unmarshal failed")
+			return nil, errs.New(
+				errs.KindInternal,
+				errs.ReasonUnmarshalFailed,
+				"credential unmarshal failed",
+				map[string]string{
+					"status": strconv.Itoa(status),
+				},
+				nil,
+			)
+		}
+		return created, nil
+	}
+
+	zlog.Error().Str("component", "apadana").Str("resource", "credential").Str("action", "create").Int("status", status).Str("resp", string(resp)).Msg("failed")
+
+	switch status {
+	case http.StatusConflict:
+		return nil, errs.ErrCredentialConflict
+	case http.StatusBadRequest:
+		if e := apiError(resp); e != nil {
+			return nil, e
+		}
+	}
+	return nil, errs.New(
+		errs.KindInternal,
+		errs.ReasonUnknown,
+		"create credential failed",
+		map[string]string{
+			"status": strconv.Itoa(status),
+			"resp":   string(resp),
+		},
+		nil,
+	)
+}
+
+func (c *Client) GetCredential(name string) (*authv1.Credential, error) {
+	if name == "" {
+		return nil, errs.ErrInvalidCredential
+	}
+	url := fmt.Sprintf("%s/api/v1/credentials/%s", c.address, name)
+	status, resp, err := c.httpClient.Do(http.MethodGet, url, c.token, nil)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "client").Str("resource", "credential").Str("action", "get").Str("name", name).Msg("failed")
+		return nil, err
+	}
+
+	if status == http.StatusOK {
+		credential := &authv1.Credential{}
+		if err := json.Unmarshal(resp, credential); err != nil {
+			zlog.Error().Err(err).Str("component", "apadana").Str("resource", "credential").Str("action", "get").Str("name", name).Int("status", status).Str("resp", string(resp)).Msg("unmarshal failed")
+			return nil, errs.New(
+				errs.KindInternal,
+				errs.ReasonUnmarshalFailed,
+				"credential unmarshal failed",
+				map[string]string{
+					"name":   name,
+					"status": strconv.Itoa(status),
+					"resp":   string(resp),
+				},
+				nil,
+			)
+		}
+		return credential, nil
+	}
+
+	zlog.Error().Str("component", "apadana").Str("resource", "credential").Str("action", "get").Str("name", name).Int("status", status).Str("resp", string(resp)).Msg("failed")
+
+	switch status {
+	case http.StatusNotFound:
+		return nil, errs.ErrCredentialNotFound
+	default:
+		return nil, errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"get credential failed",
+			map[string]string{
+				"name":   name,
+				"status": strconv.Itoa(status),
+				"resp":   string(resp),
+			},
+			nil,
+		)
+	}
+}
+
+func (c *Client) ListCredentials(opts metav1.ListOptions) (*authv1.CredentialList, error) {
+	url := withListOptions(fmt.Sprintf("%s/api/v1/credentials", c.address), opts)
+	status, resp, err := c.httpClient.Do(http.MethodGet, url, c.token, nil)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "client").Str("resource", "credentials").Str("action", "list").Msg("failed")
+		return nil, err
+	}
+
+	if status == http.StatusOK {
+		credentials := &authv1.CredentialList{}
+		if err := json.Unmarshal(resp, credentials); err != nil {
+			zlog.Error().Err(err).Str("component", "apadana").Str("resource", "credentials").Str("action", "list").Int("status", status).Str("resp", string(resp)).Msg("unmarshal failed")
+			return nil, errs.New(
+				errs.KindInternal,
+				errs.ReasonUnmarshalFailed,
+				"credentials unmarshal failed",
+				map[string]string{
+					"status": strconv.Itoa(status),
+					"resp":   string(resp),
+				},
+				nil,
+			)
+		}
+		return credentials, nil
+	}
+
+	if status == http.StatusGone {
+		return nil, errs.ErrResourceExpired
+	}
+
+	zlog.Error().Str("component", "apadana").Str("resource", "credentials").Str("action", "list").Int("status", status).Str("resp", string(resp)).Msg("failed")
+
+	return nil, errs.New(
+		errs.KindInternal,
+		errs.ReasonUnknown,
+		"list credentials failed",
+		map[string]string{
+			"status": strconv.Itoa(status),
+			"resp":   string(resp),
+		},
+		nil,
+	)
+}
+
+func (c *Client) DeleteCredential(name string) error {
+	if name == "" {
+		return errs.ErrInvalidCredential
+	}
+	url := fmt.Sprintf("%s/api/v1/credentials/%s", c.address, name)
+	status, resp, err := c.httpClient.Do(http.MethodDelete, url, c.token, nil)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "client").Str("resource", "credential").Str("action", "delete").Str("name", name).Msg("failed")
+		return err
+	}
+
+	if status == http.StatusNoContent {
+		return nil
+	}
+
+	zlog.Error().Str("component", "apadana").Str("resource", "credential").Str("action", "delete").Str("name", name).Int("status", status).Str("resp", string(resp)).Msg("failed")
+
+	switch status {
+	case http.StatusNotFound:
+		return errs.ErrCredentialNotFound
+	default:
+		return errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"delete credential failed",
+			map[string]string{
+				"name":   name,
+				"status": strconv.Itoa(status),
+				"resp":   string(resp),
+			},
+			nil,
+		)
+	}
+}
+
+// JoinNode registers node with the bootstrap token the client signs with and
+// returns the credential the node is issued in exchange. The bootstrap token
+// cannot be used again.
+func (c *Client) JoinNode(node *corev1.Node) (*authv1.NodeJoin, error) {
+	url := fmt.Sprintf("%s/api/v1/nodes", c.address)
+	status, resp, err := c.httpClient.Do(http.MethodPost, url, c.token, node)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "client").Str("resource", "node").Str("action", "join").Msg("failed")
+		return nil, err
+	}
+
+	if status == http.StatusCreated {
+		join := &authv1.NodeJoin{}
+		if err := json.Unmarshal(resp, join); err != nil || join.Credential == nil {
+			zlog.Error().Err(err).Str("component", "apadana").Str("resource", "node").Str("action", "join").Int("status", status).Msg("unmarshal failed")
+			return nil, errs.New(
+				errs.KindInternal,
+				errs.ReasonUnmarshalFailed,
+				"node join unmarshal failed",
+				map[string]string{
+					"status": strconv.Itoa(status),
+				},
+				err,
+			)
+		}
+		return join, nil
+	}
+
+	zlog.Error().Str("component", "apadana").Str("resource", "node").Str("action", "join").Int("status", status).Str("resp", string(resp)).Msg("failed")
+
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, errs.ErrBootstrapTokenRejected
+	case http.StatusConflict:
+		return nil, errs.ErrNodeConflict
+	case http.StatusBadRequest:
+		if e := apiError(resp); e != nil {
+			return nil, e
+		}
+	}
+	return nil, errs.New(
+		errs.KindInternal,
+		errs.ReasonUnknown,
+		"join node failed",
+		map[string]string{
+			"status": strconv.Itoa(status),
+			"resp":   string(resp),
+		},
+		nil,
+	)
+}
+
+// RevokeNodeCredentials deletes every credential issued to the node.
+func (c *Client) RevokeNodeCredentials(nodeName string) error {
+	if nodeName == "" {
+		return errs.ErrInvalidNode
+	}
+	url := fmt.Sprintf("%s/api/v1/nodes/%s/credentials", c.address, nodeName)
+	status, resp, err := c.httpClient.Do(http.MethodDelete, url, c.token, nil)
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "client").Str("resource", "credential").Str("action", "revoke").Str("nodeName", nodeName).Msg("failed")
+		return err
+	}
+
+	if status == http.StatusNoContent {
+		return nil
+	}
+
+	zlog.Error().Str("component", "apadana").Str("resource", "credential").Str("action", "revoke").Str("nodeName", nodeName).Int("status", status).Str("resp", string(resp)).Msg("failed")
+
+	return errs.New(
+		errs.KindInternal,
+		errs.ReasonUnknown,
+		"revoke node credentials failed",
+		map[string]string{
+			"nodeName": nodeName,
+			"status":   strconv.Itoa(status),
+			"resp":     string(resp),
+		},
+		nil,
+	)
+}
@@ -3,8 +3,10 @@ package client
 import (
 	"encoding/json"
 	"fmt"
+	"iter"
 	"net/http"
 	"strconv"
+	"time"
 
 	zlog "github.com/rs/zerolog/log"
 	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
@@ -29,10 +31,12 @@ func (c *Client) CreateInbound(nodeName string, inbound *satrapv1.Inbound) error
 
 	zlog.Error().Str("component", "apadana").Str("resource", "inbound").Str("action", "create").Str("nodeName", nodeName).Int("status", status).Str("resp", string(resp)).Msg("failed")
 
-	switch status {
-	case http.StatusConflict:
+	switch e := apiError(resp); {
+	case e != nil && (status == http.StatusBadRequest || e.Reason == errs.ReasonPortConflict || e.Reason == errs.ReasonPortsExhausted):
+		return e
+	case status == http.StatusConflict:
 		return errs.ErrInboundConflict
-	case http.StatusTooManyRequests:
+	case status == http.StatusTooManyRequests:
 		return errs.ErrNodeCapacityExceeded
 	default:
 		return errs.New(
@@ -188,11 +192,29 @@ func (c *Client) CountInbounds(nodeName string) (*satrapv1.Count, error) {
 	)
 }
 
-func (c *Client) GetInbounds(nodeName string) ([]*satrapv1.Inbound, error) {
+// GetInbounds returns every inbound of the node matching labelSelector,
+// fetching them page by page. An empty selector returns all of them.
+func (c *Client) GetInbounds(nodeName, labelSelector string) ([]*satrapv1.Inbound, error) {
+	return collect(c.Inbounds(nodeName, metav1.ListOptions{LabelSelector: labelSelector}))
+}
+
+// Inbounds iterates over the inbounds of the node, requesting the next page
+// as the previous one is consumed.
+func (c *Client) Inbounds(nodeName string, opts metav1.ListOptions) iter.Seq2[*satrapv1.Inbound, error] {
+	return paginate(opts, func(opts metav1.ListOptions) ([]*satrapv1.Inbound, metav1.ListMeta, error) {
+		list, err := c.ListInbounds(nodeName, opts)
+		if err != nil {
+			return nil, metav1.ListMeta{}, err
+		}
+		return list.Items, list.Metadata, nil
+	})
+}
+
+func (c *Client) ListInbounds(nodeName string, opts metav1.ListOptions) (*satrapv1.InboundList, error) {
 	if nodeName == "" {
 		return nil, errs.ErrInvalidNode
 	}
-	url := fmt.Sprintf("%s/api/v1/nodes/%s/inbounds", c.address, nodeName)
+	url := withListOptions(fmt.Sprintf("%s/api/v1/nodes/%s/inbounds", c.address, nodeName), opts)
 	status, resp, err := c.httpClient.Do(http.MethodGet, url, c.token, nil)
 	if err != nil {
 		zlog.Error().Err(err).Str("component", "client").Str("resource", "inbounds").Str("action", "list").Str("nodeName", nodeName).Msg("failed")
@@ -200,8 +222,8 @@ func (c *Client) GetInbounds(nodeName string) ([]*satrapv1.Inbound, error) {
 	}
 
 	if status == http.StatusOK {
-		inbounds := []*satrapv1.Inbound{}
-		if err := json.Unmarshal(resp, &inbounds); err != nil {
+		inbounds := &satrapv1.InboundList{}
+		if err := json.Unmarshal(resp, inbounds); err != nil {
 			zlog.Error().Err(err).Str("component", "apadana").Str("resource", "inbounds").Str("action", "list").Str("nodeName", nodeName).Int("status", status).Str("resp", string(resp)).Msg("unmarshal failed")
 			return nil, errs.New(
 				errs.KindInternal,
@@ -218,6 +240,10 @@ func (c *Client) GetInbounds(nodeName string) ([]*satrapv1.Inbound, error) {
 		return inbounds, nil
 	}
 
+	if status == http.StatusGone {
+		return nil, errs.ErrResourceExpired
+	}
+
 	zlog.Error().Err(err).Str("component", "apadana").Str("resource", "inbounds").Str("action", "list").Str("nodeName", nodeName).Int("status", status).Str("resp", string(resp)).Msg("failed")
 
 	return nil, errs.New(
@@ -256,6 +282,8 @@ func (c *Client) UpdateInboundMetadata(nodeName, tag string, newMetadata *metav1
 	switch status {
 	case http.StatusNotFound:
 		return errs.ErrInboundNotFound
+	case http.StatusConflict:
+		return errs.ErrResourceVersionConflict
 	default:
 		return errs.New(
 			errs.KindInternal,
@@ -272,14 +300,14 @@ func (c *Client) UpdateInboundMetadata(nodeName, tag string, newMetadata *metav1
 	}
 }
 
-func (c *Client) UpdateInboundSpec(nodeName, tag string, newSpec *satrapv1.InboundSpec) error {
+func (c *Client) UpdateInboundSpec(nodeName, tag string, newSpec *satrapv1.InboundSpec, resourceVersion string) error {
 	if nodeName == "" {
 		return errs.ErrInvalidNode
 	}
 	if tag == "" {
 		return errs.ErrInvalidInbound
 	}
-	url := fmt.Sprintf("%s/api/v1/nodes/%s/inbounds/%s/spec", c.address, nodeName, tag)
+	url := withResourceVersion(fmt.Sprintf("%s/api/v1/nodes/%s/inbounds/%s/spec", c.address, nodeName, tag), resourceVersion)
 	status, resp, err := c.httpClient.Do(http.MethodPatch, url, c.token, newSpec)
 	if err != nil {
 		zlog.Error().Err(err).Str("component", "client").Str("resource", "inbound").Str("action", "update").Str("nodeName", nodeName).Str("tag", tag).Msg("failed")
@@ -295,6 +323,8 @@ func (c *Client) UpdateInboundSpec(nodeName, tag string, newSpec *satrapv1.Inbou
 	switch status {
 	case http.StatusNotFound:
 		return errs.ErrInboundNotFound
+	case http.StatusConflict:
+		return errs.ErrResourceVersionConflict
 	default:
 		return errs.New(
 			errs.KindInternal,
@@ -311,14 +341,32 @@ func (c *Client) UpdateInboundSpec(nodeName, tag string, newSpec *satrapv1.Inbou
 	}
 }
 
-func (c *Client) GetInboundUsers(nodeName, tag string) ([]*satrapv1.InboundUser, error) {
+// GetInboundUsers returns every user of the inbound matching labelSelector,
+// fetching them page by page. An empty selector returns all of them.
+func (c *Client) GetInboundUsers(nodeName, tag, labelSelector string) ([]*satrapv1.InboundUser, error) {
+	return collect(c.InboundUsers(nodeName, tag, metav1.ListOptions{LabelSelector: labelSelector}))
+}
+
+// InboundUsers iterates over the users of the inbound, requesting the next
+// page as the previous one is consumed.
+func (c *Client) InboundUsers(nodeName, tag string, opts metav1.ListOptions) iter.Seq2[*satrapv1.InboundUser, error] {
+	return paginate(opts, func(opts metav1.ListOptions) ([]*satrapv1.InboundUser, metav1.ListMeta, error) {
+		list, err := c.ListInboundUsers(nodeName, tag, opts)
+		if err != nil {
+			return nil, metav1.ListMeta{}, err
+		}
+		return list.Items, list.Metadata, nil
+	})
+}
+
+func (c *Client) ListInboundUsers(nodeName, tag string, opts metav1.ListOptions) (*satrapv1.InboundUserList, error) {
 	if nodeName == "" {
 		return nil, errs.ErrInvalidNode
 	}
 	if tag == "" {
 		return nil, errs.ErrInvalidInbound
 	}
-	url := fmt.Sprintf("%s/api/v1/nodes/%s/inbounds/%s/users", c.address, nodeName, tag)
+	url := withListOptions(fmt.Sprintf("%s/api/v1/nodes/%s/inbounds/%s/users", c.address, nodeName, tag), opts)
 	status, resp, err := c.httpClient.Do(http.MethodGet, url, c.token, nil)
 	if err != nil {
 		zlog.Error().Err(err).Str("component", "client").Str("resource", "inboundUsers").Str("action", "list").Str("nodeName", nodeName).Str("tag", tag).Msg("failed")
@@ -326,8 +374,8 @@ func (c *Client) GetInboundUsers(nodeName, tag string) ([]*satrapv1.InboundUser,
 	}
 
 	if status == http.StatusOK {
-		inboundUsers := []*satrapv1.InboundUser{}
-		if err := json.Unmarshal(resp, &inboundUsers); err != nil {
+		inboundUsers := &satrapv1.InboundUserList{}
+		if err := json.Unmarshal(resp, inboundUsers); err != nil {
 			zlog.Error().Err(err).Str("component", "apadana").Str("resource", "inboundUsers").Str("action", "list").Str("nodeName", nodeName).Str("tag", tag).Int("status", status).Str("resp", string(resp)).Msg("unmarshal failed")
 			return nil, errs.New(
 				errs.KindInternal,
@@ -345,6 +393,10 @@ func (c *Client) GetInboundUsers(nodeName, tag string) ([]*satrapv1.InboundUser,
 		return inboundUsers, nil
 	}
 
+	if status == http.StatusGone {
+		return nil, errs.ErrResourceExpired
+	}
+
 	zlog.Error().Str("component", "apadana").Str("resource", "inboundUsers").Str("action", "list").Str("nodeName", nodeName).Str("tag", tag).Int("status", status).Str("resp", string(resp)).Msg("failed")
 
 	return nil, errs.New(
@@ -381,6 +433,10 @@ func (c *Client) CreateInboundUser(nodeName, tag string, user *satrapv1.InboundU
 
 	zlog.Error().Err(err).Str("component", "apadana").Str("resource", "inboundUsers").Str("action", "create").Str("nodeName", nodeName).Str("tag", tag).Int("status", status).Str("resp", string(resp)).Msg("failed")
 
+	if e := apiError(resp); status == http.StatusBadRequest && e != nil {
+		return e
+	}
+
 	switch status {
 	case http.StatusConflict:
 		return errs.ErrUserConflict
@@ -472,6 +528,8 @@ func (c *Client) UpdateInboundUserMetadata(nodeName, tag, email string, newMetad
 	switch status {
 	case http.StatusNotFound:
 		return errs.ErrUserNotFound
+	case http.StatusConflict:
+		return errs.ErrResourceVersionConflict
 	default:
 		return errs.New(
 			errs.KindInternal,
@@ -489,7 +547,7 @@ func (c *Client) UpdateInboundUserMetadata(nodeName, tag, email string, newMetad
 	}
 }
 
-func (c *Client) UpdateInboundUserSpec(nodeName, tag, email string, newSpec *satrapv1.InboundUserSpec) error {
+func (c *Client) UpdateInboundUserSpec(nodeName, tag, email string, newSpec *satrapv1.InboundUserSpec, resourceVersion string) error {
 	if nodeName == "" {
 		return errs.ErrInvalidNode
 	}
@@ -499,7 +557,7 @@ func (c *Client) UpdateInboundUserSpec(nodeName, tag, email string, newSpec *sat
 	if email == "" {
 		return errs.ErrInvalidUser
 	}
-	url := fmt.Sprintf("%s/api/v1/nodes/%s/inbounds/%s/users/%s/spec", c.address, nodeName, tag, email)
+	url := withResourceVersion(fmt.Sprintf("%s/api/v1/nodes/%s/inbounds/%s/users/%s/spec", c.address, nodeName, tag, email), resourceVersion)
 	status, resp, err := c.httpClient.Do(http.MethodPatch, url, c.token, newSpec)
 	if err != nil {
 		zlog.Error().Err(err).Str("component", "client").Str("resource", "inboundUser").Str("action", "update").Str("nodeName", nodeName).Str("tag", tag).Str("email", email).Msg("failed")
@@ -515,6 +573,8 @@ func (c *Client) UpdateInboundUserSpec(nodeName, tag, email string, newSpec *sat
 	switch status {
 	case http.StatusNotFound:
 		return errs.ErrUserNotFound
+	case http.StatusConflict:
+		return errs.ErrResourceVersionConflict
 	default:
 		return errs.New(
 			errs.KindInternal,
@@ -574,3 +634,124 @@ func (c *Client) CountInboundUsers(nodeName, tag string) (*satrapv1.Count, error
 		)
 	}
 }
+
+func (c *Client) RenewInbound(nodeName, tag string, ttl time.Duration, resourceVersion string) (*satrapv1.Inbound, error) {
+	if nodeName == "" {
+		return nil, errs.ErrInvalidNode
+	}
+	if tag == "" {
+		return nil, errs.ErrInvalidInbound
+	}
+	url := withResourceVersion(fmt.Sprintf("%s/api/v1/nodes/%s/inbounds/%s/renew", c.address, nodeName, tag), resourceVersion)
+	status, resp, err := c.httpClient.Do(http.MethodPost, url, c.token, &satrapv1.RenewRequest{TTL: ttl})
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "client").Str("resource", "inbound").Str("action", "renew").Str("nodeName", nodeName).Str("tag", tag).Msg("failed")
+		return nil, err
+	}
+
+	if status == http.StatusOK {
+		inbound := &satrapv1.Inbound{}
+		if err := json.Unmarshal(resp, inbound); err != nil {
+			return nil, errs.New(
+				errs.KindInternal,
+				errs.ReasonUnmarshalFailed,
+				"inbound unmarshal failed",
+				map[string]string{
+					"nodeName": nodeName,
+					"tag":      tag,
+					"status":   strconv.Itoa(status),
+					"resp":     string(resp),
+				},
+				err,
+			)
+		}
+		return inbound, nil
+	}
+
+	zlog.Error().Str("component", "apadana").Str("resource", "inbound").Str("action", "renew").Str("nodeName", nodeName).Str("tag", tag).Int("status", status).Str("resp", string(resp)).Msg("failed")
+
+	switch status {
+	case http.StatusNotFound:
+		return nil, errs.ErrInboundNotFound
+	case http.StatusConflict:
+		return nil, errs.ErrResourceVersionConflict
+	case http.StatusBadRequest:
+		return nil, errs.ErrInvalidExpiry
+	default:
+		return nil, errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"renew inbound failed",
+			map[string]string{
+				"nodeName": nodeName,
+				"tag":      tag,
+				"status":   strconv.Itoa(status),
+				"resp":     string(resp),
+			},
+			nil,
+		)
+	}
+}
+
+func (c *Client) RenewInboundUser(nodeName, tag, email string, ttl time.Duration, resourceVersion string) (*satrapv1.InboundUser, error) {
+	if nodeName == "" {
+		return nil, errs.ErrInvalidNode
+	}
+	if tag == "" {
+		return nil, errs.ErrInvalidInbound
+	}
+	if email == "" {
+		return nil, errs.ErrInvalidUser
+	}
+	url := withResourceVersion(fmt.Sprintf("%s/api/v1/nodes/%s/inbounds/%s/users/%s/renew", c.address, nodeName, tag, email), resourceVersion)
+	status, resp, err := c.httpClient.Do(http.MethodPost, url, c.token, &satrapv1.RenewRequest{TTL: ttl})
+	if err != nil {
+		zlog.Error().Err(err).Str("component", "client").Str("resource", "inboundUser").Str("action", "renew").Str("nodeName", nodeName).Str("tag", tag).Str("email", email).Msg("failed")
+		return nil, err
+	}
+
+	if status == http.StatusOK {
+		user := &satrapv1.InboundUser{}
+		if err := json.Unmarshal(resp, user); err != nil {
+			return nil, errs.New(
+				errs.KindInternal,
+				errs.ReasonUnmarshalFailed,
+				"inbound user unmarshal failed",
+				map[string]string{
+					"nodeName": nodeName,
+					"tag":      tag,
+					"email":    email,
+					"status":   strconv.Itoa(status),
+					"resp":     string(resp),
+				},
+				err,
+			)
+		}
+		return user, nil
+	}
+
+	zlog.Error().Str("component", "apadana").Str("resource", "inboundUser").Str("action", "renew").Str("nodeName", nodeName).Str("tag", tag).Str("email", email).Int("status", status).Str("resp", string(resp)).Msg("failed")
+
+	switch status {
+	case http.StatusNotFound:
+		return nil, errs.ErrUserNotFound
+	case http.StatusConflict:
+		return nil, errs.ErrResourceVersionConflict
+	case http.StatusBadRequest:
+		return nil, errs.ErrInvalidExpiry
+	default:
+		return nil, errs.New(
+			errs.KindInternal,
+			errs.ReasonUnknown,
+			"renew inbound user failed",
+			map[string]string{
+				"nodeName": nodeName,
+				"tag":      tag,
+				"email":    email,
+				"status":   strconv.Itoa(status),
+				"resp":     string(resp),
+			},
+			nil,
+		)
+	}
+}
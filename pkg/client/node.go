@@ -3,6 +3,7 @@ package client
 import (
 	"encoding/json"
 	"fmt"
+	"iter"
 	"net/http"
 	"strconv"
 
@@ -13,11 +14,13 @@ import (
 	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
 )
 
-func (c *Client) UpdateNodeStatus(nodeName string, nodeStatus *corev1.NodeStatus) error {
+// UpdateNodeStatus replaces the status of a node. If resourceVersion is not
+// empty the update only succeeds while the node is still at that version.
+func (c *Client) UpdateNodeStatus(nodeName string, nodeStatus *corev1.NodeStatus, resourceVersion string) error {
 	if nodeName == "" {
 		return errs.ErrInvalidNode
 	}
-	url := fmt.Sprintf("%s/api/v1/nodes/%s/status", c.address, nodeName)
+	url := withResourceVersion(fmt.Sprintf("%s/api/v1/nodes/%s/status", c.address, nodeName), resourceVersion)
 	status, resp, err := c.httpClient.Do(http.MethodPatch, url, c.token, nodeStatus)
 	if err != nil {
 		zlog.Error().Err(err).Str("component", "client").Str("resource", "node").Str("action", "update").Str("nodeName", nodeName).Msg("failed")
@@ -33,6 +36,8 @@ func (c *Client) UpdateNodeStatus(nodeName string, nodeStatus *corev1.NodeStatus
 	switch status {
 	case http.StatusNotFound:
 		return errs.ErrNodeNotFound
+	case http.StatusConflict:
+		return errs.ErrResourceVersionConflict
 	default:
 		return errs.New(
 			errs.KindInternal,
@@ -69,6 +74,8 @@ func (c *Client) UpdateNodeMetadata(nodeName string, nodeMetadata *metav1.Object
 	switch status {
 	case http.StatusNotFound:
 		return errs.ErrNodeNotFound
+	case http.StatusConflict:
+		return errs.ErrResourceVersionConflict
 	default:
 		return errs.New(
 			errs.KindInternal,
@@ -134,16 +141,34 @@ func (c *Client) GetNode(nodeName string) (*corev1.Node, error) {
 	}
 }
 
-func (c *Client) GetNodes() ([]*corev1.Node, error) {
-	url := fmt.Sprintf("%s/api/v1/nodes", c.address)
+// GetNodes returns every node matching labelSelector, fetching them page by
+// page. An empty selector returns all of them.
+func (c *Client) GetNodes(labelSelector string) ([]*corev1.Node, error) {
+	return collect(c.Nodes(metav1.ListOptions{LabelSelector: labelSelector}))
+}
+
+// Nodes iterates over all nodes, requesting the next page as the previous
+// one is consumed.
+func (c *Client) Nodes(opts metav1.ListOptions) iter.Seq2[*corev1.Node, error] {
+	return paginate(opts, func(opts metav1.ListOptions) ([]*corev1.Node, metav1.ListMeta, error) {
+		list, err := c.ListNodes(opts)
+		if err != nil {
+			return nil, metav1.ListMeta{}, err
+		}
+		return list.Items, list.Metadata, nil
+	})
+}
+
+func (c *Client) ListNodes(opts metav1.ListOptions) (*corev1.NodeList, error) {
+	url := withListOptions(fmt.Sprintf("%s/api/v1/nodes", c.address), opts)
 	status, resp, err := c.httpClient.Do(http.MethodGet, url, c.token, nil)
 	if err != nil {
 		zlog.Error().Err(err).Str("component", "client").Str("resource", "nodes").Str("action", "list").Msg("failed")
 		return nil, err
 	}
 	if status == http.StatusOK {
-		nodes := []*corev1.Node{}
-		if err := json.Unmarshal(resp, &nodes); err != nil {
+		nodes := &corev1.NodeList{}
+		if err := json.Unmarshal(resp, nodes); err != nil {
 			zlog.Error().Err(err).Str("component", "apadana").Str("resource", "nodes").Str("action", "list").Int("status", status).Str("resp", string(resp)).Msg("unmarshal failed")
 			return nil, errs.New(
 				errs.KindInternal,
@@ -159,6 +184,10 @@ func (c *Client) GetNodes() ([]*corev1.Node, error) {
 		return nodes, nil
 	}
 
+	if status == http.StatusGone {
+		return nil, errs.ErrResourceExpired
+	}
+
 	zlog.Error().Str("component", "apadana").Str("resource", "nodes").Str("action", "list").Int("status", status).Str("resp", string(resp)).Msg("failed")
 
 	return nil, errs.New(
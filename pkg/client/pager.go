@@ -0,0 +1,53 @@
+package client
+
+import (
+	"iter"
+
+	metav1 "github.com/vayzur/apadana/pkg/apis/meta/v1"
+)
+
+// defaultPageSize is used by the iterators when the caller does not set a limit.
+const defaultPageSize = 500
+
+type listFunc[T any] func(opts metav1.ListOptions) ([]T, metav1.ListMeta, error)
+
+// paginate walks a list endpoint page by page, following continue tokens
+// until the server reports no more items. Iteration stops at the first error.
+func paginate[T any](opts metav1.ListOptions, list listFunc[T]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		if opts.Limit == 0 {
+			opts.Limit = defaultPageSize
+		}
+
+		for {
+			items, meta, err := list(opts)
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			if meta.Continue == "" {
+				return
+			}
+			opts.Continue = meta.Continue
+		}
+	}
+}
+
+func collect[T any](seq iter.Seq2[T, error]) ([]T, error) {
+	items := []T{}
+	for item, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
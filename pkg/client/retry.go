@@ -0,0 +1,63 @@
+package client
+
+import (
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/vayzur/apadana/pkg/errs"
+)
+
+type Backoff struct {
+	Steps    int
+	Duration time.Duration
+	Factor   float64
+	Jitter   float64
+}
+
+// DefaultRetry is the recommended backoff for conflicts on objects that are
+// written concurrently by several components, such as node status.
+var DefaultRetry = Backoff{
+	Steps:    5,
+	Duration: 10 * time.Millisecond,
+	Factor:   1.0,
+	Jitter:   0.1,
+}
+
+func IsConflict(err error) bool {
+	return errors.Is(err, errs.ErrResourceVersionConflict)
+}
+
+// RetryOnConflict runs fn until it returns an error other than a resourceVersion
+// conflict, or backoff is exhausted. fn should re-read the object it updates on
+// every attempt, otherwise it keeps sending the same stale version.
+func RetryOnConflict(backoff Backoff, fn func() error) error {
+	return OnError(backoff, IsConflict, fn)
+}
+
+func OnError(backoff Backoff, retriable func(error) bool, fn func() error) error {
+	var err error
+	duration := backoff.Duration
+
+	for step := range backoff.Steps {
+		err = fn()
+		if err == nil || !retriable(err) {
+			return err
+		}
+		if step == backoff.Steps-1 {
+			break
+		}
+
+		sleep := duration
+		if backoff.Jitter > 0 {
+			sleep += time.Duration(rand.Float64() * backoff.Jitter * float64(duration))
+		}
+		time.Sleep(sleep)
+
+		if backoff.Factor > 0 {
+			duration = time.Duration(float64(duration) * backoff.Factor)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	chaparconfigv1 "github.com/vayzur/apadana/pkg/chapar/config/v1"
+)
+
+// WithTLS connects to chapar with cfg, see LoadTLSConfig.
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.httpClient.SetTLSConfig(cfg)
+	}
+}
+
+// LoadTLSConfig builds the TLS config of a client that verifies chapar with
+// the CA in caFile, or the system roots when it is empty, and presents the
+// certificate in certFile and keyFile when they are set. serverName, when set,
+// is what the certificate of chapar is checked against.
+func LoadTLSConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// ClusterOptions returns the options cfg asks for: its credential and, when
+// any TLS file or server name is set, its TLS config.
+func ClusterOptions(cfg *chaparconfigv1.ClusterConfig) ([]Option, error) {
+	opts := []Option{WithCredential(cfg.Credential)}
+
+	t := cfg.TLS
+	if t.CAFile != "" || t.CertFile != "" || t.KeyFile != "" || t.ServerName != "" {
+		tlsConfig, err := LoadTLSConfig(t.CAFile, t.CertFile, t.KeyFile, t.ServerName)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithTLS(tlsConfig))
+	}
+
+	return opts, nil
+}
@@ -13,6 +13,8 @@ const (
 	KindConflict         ErrorKind = "Conflict"
 	KindInternal         ErrorKind = "Internal"
 	KindCapacityExceeded ErrorKind = "CapacityExceeded"
+	KindExpired          ErrorKind = "Expired"
+	KindTooManyRequests  ErrorKind = "TooManyRequests"
 )
 
 const (
@@ -21,6 +23,7 @@ const (
 	ReasonMarshalFailed           ErrorReason = "MarshalFailed"
 	ReasonUnmarshalFailed         ErrorReason = "UnmarshalFailed"
 	ReasonNodeNotFound            ErrorReason = "NodeNotFound"
+	ReasonNodeConflict            ErrorReason = "NodeConflict"
 	ReasonInboundConflict         ErrorReason = "InboundConflict"
 	ReasonInboundNotFound         ErrorReason = "InboundNotFound"
 	ReasonUserConflict            ErrorReason = "UserConflict"
@@ -28,6 +31,26 @@ const (
 	ReasonNodeCapacityExceeded    ErrorReason = "NodeCapacityExceeded"
 	ReasonInboundCapacityExceeded ErrorReason = "InboundCapacityExceeded"
 	ReasonResourceNotFound        ErrorReason = "ResourceNotFound"
+	ReasonResourceVersionConflict ErrorReason = "ResourceVersionConflict"
+	ReasonInvalidResourceVersion  ErrorReason = "InvalidResourceVersion"
+	ReasonResourceExpired         ErrorReason = "ResourceExpired"
+	ReasonResourceExists          ErrorReason = "ResourceExists"
+	ReasonInvalidContinue         ErrorReason = "InvalidContinue"
+	ReasonInvalidLimit            ErrorReason = "InvalidLimit"
+	ReasonInvalidExpiry           ErrorReason = "InvalidExpiry"
+	ReasonInvalidLabelSelector    ErrorReason = "InvalidLabelSelector"
+	ReasonInvalidBatch            ErrorReason = "InvalidBatch"
+	ReasonValidationFailed        ErrorReason = "ValidationFailed"
+	ReasonPortConflict            ErrorReason = "PortConflict"
+	ReasonPortsExhausted          ErrorReason = "PortsExhausted"
+	ReasonCredentialNotFound      ErrorReason = "CredentialNotFound"
+	ReasonCredentialConflict      ErrorReason = "CredentialConflict"
+	ReasonAuditDisabled           ErrorReason = "AuditDisabled"
+	ReasonInvalidTimeRange        ErrorReason = "InvalidTimeRange"
+	ReasonRateLimited             ErrorReason = "RateLimited"
+	ReasonLockedOut               ErrorReason = "LockedOut"
+	ReasonBootstrapTokenRejected  ErrorReason = "BootstrapTokenRejected"
+	ReasonLeaseNotFound           ErrorReason = "LeaseNotFound"
 )
 
 type Error struct {
@@ -40,6 +63,7 @@ type Error struct {
 
 var (
 	ErrNodeNotFound            = &Error{Kind: KindNotFound, Reason: ReasonNodeNotFound, Message: "node not found"}
+	ErrNodeConflict            = &Error{Kind: KindConflict, Reason: ReasonNodeConflict, Message: "node or its credential already exists"}
 	ErrInboundConflict         = &Error{Kind: KindConflict, Reason: ReasonInboundConflict, Message: "inbound already exists"}
 	ErrInboundNotFound         = &Error{Kind: KindNotFound, Reason: ReasonInboundNotFound, Message: "inbound not found"}
 	ErrUserConflict            = &Error{Kind: KindConflict, Reason: ReasonUserConflict, Message: "user already exists"}
@@ -50,6 +74,24 @@ var (
 	ErrInvalidInbound          = &Error{Kind: KindInvalid, Reason: ReasonMissingParam, Message: "tag cannot be empty"}
 	ErrInvalidUser             = &Error{Kind: KindInvalid, Reason: ReasonMissingParam, Message: "email cannot be empty"}
 	ErrResourceNotFound        = &Error{Kind: KindNotFound, Reason: ReasonResourceNotFound, Message: "resource not found"}
+	ErrResourceVersionConflict = &Error{Kind: KindConflict, Reason: ReasonResourceVersionConflict, Message: "the object has been modified; please apply your changes to the latest version and try again"}
+	ErrInvalidResourceVersion  = &Error{Kind: KindInvalid, Reason: ReasonInvalidResourceVersion, Message: "invalid resourceVersion"}
+	ErrResourceExpired         = &Error{Kind: KindExpired, Reason: ReasonResourceExpired, Message: "requested resourceVersion is too old"}
+	ErrResourceExists          = &Error{Kind: KindConflict, Reason: ReasonResourceExists, Message: "resource already exists"}
+	ErrInvalidContinue         = &Error{Kind: KindInvalid, Reason: ReasonInvalidContinue, Message: "invalid continue token"}
+	ErrInvalidLimit            = &Error{Kind: KindInvalid, Reason: ReasonInvalidLimit, Message: "limit must be a non-negative integer"}
+	ErrInvalidExpiry           = &Error{Kind: KindInvalid, Reason: ReasonInvalidExpiry, Message: "ttl must be positive and expiresAt in the future"}
+	ErrInvalidBatchAction      = &Error{Kind: KindInvalid, Reason: ReasonInvalidBatch, Message: "action must be one of create, update or delete"}
+	ErrBatchTooLarge           = &Error{Kind: KindInvalid, Reason: ReasonInvalidBatch, Message: "too many items in batch"}
+	ErrCredentialNotFound      = &Error{Kind: KindNotFound, Reason: ReasonCredentialNotFound, Message: "credential not found"}
+	ErrCredentialConflict      = &Error{Kind: KindConflict, Reason: ReasonCredentialConflict, Message: "credential already exists"}
+	ErrInvalidCredential       = &Error{Kind: KindInvalid, Reason: ReasonMissingParam, Message: "credential name cannot be empty"}
+	ErrAuditDisabled           = &Error{Kind: KindNotFound, Reason: ReasonAuditDisabled, Message: "audit events are not stored"}
+	ErrInvalidTimeRange        = &Error{Kind: KindInvalid, Reason: ReasonInvalidTimeRange, Message: "since and until must be RFC 3339 times, since before until"}
+	ErrRateLimited             = &Error{Kind: KindTooManyRequests, Reason: ReasonRateLimited, Message: "too many requests"}
+	ErrLockedOut               = &Error{Kind: KindTooManyRequests, Reason: ReasonLockedOut, Message: "too many failed authentications"}
+	ErrLeaseNotFound           = &Error{Kind: KindNotFound, Reason: ReasonLeaseNotFound, Message: "lease not found"}
+	ErrBootstrapTokenRejected  = &Error{Kind: KindInvalid, Reason: ReasonBootstrapTokenRejected, Message: "bootstrap token rejected; the node needs a new bootstrap token to join"}
 )
 
 func (e *Error) Error() string {
@@ -40,31 +40,30 @@ func HandleXrayError(err error, resourceType satrapv1.Resource) error {
 }
 
 func HandleAPIError(c fiber.Ctx, err error) error {
+	return c.Status(StatusCode(err)).JSON(fiber.Map{
+		"error": err,
+	})
+}
+
+// StatusCode returns the HTTP status err is answered with.
+func StatusCode(err error) int {
 	e, ok := err.(*Error)
 	if !ok {
-		e = &Error{
-			Kind:    KindInternal,
-			Reason:  ReasonUnknown,
-			Message: err.Error(),
-		}
+		return fiber.StatusInternalServerError
 	}
 
-	var status int
-
 	switch e.Kind {
 	case KindNotFound:
-		status = fiber.StatusNotFound
+		return fiber.StatusNotFound
 	case KindConflict:
-		status = fiber.StatusConflict
-	case KindCapacityExceeded:
-		status = fiber.StatusTooManyRequests
+		return fiber.StatusConflict
+	case KindCapacityExceeded, KindTooManyRequests:
+		return fiber.StatusTooManyRequests
 	case KindInvalid:
-		status = fiber.StatusBadRequest
+		return fiber.StatusBadRequest
+	case KindExpired:
+		return fiber.StatusGone
 	default:
-		status = fiber.StatusInternalServerError
+		return fiber.StatusInternalServerError
 	}
-
-	return c.Status(status).JSON(fiber.Map{
-		"error": err,
-	})
 }
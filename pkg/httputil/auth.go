@@ -1,17 +1,15 @@
 package httputil
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
-	"fmt"
-	"time"
+	"github.com/vayzur/apadana/pkg/chapar/authentication"
 )
 
-func buildHMACHeader(token string) string {
-	ts := time.Now().Unix()
-	mac := hmac.New(sha256.New, []byte(token))
-	fmt.Fprintf(mac, "%d", ts)
-	sig := hex.EncodeToString(mac.Sum(nil))
-	return fmt.Sprintf("hmac %d:%s", ts, sig)
+// buildHMACHeader signs the request with token. A credential name is sent
+// along for chapar to look the token up by.
+func buildHMACHeader(credential, token, method, uri string, body []byte) (string, error) {
+	return authentication.SignRequest(credential, token, &authentication.Request{
+		Method: method,
+		URI:    uri,
+		Body:   body,
+	})
 }
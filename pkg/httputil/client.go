@@ -2,6 +2,7 @@ package httputil
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,7 +11,8 @@ import (
 )
 
 type Client struct {
-	client *http.Client
+	client     *http.Client
+	credential string
 }
 
 func New(timeout time.Duration) *Client {
@@ -19,6 +21,21 @@ func New(timeout time.Duration) *Client {
 	}
 }
 
+// SetCredential names the credential the token passed to Do belongs to.
+func (c *Client) SetCredential(name string) {
+	c.credential = name
+}
+
+// SetTLSConfig makes the client connect with cfg, to verify the server
+// against a custom CA or present a client certificate.
+func (c *Client) SetTLSConfig(cfg *tls.Config) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	c.client.Transport = transport
+}
+
+// Do sends the request signed with token. An empty token sends it unsigned,
+// for a client certificate to authenticate.
 func (c *Client) Do(method, url, token string, body any) (int, []byte, error) {
 	var requestBody []byte
 	var err error
@@ -36,7 +53,13 @@ func (c *Client) Do(method, url, token string, body any) (int, []byte, error) {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", buildHMACHeader(token))
+	if token != "" {
+		auth, err := buildHMACHeader(c.credential, token, method, req.URL.RequestURI(), requestBody)
+		if err != nil {
+			return 0, nil, fmt.Errorf("sign error: %w", err)
+		}
+		req.Header.Set("Authorization", auth)
+	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -0,0 +1,220 @@
+package labels
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type Operator string
+
+const (
+	Equals       Operator = "="
+	NotEquals    Operator = "!="
+	In           Operator = "in"
+	NotIn        Operator = "notin"
+	Exists       Operator = "exists"
+	DoesNotExist Operator = "!"
+)
+
+// Requirement is a single condition on the value of one label.
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+func (r Requirement) Matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+
+	switch r.Operator {
+	case Equals:
+		return ok && value == r.Values[0]
+	case NotEquals:
+		return !ok || value != r.Values[0]
+	case In:
+		return ok && slices.Contains(r.Values, value)
+	case NotIn:
+		return !ok || !slices.Contains(r.Values, value)
+	case Exists:
+		return ok
+	case DoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+func (r Requirement) String() string {
+	switch r.Operator {
+	case Equals, NotEquals:
+		return r.Key + string(r.Operator) + r.Values[0]
+	case In, NotIn:
+		return fmt.Sprintf("%s %s (%s)", r.Key, r.Operator, strings.Join(r.Values, ","))
+	case DoesNotExist:
+		return "!" + r.Key
+	}
+	return r.Key
+}
+
+// Selector matches label sets that satisfy every one of its requirements.
+// An empty selector matches everything.
+type Selector []Requirement
+
+// SelectorFromSet returns a selector requiring every key=value pair of set.
+func SelectorFromSet(set map[string]string) Selector {
+	sel := make(Selector, 0, len(set))
+	for key, value := range set {
+		sel = append(sel, Requirement{Key: key, Operator: Equals, Values: []string{value}})
+	}
+	slices.SortFunc(sel, func(a, b Requirement) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return sel
+}
+
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Selector) Empty() bool {
+	return len(s) == 0
+}
+
+// String returns the selector in the syntax accepted by Parse.
+func (s Selector) String() string {
+	reqs := make([]string, len(s))
+	for i, r := range s {
+		reqs[i] = r.String()
+	}
+	return strings.Join(reqs, ",")
+}
+
+// Parse reads a comma separated list of requirements in the Kubernetes label
+// selector syntax:
+//
+//	key=value, key==value, key!=value
+//	key in (v1,v2), key notin (v1,v2)
+//	key, !key
+//
+// An empty string selects everything.
+func Parse(s string) (Selector, error) {
+	sel := Selector{}
+	rest := strings.TrimSpace(s)
+
+	for rest != "" {
+		req, tail, err := parseRequirement(rest)
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, req)
+
+		tail = strings.TrimSpace(tail)
+		if tail == "" {
+			break
+		}
+		if tail[0] != ',' {
+			return nil, fmt.Errorf("expected ',' before %q", tail)
+		}
+		rest = strings.TrimSpace(tail[1:])
+		if rest == "" {
+			return nil, fmt.Errorf("trailing ',' in %q", s)
+		}
+	}
+
+	return sel, nil
+}
+
+// parseRequirement reads one requirement from the start of s and returns it
+// with what follows it.
+func parseRequirement(s string) (Requirement, string, error) {
+	if strings.HasPrefix(s, "!") {
+		key, tail := token(strings.TrimSpace(s[1:]))
+		if err := validateKey(key); err != nil {
+			return Requirement{}, "", err
+		}
+		return Requirement{Key: key, Operator: DoesNotExist}, tail, nil
+	}
+
+	key, tail := token(s)
+	if err := validateKey(key); err != nil {
+		return Requirement{}, "", err
+	}
+	tail = strings.TrimLeft(tail, " ")
+
+	switch {
+	case tail == "" || tail[0] == ',':
+		return Requirement{Key: key, Operator: Exists}, tail, nil
+
+	case strings.HasPrefix(tail, "!="), strings.HasPrefix(tail, "=="), strings.HasPrefix(tail, "="):
+		op := Equals
+		if tail[0] == '!' {
+			op = NotEquals
+		}
+		tail = strings.TrimPrefix(strings.TrimPrefix(tail, string(op)), "=")
+		value, tail := token(strings.TrimLeft(tail, " "))
+		if err := validateValue(value); err != nil {
+			return Requirement{}, "", err
+		}
+		return Requirement{Key: key, Operator: op, Values: []string{value}}, tail, nil
+	}
+
+	word, tail := token(tail)
+	op := Operator(word)
+	if op != In && op != NotIn {
+		return Requirement{}, "", fmt.Errorf("unknown operator %q for key %q", word, key)
+	}
+
+	tail = strings.TrimLeft(tail, " ")
+	if !strings.HasPrefix(tail, "(") {
+		return Requirement{}, "", fmt.Errorf("expected '(' after %q", key+" "+word)
+	}
+	list, tail, ok := strings.Cut(tail[1:], ")")
+	if !ok {
+		return Requirement{}, "", fmt.Errorf("missing ')' after %q", key+" "+word)
+	}
+
+	values := []string{}
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if err := validateValue(v); err != nil {
+			return Requirement{}, "", err
+		}
+		values = append(values, v)
+	}
+	return Requirement{Key: key, Operator: op, Values: values}, tail, nil
+}
+
+// token splits s after its leading run of label characters.
+func token(s string) (string, string) {
+	i := strings.IndexFunc(s, func(r rune) bool { return !isLabelChar(r) })
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
+
+func isLabelChar(r rune) bool {
+	return r >= 'a' && r <= 'z' ||
+		r >= 'A' && r <= 'Z' ||
+		r >= '0' && r <= '9' ||
+		r == '-' || r == '_' || r == '.' || r == '/'
+}
+
+func validateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("missing label key")
+	}
+	return nil
+}
+
+func validateValue(value string) error {
+	if strings.Contains(value, "/") {
+		return fmt.Errorf("invalid label value %q", value)
+	}
+	return nil
+}
@@ -23,6 +23,20 @@ type SatrapConfig struct {
 	ConcurrentUserSyncs       uint32                       `mapstructure:"concurrentUserSyncs" yaml:"concurrentUserSyncs"`
 	ConcurrentUserGCSyncs     uint32                       `mapstructure:"concurrentUserGCSyncs" yaml:"concurrentUserGCSyncs"`
 	MaxInbounds               uint32                       `mapstructure:"maxInbounds" yaml:"maxInbounds"`
+	// BootstrapToken, as "<name>.<token>", joins the node to the cluster when
+	// CredentialFile holds no credential yet. The credential chapar issues in
+	// exchange is written to CredentialFile and used from then on.
+	BootstrapToken string `mapstructure:"bootstrapToken" yaml:"bootstrapToken"`
+	CredentialFile string `mapstructure:"credentialFile" yaml:"credentialFile"`
+}
+
+const DefaultCredentialFile = "/var/lib/satrap/credential.json"
+
+func (c *SatrapConfig) GetCredentialFile() string {
+	if c.CredentialFile != "" {
+		return c.CredentialFile
+	}
+	return DefaultCredentialFile
 }
 
 func (c *SatrapConfig) GetName() string {
@@ -40,7 +40,7 @@ func (h *HeartbeatManager) Run(ctx context.Context, nodeName string) {
 		case <-ticker.C:
 			h.nodeStatus.LastHeartbeatTime = time.Now()
 
-			if err := h.apadanaClient.UpdateNodeStatus(nodeName, h.nodeStatus); err != nil {
+			if err := h.apadanaClient.UpdateNodeStatus(nodeName, h.nodeStatus, ""); err != nil {
 				zlog.Error().Err(err).Str("component", "heartbeatManager").Str("resource", "node").Str("action", "update").Str("nodeName", nodeName).Msg("failed")
 				continue
 			}
@@ -0,0 +1,75 @@
+package register
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	authv1 "github.com/vayzur/apadana/pkg/apis/auth/v1"
+)
+
+// ParseBootstrapToken splits a bootstrap token written as "<name>.<token>".
+func ParseBootstrapToken(s string) (string, string, error) {
+	i := strings.LastIndex(s, ".")
+	if i <= 0 || i == len(s)-1 {
+		return "", "", fmt.Errorf("bootstrap token is not in the form <name>.<token>")
+	}
+	return s[:i], s[i+1:], nil
+}
+
+// LoadCredential reads the credential saved at path. It returns nil and no
+// error when there is none yet.
+func LoadCredential(path string) (*authv1.Credential, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	credential := &authv1.Credential{}
+	if err := json.Unmarshal(data, credential); err != nil {
+		return nil, fmt.Errorf("invalid credential file %s: %w", path, err)
+	}
+	if credential.Metadata.Name == "" || credential.Spec.Token == "" {
+		return nil, fmt.Errorf("invalid credential file %s: name and token are required", path)
+	}
+	return credential, nil
+}
+
+// SaveCredential writes credential to path, readable by its owner only. The
+// file is replaced atomically so that a crash never leaves half of it.
+func SaveCredential(path string, credential *authv1.Credential) error {
+	data, err := json.MarshalIndent(credential, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, ".credential-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
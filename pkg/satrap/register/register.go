@@ -2,12 +2,14 @@ package register
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	corev1 "github.com/vayzur/apadana/pkg/apis/core/v1"
 
 	zlog "github.com/rs/zerolog/log"
 	apadana "github.com/vayzur/apadana/pkg/client"
+	"github.com/vayzur/apadana/pkg/errs"
 )
 
 type RegisterManager struct {
@@ -45,3 +47,44 @@ func (r *RegisterManager) RegisterWithAPIServer(ctx context.Context, node *corev
 		}
 	}
 }
+
+// JoinWithAPIServer registers node with the bootstrap token the client signs
+// with, saves the credential it is issued to credentialFile and switches the
+// client over to it. It blocks until the node has joined, or fails with
+// errs.ErrBootstrapTokenRejected once the token is refused, as retrying
+// cannot help a token that is expired, revoked or spent.
+func (r *RegisterManager) JoinWithAPIServer(ctx context.Context, node *corev1.Node, credentialFile string) error {
+	step := 100 * time.Millisecond
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(step):
+			step = step * 2
+			if step >= 7*time.Second {
+				step = 7 * time.Second
+			}
+
+			zlog.Info().Str("component", "registerManager").Str("nodeName", node.Metadata.Name).Msg("attempting to join cluster")
+			join, err := r.apadanaClient.JoinNode(node)
+			if errors.Is(err, errs.ErrBootstrapTokenRejected) {
+				return err
+			}
+			if err != nil {
+				continue
+			}
+
+			credential := join.Credential
+			if err := SaveCredential(credentialFile, credential); err != nil {
+				// the bootstrap token is spent; without the file the node
+				// has to be given a new one on the next start
+				zlog.Error().Err(err).Str("component", "registerManager").Str("path", credentialFile).Msg("failed to save credential")
+			}
+			r.apadanaClient.SetCredential(credential.Metadata.Name, credential.Spec.Token)
+
+			zlog.Info().Str("component", "registerManager").Str("nodeName", node.Metadata.Name).Str("credential", credential.Metadata.Name).Msg("successfully joined cluster")
+			return nil
+		}
+	}
+}
@@ -26,7 +26,7 @@ func (m *SyncManager) Run(ctx context.Context, nodeName string) {
 					continue
 				}
 
-				desiredUsers, err := m.apadanaClient.GetInboundUsers(nodeName, inb.Spec.Config.Tag)
+				desiredUsers, err := m.apadanaClient.GetInboundUsers(nodeName, inb.Spec.Config.Tag, "")
 				if err != nil {
 					continue
 				}
@@ -102,7 +102,7 @@ func (m *SyncManager) Run(ctx context.Context, nodeName string) {
 			return
 
 		case <-ticker.C:
-			desiredInbounds, err := m.apadanaClient.GetInbounds(nodeName)
+			desiredInbounds, err := m.apadanaClient.GetInbounds(nodeName, "")
 			if err != nil {
 				zlog.Error().Err(err).Str("component", "syncManager").Str("nodeName", nodeName).
 					Msg("failed to get desired inbounds")
@@ -134,7 +134,7 @@ func (m *SyncManager) Run(ctx context.Context, nodeName string) {
 						continue
 					}
 
-					desiredUsers, err := m.apadanaClient.GetInboundUsers(nodeName, inbound.Spec.Config.Tag)
+					desiredUsers, err := m.apadanaClient.GetInboundUsers(nodeName, inbound.Spec.Config.Tag, "")
 					if err != nil {
 						continue
 					}
@@ -6,6 +6,7 @@ import (
 
 	zlog "github.com/rs/zerolog/log"
 	corev1 "github.com/vayzur/apadana/pkg/apis/core/v1"
+	apadana "github.com/vayzur/apadana/pkg/client"
 )
 
 func (c *Spasaka) RunNodeMonitor(ctx context.Context, concurrentNodeSyncs int, nodeMonitorPeriod, nodeMonitorGracePeriod time.Duration) {
@@ -14,14 +15,29 @@ func (c *Spasaka) RunNodeMonitor(ctx context.Context, concurrentNodeSyncs int, n
 	for range concurrentNodeSyncs {
 		go func() {
 			for node := range nodesCh {
-				if time.Since(node.Status.LastHeartbeatTime) >= nodeMonitorGracePeriod {
+				// the status write is conditioned on the version we judged, so a
+				// heartbeat landing in between is never overwritten with Ready=false
+				err := apadana.RetryOnConflict(apadana.DefaultRetry, func() error {
+					if !node.Status.Ready || time.Since(node.Status.LastHeartbeatTime) < nodeMonitorGracePeriod {
+						return nil
+					}
+
 					node.Status.Ready = false
-					if err := c.apadanaClient.UpdateNodeStatus(node.Metadata.Name, &node.Status); err != nil {
-						if ctx.Err() != nil {
-							return
+					err := c.apadanaClient.UpdateNodeStatus(node.Metadata.Name, &node.Status, node.Metadata.ResourceVersion)
+					if apadana.IsConflict(err) {
+						latest, getErr := c.apadanaClient.GetNode(node.Metadata.Name)
+						if getErr != nil {
+							return getErr
 						}
-						continue
+						node = latest
+					}
+					return err
+				})
+				if err != nil {
+					if ctx.Err() != nil {
+						return
 					}
+					continue
 				}
 			}
 		}()